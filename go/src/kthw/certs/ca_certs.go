@@ -3,19 +3,26 @@ package certs
 import (
 	"fmt"
 	"io/ioutil"
+	"kthw/secrets"
+	"os"
 	"path"
 
 	"github.com/cloudflare/cfssl/csr"
 	"github.com/cloudflare/cfssl/initca"
 )
 
-// CACerts stores CA configuration and manages certificates.
+// CACerts stores CA configuration and manages certificates. CA is the root
+// CA, which only signs the IntermediateCAs and never leaves the local machine.
+// If Sealer is set, the private keys of all CAs are encrypted on disk.
 type CACerts struct {
-	CAKeySize int
-	CABaseDir string
-	cACsr     *csr.CertificateRequest
-	cAConf    *csr.CAConfig
-	CA        *CA
+	CAKeyAlgo       string
+	CAKeySize       int
+	CABaseDir       string
+	cACsr           *csr.CertificateRequest
+	cAConf          *csr.CAConfig
+	CA              *CA
+	IntermediateCAs IntermediateCAs
+	Sealer          *secrets.Sealer
 }
 
 // CA holds the certificates used to generate SSL certificates.
@@ -30,25 +37,27 @@ func (c *CACerts) CNPrivateKeyFile() string { return path.Join(c.CABaseDir, caKe
 // CNPublicKeyFile returns the path to CA private key PEM file.
 func (c *CACerts) CNPublicKeyFile() string { return path.Join(c.CABaseDir, caCertFileName) }
 
-// DefaultCACerts initializes Certs with default parameters.
-func DefaultCACerts(baseDir string) *CACerts {
-	caConf := &csr.CAConfig{PathLength: 0, PathLenZero: true, Expiry: signingExpiry}
+// DefaultCACerts initializes Certs with key algorithm, expiry and names of
+// certsConf. The CA is stored in the base dir of certsConf.
+func DefaultCACerts(certsConf Config) *CACerts {
+	caConf := &csr.CAConfig{PathLength: 1, Expiry: certsConf.CAExpiry}
 	return &CACerts{
-		CAKeySize: keySize,
-		CABaseDir: baseDir,
+		CAKeyAlgo: certsConf.KeyAlgo,
+		CAKeySize: certsConf.KeySize,
+		CABaseDir: certsConf.BaseDir,
 		cAConf:    caConf,
 		cACsr: &csr.CertificateRequest{
 			CN:         caCN,
 			CA:         caConf,
-			KeyRequest: &csr.BasicKeyRequest{A: keyAlgo, S: keySize},
-			Names:      []csr.Name{certName(caCN)}}}
+			KeyRequest: certsConf.keyRequest(),
+			Names:      []csr.Name{certsConf.certName()}}}
 }
 
 // LoadCACerts creats a CACerts and load the certificates from disk.
 // InitCA must be called previously.
-func LoadCACerts(baseDir string) (*CACerts, error) {
+func LoadCACerts(certsConf Config) (*CACerts, error) {
 
-	caCerts := DefaultCACerts(baseDir)
+	caCerts := DefaultCACerts(certsConf)
 	err := caCerts.LoadCA()
 	if err != nil {
 		return nil, fmt.Errorf("Error while loading CA from disk. Did you call InitCA before? %s", err)
@@ -66,39 +75,124 @@ func (c *CACerts) generateCA() error {
 	return nil
 }
 
-// InitCa generates the CA public and private key and stores both in PEM
-// format in directory 'ca' relative to working directory.
+// InitCa generates the root CA and all intermediate CAs and stores their
+// public and private keys in PEM format in the CA base dir.
 func (c *CACerts) InitCa() error {
-	c.generateCA()
-	err := ensureDirectoryExists(c.CABaseDir)
+	err := c.generateCA()
+	if err != nil {
+		return err
+	}
+	err = ensureDirectoryExists(c.CABaseDir)
 	if err != nil {
 		return fmt.Errorf("Error while ensuring CA directories: %s", err)
 	}
 
-	err = writeToFile(c.CA.KeyBytes, c.CNPrivateKeyFile())
+	err = c.writeCA(c.CA, c.CNPublicKeyFile(), c.CNPrivateKeyFile())
 	if err != nil {
-		return fmt.Errorf("Writing CA private key to file failed: %s", err)
+		return err
 	}
 
-	err = writeToFile(c.CA.CertBytes, c.CNPublicKeyFile())
+	return c.InitIntermediateCAs()
+}
+
+// LoadCA loads private and public keys of the root CA from files. An encrypted
+// private key is decrypted in memory using Sealer.
+func (c *CACerts) LoadCA() error {
+	ca, err := c.readCA(c.CNPublicKeyFile(), c.CNPrivateKeyFile())
 	if err != nil {
-		return fmt.Errorf("Writing CA private key to file failed: %s", err)
+		return err
+	}
+	c.CA = ca
+	return nil
+}
+
+// SealPrivateKey encrypts existing private keys of the root CA and all
+// intermediate CAs stored in plain text using Sealer. Private keys which
+// are already encrypted stay unchanged.
+func (c *CACerts) SealPrivateKey() error {
+	if c.Sealer == nil {
+		return fmt.Errorf("Could not encrypt CA private key. Set %s or configure a key file", secrets.PassphraseEnv)
 	}
 
+	keyFiles := []string{c.CNPrivateKeyFile()}
+	for _, name := range intermediateCANames {
+		keyFiles = append(keyFiles, c.IntermediatePrivateKeyFile(name))
+	}
+	for _, keyFile := range keyFiles {
+		err := c.sealPrivateKeyFile(keyFile)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// LoadCA loads private and public keys of CA from files.
-func (c *CACerts) LoadCA() error {
-	keyBytes, err := ioutil.ReadFile(c.CNPrivateKeyFile())
+func (c *CACerts) sealPrivateKeyFile(keyFile string) error {
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return fmt.Errorf("Error reading private key file '%s': %s", keyFile, err)
+	}
+	if secrets.IsSealed(keyBytes) {
+		return nil
+	}
+
+	sealedBytes, err := c.sealPrivateKey(keyBytes)
 	if err != nil {
-		return fmt.Errorf("Error reading private key file '%s': %s", c.CNPrivateKeyFile(), err)
+		return err
 	}
-	certBytes, err := ioutil.ReadFile(c.CNPublicKeyFile())
+	err = ioutil.WriteFile(keyFile, sealedBytes, privateKeyFileMode)
 	if err != nil {
-		return fmt.Errorf("Error reading public key file '%s': %s", c.CNPublicKeyFile(), err)
+		return fmt.Errorf("Writing CA private key to file failed: %s", err)
 	}
-	c.CA = &CA{KeyBytes: keyBytes, CertBytes: certBytes}
+	return os.Chmod(keyFile, privateKeyFileMode)
+}
 
+func (c *CACerts) sealPrivateKey(keyBytes []byte) ([]byte, error) {
+	if c.Sealer == nil {
+		return keyBytes, nil
+	}
+	sealed, err := c.Sealer.Seal(keyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error encrypting CA private key: %s", err)
+	}
+	return []byte(sealed + "\n"), nil
+}
+
+func (c *CACerts) writeCA(ca *CA, certFile string, keyFile string) error {
+	keyBytes, err := c.sealPrivateKey(ca.KeyBytes)
+	if err != nil {
+		return err
+	}
+
+	err = writeToFile(keyBytes, keyFile, privateKeyFileMode)
+	if err != nil {
+		return fmt.Errorf("Writing CA private key to file failed: %s", err)
+	}
+
+	err = writeToFile(ca.CertBytes, certFile, publicKeyFileMode)
+	if err != nil {
+		return fmt.Errorf("Writing CA public key to file failed: %s", err)
+	}
 	return nil
 }
+
+func (c *CACerts) readCA(certFile string, keyFile string) (*CA, error) {
+	keyBytes, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading private key file '%s': %s", keyFile, err)
+	}
+	certBytes, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading public key file '%s': %s", certFile, err)
+	}
+	if secrets.IsSealed(keyBytes) {
+		if c.Sealer == nil {
+			return nil, fmt.Errorf("CA private key '%s' is encrypted. Set %s or configure a key file", keyFile, secrets.PassphraseEnv)
+		}
+		keyBytes, err = c.Sealer.Open(keyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error decrypting CA private key '%s': %s", keyFile, err)
+		}
+	}
+	return &CA{KeyBytes: keyBytes, CertBytes: certBytes}, nil
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/rsa"
 	"io/ioutil"
 	"kthw/certs"
+	"kthw/secrets"
+	"os"
 	"testing"
 
 	"github.com/cloudflare/cfssl/helpers"
@@ -109,7 +111,9 @@ func helperCreateDefaultCACerts(t *testing.T) (*certs.CACerts, string) {
 	tempDirName, err := ioutil.TempDir("", "InitCA")
 	helperFailIfErr(t, "Error creating temp dir: %s", err)
 
-	defaultCaCerts := certs.DefaultCACerts(tempDirName)
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = tempDirName
+	defaultCaCerts := certs.DefaultCACerts(certsConf)
 
 	return defaultCaCerts, tempDirName
 }
@@ -135,3 +139,54 @@ func helperEnsureCaCertsInitialized(t *testing.T, caCerts *certs.CACerts) {
 		t.Fatal("CACerts not initialized")
 	}
 }
+
+func TestInitAndLoadSealedCA(t *testing.T) {
+	defaultCaCerts, tempDirName := helperCreateDefaultCACerts(t)
+	sealer, err := secrets.NewPassphraseSealer("passphrase")
+	helperFailIfErr(t, "Error creating sealer: %s", err)
+	defaultCaCerts.Sealer = sealer
+	err = defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	keyFileBytes, err := ioutil.ReadFile(defaultCaCerts.CNPrivateKeyFile())
+	helperFailIfErr(t, "Failed reading private key: %s", err)
+	if !secrets.IsSealed(keyFileBytes) {
+		t.Fatal("CA private key was written in plain text")
+	}
+
+	loadedCaCerts, _ := helperCreateDefaultCACerts(t)
+	loadedCaCerts.CABaseDir = tempDirName
+	err = loadedCaCerts.LoadCA()
+	if err == nil {
+		t.Fatal("Loading an encrypted CA private key without sealer must fail")
+	}
+
+	loadedCaCerts.Sealer = sealer
+	err = loadedCaCerts.LoadCA()
+	helperFailIfErr(t, "Error loading encrypted CA: %s", err)
+	if !bytes.Equal(defaultCaCerts.CA.KeyBytes, loadedCaCerts.CA.KeyBytes) {
+		t.Fatal("Decrypted private key differs from private key generated")
+	}
+}
+
+func TestSealPrivateKeyOfExistingCA(t *testing.T) {
+	defaultCaCerts, _ := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	sealer, _ := secrets.NewPassphraseSealer("passphrase")
+	defaultCaCerts.Sealer = sealer
+	err = defaultCaCerts.SealPrivateKey()
+	helperFailIfErr(t, "Error encrypting CA private key: %s", err)
+
+	keyFileBytes, err := ioutil.ReadFile(defaultCaCerts.CNPrivateKeyFile())
+	helperFailIfErr(t, "Failed reading private key: %s", err)
+	if !secrets.IsSealed(keyFileBytes) {
+		t.Fatal("CA private key was not encrypted")
+	}
+	info, err := os.Stat(defaultCaCerts.CNPrivateKeyFile())
+	helperFailIfErr(t, "Failed reading private key file mode: %s", err)
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("Expected CA private key file mode 0600, but was %o", info.Mode().Perm())
+	}
+}
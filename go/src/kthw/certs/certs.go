@@ -13,6 +13,7 @@ import (
 	"github.com/cloudflare/cfssl/helpers"
 	"github.com/cloudflare/cfssl/signer"
 	"github.com/cloudflare/cfssl/signer/local"
+	"github.com/spf13/viper"
 )
 
 // WriteCert defines methods implemented by all cert to writeit to disk
@@ -40,11 +41,11 @@ func writeCert(certPaths CertPaths, baseDir string, privateKeyBytes []byte, publ
 	if err != nil {
 		return err
 	}
-	err = writeToFile(privateKeyBytes, certPaths.PrivateKeyPath())
+	err = writeToFile(privateKeyBytes, certPaths.PrivateKeyPath(), privateKeyFileMode)
 	if err != nil {
 		return err
 	}
-	return writeToFile(publicKeyBytes, certPaths.PublicKeyPath())
+	return writeToFile(publicKeyBytes, certPaths.PublicKeyPath(), publicKeyFileMode)
 }
 
 // EtcdCert represents private and public key of etcd cert. This
@@ -72,40 +73,55 @@ func (e *EtcdClientCert) Write() error {
 	return writeCert(e, e.BaseDir, e.PrivateKeyBytes, e.PublicKeyBytes)
 }
 
-// CertGenerator generates certificates using a CA
+// CertGenerator generates certificates using the intermediate CAs. Each kind
+// of certificate is issued by the intermediate CA of its component.
 type CertGenerator struct {
-	CA          *CA
-	caKey       crypto.Signer
-	caCert      *x509.Certificate
+	CAs         IntermediateCAs
+	issuers     map[string]*issuer
 	signingConf *config.Signing
 	certsConf   Config
 	GeneratesCerts
 }
 
-// GeneratesCerts gets a CA and generates different certificates
+type issuer struct {
+	key  crypto.Signer
+	cert *x509.Certificate
+}
+
+// GeneratesCerts gets intermediate CAs and generates different certificates
 type GeneratesCerts interface {
-	GetCA() *CA
+	GetIntermediateCA(name string) *CA
 	GenEtcdCertificate(hosts []string) (*EtcdCert, error)
 	GenEtcdClientCertificate() (*EtcdClientCert, error)
 }
 
-// NewCertGenerator creates a CertGenerator using given CACerts
-func NewCertGenerator(ca *CA, certsConf Config) (*CertGenerator, error) {
-	if ca == nil {
-		return nil, fmt.Errorf("CACerts not porpery initiated. Either InitCA or LoadCA")
-	}
-
-	caKey, err := helpers.ParsePrivateKeyPEM(ca.KeyBytes)
+// NewCertGenerator creates a CertGenerator using given intermediate CAs
+func NewCertGenerator(cas IntermediateCAs, certsConf Config) (*CertGenerator, error) {
+	err := certsConf.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("Error while CA parsing private key: %s", err)
+		return nil, fmt.Errorf("Invalid certs config: %s", err)
 	}
 
-	caCert, err := helpers.ParseCertificatePEM(ca.CertBytes)
-	if err != nil {
-		return nil, fmt.Errorf("Error while parsing CA certificate: %s", err)
+	issuers := make(map[string]*issuer)
+	for _, name := range intermediateCANames {
+		ca := cas[name]
+		if ca == nil {
+			return nil, fmt.Errorf("Intermediate CA '%s' not properly initiated. Either InitCA or LoadIntermediateCAs", name)
+		}
+
+		caKey, err := helpers.ParsePrivateKeyPEM(ca.KeyBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing private key of CA '%s': %s", name, err)
+		}
+
+		caCert, err := helpers.ParseCertificatePEM(ca.CertBytes)
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing certificate of CA '%s': %s", name, err)
+		}
+		issuers[name] = &issuer{key: caKey, cert: caCert}
 	}
 
-	expiryDuration, _ := time.ParseDuration(signingExpiry)
+	expiryDuration, _ := time.ParseDuration(certsConf.CertExpiry)
 	signingConf := &config.Signing{
 		Profiles: map[string]*config.SigningProfile{
 			signingProfile: {
@@ -118,64 +134,75 @@ func NewCertGenerator(ca *CA, certsConf Config) (*CertGenerator, error) {
 		},
 	}
 	return &CertGenerator{
-		CA:          ca,
-		caKey:       caKey,
-		caCert:      caCert,
+		CAs:         cas,
+		issuers:     issuers,
 		signingConf: signingConf,
 		certsConf:   certsConf}, nil
 }
 
-// LoadCertGenerator loads a existing CA and creates a CertGenerator.
+// LoadCertGenerator loads the existing intermediate CAs and creates a CertGenerator.
 func LoadCertGenerator() (*CertGenerator, error) {
-	ca, err := NewDefaultCertificateLoader().LoadCA()
+	return LoadCertGeneratorIn(viper.GetViper())
+}
+
+// LoadCertGeneratorIn loads the existing intermediate CAs like
+// LoadCertGenerator using the certs config and secrets settings in settings.
+func LoadCertGeneratorIn(settings *viper.Viper) (*CertGenerator, error) {
+	cas, err := NewDefaultCertificateLoaderIn(settings).LoadIntermediateCAs()
 	if err != nil {
-		return nil, fmt.Errorf("Error while loading CA. %s", err)
+		return nil, fmt.Errorf("Error while loading intermediate CAs. %s", err)
 	}
 
-	conf := ReadConfig()
-	certGenerator, err := NewCertGenerator(ca, conf)
+	conf := ReadConfigIn(settings)
+	certGenerator, err := NewCertGenerator(cas, conf)
 	if err != nil {
 		return nil, fmt.Errorf("Error while creating certificate generator: %s", err)
 	}
 	return certGenerator, nil
 }
 
-// GetCA returns the CA used to generate certificates
-func (c *CertGenerator) GetCA() *CA { return c.CA }
+// GetIntermediateCA returns the intermediate CA with the given name
+func (c *CertGenerator) GetIntermediateCA(name string) *CA { return c.CAs[name] }
 
 const noHostname string = ""
 
-// GenEtcdCertificate generates a etcd server certificate using the CA og CertGenerator.
+// GenEtcdCertificate generates a etcd server certificate issued by the etcd CA.
 func (c *CertGenerator) GenEtcdCertificate(hosts []string) (*EtcdCert, error) {
 	req := &csr.CertificateRequest{
 		CN:         etcdCN,
-		KeyRequest: &csr.BasicKeyRequest{A: keyAlgo, S: keySize},
-		Names:      []csr.Name{certName(etcdO)},
+		KeyRequest: c.certsConf.keyRequest(),
+		Names:      []csr.Name{c.certsConf.certName()},
 		Hosts:      hosts}
-	privateKeyBytes, publicKeyBytes, _ := c.genPrivateAndPublicKey(req, noHostname)
+	privateKeyBytes, publicKeyBytes, err := c.genPrivateAndPublicKey(EtcdCA, req, noHostname)
+	if err != nil {
+		return nil, err
+	}
 	etcdCert := &EtcdCert{BaseDir: c.certsConf.BaseDir, PrivateKeyBytes: privateKeyBytes, PublicKeyBytes: publicKeyBytes}
 	return etcdCert, nil
 }
 
-// GenEtcdClientCertificate generates a etcd client certificate using the CA og CertGenerator.
+// GenEtcdClientCertificate generates a etcd client certificate issued by the etcd CA.
 func (c *CertGenerator) GenEtcdClientCertificate() (*EtcdClientCert, error) {
 	req := &csr.CertificateRequest{
 		CN:         noHostname,
-		KeyRequest: &csr.BasicKeyRequest{A: keyAlgo, S: keySize},
-		Names:      []csr.Name{certName(etcdO)}}
-	privateKeyBytes, publicKeyBytes, _ := c.genPrivateAndPublicKey(req, noHostname)
+		KeyRequest: c.certsConf.keyRequest(),
+		Names:      []csr.Name{c.certsConf.certName()}}
+	privateKeyBytes, publicKeyBytes, err := c.genPrivateAndPublicKey(EtcdCA, req, noHostname)
+	if err != nil {
+		return nil, err
+	}
 	etcdCert := &EtcdClientCert{BaseDir: c.certsConf.BaseDir, PrivateKeyBytes: privateKeyBytes, PublicKeyBytes: publicKeyBytes}
 	return etcdCert, nil
 }
 
-func (c *CertGenerator) genPrivateAndPublicKey(req *csr.CertificateRequest, hostname string) (privateKeyBytes []byte, publicKeyBytes []byte, err error) {
+func (c *CertGenerator) genPrivateAndPublicKey(issuerName string, req *csr.CertificateRequest, hostname string) (privateKeyBytes []byte, publicKeyBytes []byte, err error) {
 	csrBytes, privateKeyBytes, err := c.genPrivateKey(req)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Error while generating private key: %s", err)
+		return nil, nil, fmt.Errorf("Error while generating private key: %w", err)
 	}
-	publicKeyBytes, err = c.genPublicKey(csrBytes, privateKeyBytes, hostname)
+	publicKeyBytes, err = c.genPublicKey(issuerName, csrBytes, hostname)
 	if err != nil {
-		return nil, nil, fmt.Errorf("Error while generating public key: %s", err)
+		return nil, nil, fmt.Errorf("Error while generating public key: %w", err)
 	}
 	return privateKeyBytes, publicKeyBytes, nil
 }
@@ -189,8 +216,9 @@ func (c *CertGenerator) genPrivateKey(req *csr.CertificateRequest) (csrBytes []b
 	return csrBytes, privateKeyBytes, nil
 }
 
-func (c *CertGenerator) genPublicKey(csrBytes []byte, privateKeyBytes []byte, hostname string) (cert []byte, err error) {
-	caSigner, err := local.NewSigner(c.caKey, c.caCert, signer.DefaultSigAlgo(c.caKey), c.signingConf)
+func (c *CertGenerator) genPublicKey(issuerName string, csrBytes []byte, hostname string) (cert []byte, err error) {
+	issuer := c.issuers[issuerName]
+	caSigner, err := local.NewSigner(issuer.key, issuer.cert, signer.DefaultSigAlgo(issuer.key), c.signingConf)
 	if err != nil {
 		return nil, fmt.Errorf("Error while creating CA signer: %s", err)
 	}
@@ -1,17 +1,23 @@
 package certs_test
 
 import (
+	"crypto/ecdsa"
+	"io/ioutil"
 	"kthw/certs"
 	"path"
 	"testing"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
 )
 
 func createCertGenerator(t *testing.T) (*certs.CertGenerator, certs.Config) {
 	caCerts, certsDir := helperCreateDefaultCACerts(t)
 	caCerts.InitCa()
 	helperEnsureCaCertsInitialized(t, caCerts)
-	certsConf := certs.Config{BaseDir: certsDir}
-	certGenerator, err := certs.NewCertGenerator(caCerts.CA, certsConf)
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = certsDir
+	certGenerator, err := certs.NewCertGenerator(caCerts.IntermediateCAs, certsConf)
 	helperFailIfErr(t, "Error while creating CertGenerator", err)
 
 	return certGenerator, certsConf
@@ -62,3 +68,64 @@ func TestGenerateEtcdClientCert(t *testing.T) {
 		t.Fatalf("Private key path wrong. Should be '$baseDir/etcd-client.key'.")
 	}
 }
+
+func TestGenerateCertsUsingConfig(t *testing.T) {
+	tempDirName, err := ioutil.TempDir("", "InitCA")
+	helperFailIfErr(t, "Error creating temp dir: %s", err)
+
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = tempDirName
+	certsConf.KeyAlgo = "ecdsa"
+	certsConf.KeySize = 256
+	certsConf.CAExpiry = "87600h"
+	certsConf.CertExpiry = "720h"
+	certsConf.Organization = "Example Corp"
+	certsConf.OrganizationalUnit = "Platform"
+	certsConf.Locality = "Berlin"
+
+	caCerts := certs.DefaultCACerts(certsConf)
+	err = caCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	caCert, err := helpers.ParseCertificatePEM(caCerts.CA.CertBytes)
+	helperFailIfErr(t, "Error parsing generated CA cert: %s", err)
+	if _, ok := caCert.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("Expected CA with ECDSA key")
+	}
+	if days := int(time.Until(caCert.NotAfter).Hours() / 24); days < 3649 || days > 3650 {
+		t.Errorf("Expected CA to expire in 3650 days, but expires in %d days", days)
+	}
+
+	certGenerator, err := certs.NewCertGenerator(caCerts.IntermediateCAs, certsConf)
+	helperFailIfErr(t, "Error while creating CertGenerator: %s", err)
+	etcdCert, err := certGenerator.GenEtcdCertificate([]string{"localhost"})
+	helperFailIfErr(t, "Error while generating etcd certificate: %s", err)
+
+	cert, err := helpers.ParseCertificatePEM(etcdCert.PublicKeyBytes)
+	helperFailIfErr(t, "Error parsing generated etcd cert: %s", err)
+	if _, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok {
+		t.Errorf("Expected etcd certificate with ECDSA key")
+	}
+	if days := int(time.Until(cert.NotAfter).Hours() / 24); days < 29 || days > 30 {
+		t.Errorf("Expected etcd certificate to expire in 30 days, but expires in %d days", days)
+	}
+	if len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != "Example Corp" {
+		t.Errorf("Expected organization 'Example Corp', but was %v", cert.Subject.Organization)
+	}
+	if len(cert.Subject.OrganizationalUnit) != 1 || cert.Subject.OrganizationalUnit[0] != "Platform" {
+		t.Errorf("Expected organizational unit 'Platform', but was %v", cert.Subject.OrganizationalUnit)
+	}
+}
+
+func TestNewCertGeneratorRejectsInvalidConfig(t *testing.T) {
+	caCerts, certsDir := helperCreateDefaultCACerts(t)
+	caCerts.InitCa()
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = certsDir
+	certsConf.KeyAlgo = "dsa"
+
+	_, err := certs.NewCertGenerator(caCerts.IntermediateCAs, certsConf)
+	if err == nil {
+		t.Errorf("Expected error creating CertGenerator with invalid config")
+	}
+}
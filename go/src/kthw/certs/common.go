@@ -4,8 +4,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-
-	"github.com/cloudflare/cfssl/csr"
 )
 
 var signingUsages = []string{"signing", "key encipherment", "server auth", "client auth"}
@@ -14,6 +12,8 @@ const (
 	keySize = 2048
 	keyAlgo = "rsa"
 
+	defaultOrganization = "Kubernetes"
+
 	caCN           = "Kubernetes"
 	caKeyFileName  = "ca.key"
 	caCertFileName = "ca.crt"
@@ -29,28 +29,23 @@ const (
 	adminClientCertFileName = "admin.crt"
 
 	etcdCN                 = "etcd"
-	etcdO                  = "Kubernetes"
 	etcdKeyFileName        = "etcd.key"
 	etcdCertFileName       = "etcd.crt"
 	etcdClientKeyFileName  = "etcd-client.key"
 	etcdClientCertFileName = "etcd-client.crt"
 )
 
-func certName(o string) csr.Name {
-	return csr.Name{
-		C:  "DE",
-		L:  "Mainz",
-		O:  o,
-		OU: "Learning Kubernetes",
-		ST: "RLP"}
-}
+const (
+	publicKeyFileMode  = 0644
+	privateKeyFileMode = 0600
+)
 
-func writeToFile(cert []byte, file string) error {
+func writeToFile(cert []byte, file string, mode os.FileMode) error {
 	var err error
 	if _, statErr := os.Stat(file); statErr == nil {
 		err = fmt.Errorf("Could not write certificate to already existing file %s", file)
 	} else {
-		err = ioutil.WriteFile(file, cert, 0644)
+		err = ioutil.WriteFile(file, cert, mode)
 	}
 	return err
 }
@@ -1,23 +1,149 @@
 package certs
 
-import "github.com/spf13/viper"
+import (
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/spf13/viper"
+)
 
 const (
-	certsBaseDirKey = "certs.baseDir"
+	certsBaseDirKey            = "certs.baseDir"
+	certsKeyAlgoKey            = "certs.keyAlgo"
+	certsKeySizeKey            = "certs.keySize"
+	certsCAExpiryKey           = "certs.caExpiry"
+	certsCertExpiryKey         = "certs.certExpiry"
+	certsCountryKey            = "certs.names.country"
+	certsStateKey              = "certs.names.state"
+	certsLocalityKey           = "certs.names.locality"
+	certsOrganizationKey       = "certs.names.organization"
+	certsOrganizationalUnitKey = "certs.names.organizationalUnit"
 )
 
-// Config contains all configuations for generating certs
+// Config contains all configuations for generating certs. KeyAlgo is either
+// 'rsa' or 'ecdsa'. Expiries are durations like '8760h'. The names are used in
+// the subject of the CA and all certificates issued by it.
 type Config struct {
-	BaseDir string
+	BaseDir            string
+	KeyAlgo            string
+	KeySize            int
+	CAExpiry           string
+	CertExpiry         string
+	Country            string
+	State              string
+	Locality           string
+	Organization       string
+	OrganizationalUnit string
 }
 
-// ReadConfig reads certs configuration from config file.
+// DefaultConfig returns the certs config used if project.yaml doesn't
+// configure a setting.
+func DefaultConfig() Config {
+	return Config{
+		BaseDir:            certsBaseDir,
+		KeyAlgo:            keyAlgo,
+		KeySize:            keySize,
+		CAExpiry:           signingExpiry,
+		CertExpiry:         signingExpiry,
+		Country:            "DE",
+		State:              "RLP",
+		Locality:           "Mainz",
+		Organization:       defaultOrganization,
+		OrganizationalUnit: "Learning Kubernetes"}
+}
+
+// ReadConfig reads certs configuration from config file. Settings missing
+// in the config file have their default value.
 func ReadConfig() Config {
-	return Config{BaseDir: viper.GetString(certsBaseDirKey)}
+	return ReadConfigIn(viper.GetViper())
 }
 
-// InitDefaultConfig sets default certs config.
+// ReadConfigIn reads certs configuration from settings like ReadConfig.
+func ReadConfigIn(settings *viper.Viper) Config {
+	conf := DefaultConfig()
+	conf.BaseDir = settings.GetString(certsBaseDirKey)
+	readStringIfSet(settings, certsKeyAlgoKey, &conf.KeyAlgo)
+	if settings.IsSet(certsKeySizeKey) {
+		conf.KeySize = settings.GetInt(certsKeySizeKey)
+	}
+	readStringIfSet(settings, certsCAExpiryKey, &conf.CAExpiry)
+	readStringIfSet(settings, certsCertExpiryKey, &conf.CertExpiry)
+	readStringIfSet(settings, certsCountryKey, &conf.Country)
+	readStringIfSet(settings, certsStateKey, &conf.State)
+	readStringIfSet(settings, certsLocalityKey, &conf.Locality)
+	readStringIfSet(settings, certsOrganizationKey, &conf.Organization)
+	readStringIfSet(settings, certsOrganizationalUnitKey, &conf.OrganizationalUnit)
+	return conf
+}
+
+func readStringIfSet(settings *viper.Viper, key string, value *string) {
+	if settings.IsSet(key) {
+		*value = settings.GetString(key)
+	}
+}
+
+// InitDefaultConfig sets the default of every certs setting which isn't set
+// yet. Settings already in the config file are kept.
 func InitDefaultConfig() Config {
-	viper.Set(certsBaseDirKey, certsBaseDir)
+	conf := DefaultConfig()
+	setIfUnset(certsBaseDirKey, conf.BaseDir)
+	setIfUnset(certsKeyAlgoKey, conf.KeyAlgo)
+	setIfUnset(certsKeySizeKey, conf.KeySize)
+	setIfUnset(certsCAExpiryKey, conf.CAExpiry)
+	setIfUnset(certsCertExpiryKey, conf.CertExpiry)
+	setIfUnset(certsCountryKey, conf.Country)
+	setIfUnset(certsStateKey, conf.State)
+	setIfUnset(certsLocalityKey, conf.Locality)
+	setIfUnset(certsOrganizationKey, conf.Organization)
+	setIfUnset(certsOrganizationalUnitKey, conf.OrganizationalUnit)
 	return ReadConfig()
 }
+
+func setIfUnset(key string, value interface{}) {
+	if !viper.IsSet(key) {
+		viper.Set(key, value)
+	}
+}
+
+// Validate returns an error if key algorithm, key size or expiries are invalid.
+func (c Config) Validate() error {
+	switch c.KeyAlgo {
+	case "rsa":
+		if c.KeySize < 2048 {
+			return fmt.Errorf("RSA key size must be at least 2048, but was %d", c.KeySize)
+		}
+	case "ecdsa":
+		if c.KeySize != 256 && c.KeySize != 384 && c.KeySize != 521 {
+			return fmt.Errorf("ECDSA key size must be 256, 384 or 521, but was %d", c.KeySize)
+		}
+	default:
+		return fmt.Errorf("'%s' is not a valid key algorithm. Valid algorithms are: rsa, ecdsa", c.KeyAlgo)
+	}
+
+	caExpiry, err := time.ParseDuration(c.CAExpiry)
+	if err != nil {
+		return fmt.Errorf("Invalid CA expiry '%s': %s", c.CAExpiry, err)
+	}
+	certExpiry, err := time.ParseDuration(c.CertExpiry)
+	if err != nil {
+		return fmt.Errorf("Invalid certificate expiry '%s': %s", c.CertExpiry, err)
+	}
+	if certExpiry > caExpiry {
+		return fmt.Errorf("Certificate expiry %s must not exceed CA expiry %s", c.CertExpiry, c.CAExpiry)
+	}
+	return nil
+}
+
+func (c Config) keyRequest() *csr.BasicKeyRequest {
+	return &csr.BasicKeyRequest{A: c.KeyAlgo, S: c.KeySize}
+}
+
+func (c Config) certName() csr.Name {
+	return csr.Name{
+		C:  c.Country,
+		L:  c.Locality,
+		O:  c.Organization,
+		OU: c.OrganizationalUnit,
+		ST: c.State}
+}
@@ -3,8 +3,15 @@ package certs_test
 import (
 	"kthw/certs"
 	"testing"
+
+	"github.com/spf13/viper"
 )
 
+func helperResetConfig() {
+	viper.Reset()
+	certs.InitDefaultConfig()
+}
+
 func TestInitDefaultConfigAndReadConfig(t *testing.T) {
 	certs.InitDefaultConfig()
 
@@ -13,3 +20,62 @@ func TestInitDefaultConfigAndReadConfig(t *testing.T) {
 		t.Errorf("Expected base dir is 'pki', but was %s", conf.BaseDir)
 	}
 }
+
+func TestReadConfigOverridesDefaults(t *testing.T) {
+	certs.InitDefaultConfig()
+	viper.Set("certs.keyAlgo", "ecdsa")
+	viper.Set("certs.keySize", 256)
+	viper.Set("certs.caExpiry", "87600h")
+	viper.Set("certs.names.organization", "Example Corp")
+	defer helperResetConfig()
+
+	conf := certs.ReadConfig()
+	if conf.KeyAlgo != "ecdsa" || conf.KeySize != 256 {
+		t.Errorf("Expected key ecdsa 256, but was %s %d", conf.KeyAlgo, conf.KeySize)
+	}
+	if conf.CAExpiry != "87600h" {
+		t.Errorf("Expected CA expiry 87600h, but was %s", conf.CAExpiry)
+	}
+	if conf.CertExpiry != "8760h" {
+		t.Errorf("Expected default certificate expiry 8760h, but was %s", conf.CertExpiry)
+	}
+	if conf.Organization != "Example Corp" {
+		t.Errorf("Expected organization 'Example Corp', but was %s", conf.Organization)
+	}
+}
+
+func TestInitDefaultConfigKeepsSettings(t *testing.T) {
+	viper.Reset()
+	viper.Set("certs.keyAlgo", "ecdsa")
+	viper.Set("certs.keySize", 384)
+	viper.Set("certs.names.country", "NL")
+	defer helperResetConfig()
+
+	conf := certs.InitDefaultConfig()
+	if conf.KeyAlgo != "ecdsa" || conf.KeySize != 384 || conf.Country != "NL" {
+		t.Errorf("Expected settings to be kept, but config was %+v", conf)
+	}
+	if conf.BaseDir != "pki" || conf.CAExpiry != certs.DefaultConfig().CAExpiry {
+		t.Errorf("Expected defaults for unset settings, but config was %+v", conf)
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	invalidConfs := map[string]func(*certs.Config){
+		"unknown key algorithm": func(c *certs.Config) { c.KeyAlgo = "dsa" },
+		"small rsa key":         func(c *certs.Config) { c.KeySize = 1024 },
+		"invalid ecdsa size":    func(c *certs.Config) { c.KeyAlgo = "ecdsa"; c.KeySize = 2048 },
+		"invalid expiry":        func(c *certs.Config) { c.CertExpiry = "one year" },
+		"cert outlives CA":      func(c *certs.Config) { c.CAExpiry = "720h" }}
+
+	if err := certs.DefaultConfig().Validate(); err != nil {
+		t.Errorf("Default config is invalid: %s", err)
+	}
+	for name, modify := range invalidConfs {
+		conf := certs.DefaultConfig()
+		modify(&conf)
+		if conf.Validate() == nil {
+			t.Errorf("Expected config with %s to be invalid", name)
+		}
+	}
+}
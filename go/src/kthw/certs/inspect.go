@@ -0,0 +1,123 @@
+package certs
+
+import (
+	"bufio"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+// CertInfo describes a certificate and where it was found.
+type CertInfo struct {
+	Location string
+	Subject  string
+	Issuer   string
+	SANs     []string
+	IsCA     bool
+	NotAfter time.Time
+}
+
+// DaysRemaining returns the number of days until the certificate expires.
+// It is negative if the certificate already expired.
+func (c *CertInfo) DaysRemaining(now time.Time) int {
+	return int(c.NotAfter.Sub(now).Hours() / 24)
+}
+
+// ParseCertInfo parses a PEM encoded certificate found at location.
+func ParseCertInfo(location string, certBytes []byte) (*CertInfo, error) {
+	cert, err := helpers.ParseCertificatePEM(certBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing certificate '%s': %s", location, err)
+	}
+	return newCertInfo(location, cert), nil
+}
+
+func newCertInfo(location string, cert *x509.Certificate) *CertInfo {
+	sans := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	return &CertInfo{
+		Location: location,
+		Subject:  cert.Subject.String(),
+		Issuer:   cert.Issuer.String(),
+		SANs:     sans,
+		IsCA:     cert.IsCA,
+		NotAfter: cert.NotAfter}
+}
+
+// InspectDir parses all certificates with extension '.crt' in baseDir.
+func InspectDir(baseDir string) ([]*CertInfo, error) {
+	files, err := filepath.Glob(path.Join(baseDir, "*.crt"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var infos []*CertInfo
+	for _, file := range files {
+		certBytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading certificate file '%s': %s", file, err)
+		}
+		info, err := ParseCertInfo(file, certBytes)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// CertBundleFileMarker precedes the content of every file in a certificate bundle.
+const CertBundleFileMarker = "### "
+
+// ParseCertBundle parses certificates of several files. Each file starts with a
+// line containing CertBundleFileMarker followed by the path of the file. Locations
+// of certificates are prefixed with host.
+func ParseCertBundle(host string, bundle string) ([]*CertInfo, error) {
+	var infos []*CertInfo
+	var currentFile string
+	var content strings.Builder
+
+	flush := func() error {
+		if currentFile == "" {
+			return nil
+		}
+		info, err := ParseCertInfo(fmt.Sprintf("%s:%s", host, currentFile), []byte(content.String()))
+		if err != nil {
+			return err
+		}
+		infos = append(infos, info)
+		content.Reset()
+		return nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(bundle))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, CertBundleFileMarker) {
+			err := flush()
+			if err != nil {
+				return nil, err
+			}
+			currentFile = strings.TrimPrefix(line, CertBundleFileMarker)
+			continue
+		}
+		content.WriteString(line)
+		content.WriteString("\n")
+	}
+
+	err := flush()
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
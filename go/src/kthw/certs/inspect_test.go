@@ -0,0 +1,68 @@
+package certs_test
+
+import (
+	"fmt"
+	"kthw/certs"
+	"testing"
+	"time"
+)
+
+func TestInspectDir(t *testing.T) {
+	defaultCaCerts, tempDirName := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	infos, err := certs.InspectDir(tempDirName)
+	helperFailIfErr(t, "Error while inspecting certificates: %s", err)
+
+	if len(infos) != 4 {
+		t.Fatalf("Expected to find root and 3 intermediate certificates, but found %d", len(infos))
+	}
+
+	var rootInfo *certs.CertInfo
+	for _, info := range infos {
+		if info.Location == defaultCaCerts.CNPublicKeyFile() {
+			rootInfo = info
+		}
+	}
+	if rootInfo == nil {
+		t.Fatalf("Root CA certificate %s not found", defaultCaCerts.CNPublicKeyFile())
+	}
+	if !rootInfo.IsCA {
+		t.Errorf("Expected CA certificate")
+	}
+
+	daysRemaining := rootInfo.DaysRemaining(time.Now())
+	if daysRemaining < 364 || daysRemaining > 365 {
+		t.Errorf("Expected CA certificate to expire in 365 days, but expires in %d days", daysRemaining)
+	}
+}
+
+func TestParseCertBundle(t *testing.T) {
+	defaultCaCerts, _ := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	caCert := string(defaultCaCerts.CA.CertBytes)
+	bundle := fmt.Sprintf("%s/etc/etcd/pki/ca.crt\n%s\n%s/etc/kubernetes/pki/ca.crt\n%s",
+		certs.CertBundleFileMarker, caCert, certs.CertBundleFileMarker, caCert)
+
+	infos, err := certs.ParseCertBundle("192.168.1.1", bundle)
+	helperFailIfErr(t, "Error while parsing certificate bundle: %s", err)
+
+	if len(infos) != 2 {
+		t.Fatalf("Expected to find 2 certificates, but found %d", len(infos))
+	}
+	if infos[1].Location != "192.168.1.1:/etc/kubernetes/pki/ca.crt" {
+		t.Errorf("Unexpected location of certificate: %s", infos[1].Location)
+	}
+}
+
+func TestParseEmptyCertBundle(t *testing.T) {
+	infos, err := certs.ParseCertBundle("192.168.1.1", "")
+	helperFailIfErr(t, "Error while parsing certificate bundle: %s", err)
+
+	if len(infos) != 0 {
+		t.Errorf("Expected no certificates, but found %d", len(infos))
+	}
+}
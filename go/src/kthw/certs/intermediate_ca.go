@@ -0,0 +1,135 @@
+package certs
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/cloudflare/cfssl/config"
+	"github.com/cloudflare/cfssl/csr"
+	"github.com/cloudflare/cfssl/helpers"
+	"github.com/cloudflare/cfssl/signer"
+	"github.com/cloudflare/cfssl/signer/local"
+)
+
+// Names of the intermediate CAs signed by the root CA. Each intermediate CA
+// issues the certificates of one component, so that only its key has to be
+// uploaded to the nodes running that component.
+const (
+	EtcdCA       = "etcd-ca"
+	KubernetesCA = "kubernetes-ca"
+	FrontProxyCA = "front-proxy-ca"
+)
+
+var intermediateCANames = []string{EtcdCA, KubernetesCA, FrontProxyCA}
+
+const intermediateCAProfile = "intermediate-ca"
+
+// IntermediateCAs maps names of intermediate CAs to their public and private keys.
+type IntermediateCAs map[string]*CA
+
+// IntermediateCANames returns the names of all intermediate CAs.
+func IntermediateCANames() []string {
+	return intermediateCANames
+}
+
+// IntermediatePrivateKeyFile returns the path to the private key PEM file of an intermediate CA.
+func (c *CACerts) IntermediatePrivateKeyFile(name string) string {
+	return path.Join(c.CABaseDir, name+".key")
+}
+
+// IntermediatePublicKeyFile returns the path to the public key PEM file of an intermediate CA.
+func (c *CACerts) IntermediatePublicKeyFile(name string) string {
+	return path.Join(c.CABaseDir, name+".crt")
+}
+
+// InitIntermediateCAs generates all intermediate CAs signed by the root CA
+// and stores them in the CA base dir. The root CA must be initialised or
+// loaded before. Existing files are not overwritten.
+func (c *CACerts) InitIntermediateCAs() error {
+	if c.CA == nil {
+		return fmt.Errorf("Root CA not initialised. Either InitCA or LoadCA")
+	}
+
+	caSigner, err := c.newIntermediateCASigner()
+	if err != nil {
+		return err
+	}
+
+	intermediateCAs := make(IntermediateCAs)
+	for _, name := range intermediateCANames {
+		ca, err := c.generateIntermediateCA(name, caSigner)
+		if err != nil {
+			return err
+		}
+		err = c.writeCA(ca, c.IntermediatePublicKeyFile(name), c.IntermediatePrivateKeyFile(name))
+		if err != nil {
+			return err
+		}
+		intermediateCAs[name] = ca
+	}
+	c.IntermediateCAs = intermediateCAs
+	return nil
+}
+
+// LoadIntermediateCAs loads public and private keys of all intermediate CAs
+// from files. Encrypted private keys are decrypted in memory using Sealer.
+// The root CA isn't required.
+func (c *CACerts) LoadIntermediateCAs() error {
+	intermediateCAs := make(IntermediateCAs)
+	for _, name := range intermediateCANames {
+		ca, err := c.readCA(c.IntermediatePublicKeyFile(name), c.IntermediatePrivateKeyFile(name))
+		if err != nil {
+			return err
+		}
+		intermediateCAs[name] = ca
+	}
+	c.IntermediateCAs = intermediateCAs
+	return nil
+}
+
+func (c *CACerts) newIntermediateCASigner() (*local.Signer, error) {
+	rootKey, err := helpers.ParsePrivateKeyPEM(c.CA.KeyBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing root CA private key: %s", err)
+	}
+	rootCert, err := helpers.ParseCertificatePEM(c.CA.CertBytes)
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing root CA certificate: %s", err)
+	}
+
+	expiryDuration, err := time.ParseDuration(c.cAConf.Expiry)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid CA expiry '%s': %s", c.cAConf.Expiry, err)
+	}
+	profile := &config.SigningProfile{
+		Usage:        []string{"cert sign", "crl sign"},
+		Expiry:       expiryDuration,
+		CAConstraint: config.CAConstraint{IsCA: true, MaxPathLen: 0, MaxPathLenZero: true}}
+	signingConf := &config.Signing{
+		Profiles: map[string]*config.SigningProfile{intermediateCAProfile: profile},
+		Default:  profile}
+
+	caSigner, err := local.NewSigner(rootKey, rootCert, signer.DefaultSigAlgo(rootKey), signingConf)
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating root CA signer: %s", err)
+	}
+	return caSigner, nil
+}
+
+func (c *CACerts) generateIntermediateCA(name string, caSigner *local.Signer) (*CA, error) {
+	req := &csr.CertificateRequest{
+		CN:         name,
+		KeyRequest: c.cACsr.KeyRequest,
+		Names:      c.cACsr.Names}
+	csrBytes, keyBytes, err := csr.ParseRequest(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error generating private key of intermediate CA '%s': %s", name, err)
+	}
+
+	certBytes, err := caSigner.Sign(signer.SignRequest{Request: string(csrBytes), Profile: intermediateCAProfile})
+	if err != nil {
+		return nil, fmt.Errorf("Error signing intermediate CA '%s': %s", name, err)
+	}
+	return &CA{CertBytes: certBytes, KeyBytes: keyBytes}, nil
+}
@@ -0,0 +1,92 @@
+package certs_test
+
+import (
+	"crypto/x509"
+	"kthw/certs"
+	"os"
+	"testing"
+
+	"github.com/cloudflare/cfssl/helpers"
+)
+
+func TestInitCACreatesIntermediateCAsSignedByRoot(t *testing.T) {
+	defaultCaCerts, _ := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	rootCert, err := helpers.ParseCertificatePEM(defaultCaCerts.CA.CertBytes)
+	helperFailIfErr(t, "Error parsing root CA cert: %s", err)
+	roots := x509.NewCertPool()
+	roots.AddCert(rootCert)
+
+	for _, name := range certs.IntermediateCANames() {
+		ca := defaultCaCerts.IntermediateCAs[name]
+		if ca == nil {
+			t.Fatalf("Intermediate CA '%s' not generated", name)
+		}
+		cert, err := helpers.ParseCertificatePEM(ca.CertBytes)
+		helperFailIfErr(t, "Error parsing intermediate CA cert: %s", err)
+
+		if !cert.IsCA || cert.Subject.CommonName != name {
+			t.Errorf("Expected intermediate CA with common name '%s', but was '%s'", name, cert.Subject.CommonName)
+		}
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		if err != nil {
+			t.Errorf("Intermediate CA '%s' is not signed by root CA: %s", name, err)
+		}
+		if !helperFileExists(defaultCaCerts.IntermediatePrivateKeyFile(name)) {
+			t.Errorf("Private key of intermediate CA '%s' not written", name)
+		}
+	}
+}
+
+func TestEtcdCertIssuedByEtcdCA(t *testing.T) {
+	defaultCaCerts, tempDirName := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	// The root CA stays offline. Loading intermediates must not require its private key.
+	err = os.Remove(defaultCaCerts.CNPrivateKeyFile())
+	helperFailIfErr(t, "Error removing root CA private key: %s", err)
+
+	loadedCaCerts, _ := helperCreateDefaultCACerts(t)
+	loadedCaCerts.CABaseDir = tempDirName
+	err = loadedCaCerts.LoadIntermediateCAs()
+	helperFailIfErr(t, "Error loading intermediate CAs: %s", err)
+
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = tempDirName
+	certGenerator, err := certs.NewCertGenerator(loadedCaCerts.IntermediateCAs, certsConf)
+	helperFailIfErr(t, "Error while creating CertGenerator: %s", err)
+
+	etcdCert, err := certGenerator.GenEtcdCertificate([]string{"localhost"})
+	helperFailIfErr(t, "Error while generating etcd certificate: %s", err)
+	cert, err := helpers.ParseCertificatePEM(etcdCert.PublicKeyBytes)
+	helperFailIfErr(t, "Error parsing etcd cert: %s", err)
+
+	etcdCACert, err := helpers.ParseCertificatePEM(certGenerator.GetIntermediateCA(certs.EtcdCA).CertBytes)
+	helperFailIfErr(t, "Error parsing etcd CA cert: %s", err)
+	err = cert.CheckSignatureFrom(etcdCACert)
+	if err != nil {
+		t.Errorf("etcd certificate is not issued by etcd CA: %s", err)
+	}
+}
+
+func TestNewCertGeneratorRequiresAllIntermediateCAs(t *testing.T) {
+	defaultCaCerts, tempDirName := helperCreateDefaultCACerts(t)
+	err := defaultCaCerts.InitCa()
+	helperFailIfErr(t, "Error while generating CA: %s", err)
+
+	cas := certs.IntermediateCAs{certs.EtcdCA: defaultCaCerts.IntermediateCAs[certs.EtcdCA]}
+	certsConf := certs.DefaultConfig()
+	certsConf.BaseDir = tempDirName
+	_, err = certs.NewCertGenerator(cas, certsConf)
+	if err == nil {
+		t.Errorf("Expected error creating CertGenerator without all intermediate CAs")
+	}
+}
+
+func helperFileExists(file string) bool {
+	_, err := os.Stat(file)
+	return err == nil
+}
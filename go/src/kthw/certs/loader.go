@@ -2,30 +2,39 @@ package certs
 
 import (
 	"fmt"
+	"kthw/secrets"
+
+	"github.com/spf13/viper"
 )
 
 // CertificateLoader loads public and private keys
 type CertificateLoader interface {
 	LoadEtcdClientCert() (*EtcdClientCert, error)
-	LoadCA() (*CA, error)
+	LoadIntermediateCAs() (IntermediateCAs, error)
 }
 
 // DefaultCertificateLoader loads certificates from filesystem
 type DefaultCertificateLoader struct {
-	baseDir string
+	certsConf Config
+	settings  *viper.Viper
 	CertificateLoader
 }
 
 // NewDefaultCertificateLoader creates a DefaultCertificateLoader using certificate base dir from certs config.
 func NewDefaultCertificateLoader() *DefaultCertificateLoader {
-	config := ReadConfig()
-	return &DefaultCertificateLoader{baseDir: config.BaseDir}
+	return NewDefaultCertificateLoaderIn(viper.GetViper())
+}
+
+// NewDefaultCertificateLoaderIn creates a DefaultCertificateLoader using the
+// certs config and the secrets settings in settings.
+func NewDefaultCertificateLoaderIn(settings *viper.Viper) *DefaultCertificateLoader {
+	return &DefaultCertificateLoader{certsConf: ReadConfigIn(settings), settings: settings}
 }
 
 // LoadEtcdClientCert loads etcd client certificate from filesystem.
 func (d *DefaultCertificateLoader) LoadEtcdClientCert() (*EtcdClientCert, error) {
 	cert := &EtcdClientCert{
-		BaseDir: d.baseDir}
+		BaseDir: d.certsConf.BaseDir}
 
 	privateKeyBytes, publicKeyBytes, err := d.loadPrivateAndPublicKey(cert.PrivateKeyPath(), cert.PublicKeyPath())
 	if err != nil {
@@ -38,15 +47,23 @@ func (d *DefaultCertificateLoader) LoadEtcdClientCert() (*EtcdClientCert, error)
 	return cert, nil
 }
 
-// LoadCA loads CA certificate from filesystem.
-func (d *DefaultCertificateLoader) LoadCA() (*CA, error) {
-	caCert := DefaultCACerts(d.baseDir)
-	err := caCert.LoadCA()
+// LoadIntermediateCAs loads the intermediate CAs from filesystem. Encrypted
+// private keys are decrypted using the passphrase or key file returned by
+// secrets.ReadSealer. The root CA isn't loaded.
+func (d *DefaultCertificateLoader) LoadIntermediateCAs() (IntermediateCAs, error) {
+	sealer, err := secrets.ReadSealerIn(d.settings)
+	if err != nil {
+		return nil, err
+	}
+
+	caCerts := DefaultCACerts(d.certsConf)
+	caCerts.Sealer = sealer
+	err = caCerts.LoadIntermediateCAs()
 	if err != nil {
 		return nil, err
 	}
 
-	return caCert.CA, nil
+	return caCerts.IntermediateCAs, nil
 }
 
 func (d *DefaultCertificateLoader) loadPrivateAndPublicKey(privateKeyPath string, publicKeyPath string) ([]byte, []byte, error) {
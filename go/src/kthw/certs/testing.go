@@ -1,15 +1,15 @@
 package certs
 
 type GeneratesCertsMock struct {
-	ca                  *CA
+	cas                 IntermediateCAs
 	etcdCert            *EtcdCert
 	etcdClientCert      *EtcdClientCert
 	IsEtcdCertGenerated bool
 	GeneratesCerts
 }
 
-// GetCA returns CA of mock.
-func (g *GeneratesCertsMock) GetCA() *CA { return g.ca }
+// GetIntermediateCA returns intermediate CA of mock.
+func (g *GeneratesCertsMock) GetIntermediateCA(name string) *CA { return g.cas[name] }
 
 // GenEtcdCertificate returns etcd certificate of mock
 func (g *GeneratesCertsMock) GenEtcdCertificate(hosts []string) (*EtcdCert, error) {
@@ -24,10 +24,6 @@ func (g *GeneratesCertsMock) GenEtcdClientCertificate() (*EtcdClientCert, error)
 
 // NewGeneratesCertsMock creates a new mock with dummy certs
 func NewGeneratesCertsMock() *GeneratesCertsMock {
-	ca := CA{
-		CertBytes: []byte("CA_CERT"),
-		KeyBytes:  []byte("CA_KEY")}
-
 	etcdCert := EtcdCert{
 		PrivateKeyBytes: []byte("ETCD_KEY"),
 		PublicKeyBytes:  []byte("ETCD_CERT")}
@@ -37,7 +33,7 @@ func NewGeneratesCertsMock() *GeneratesCertsMock {
 		PublicKeyBytes:  []byte("ETCD_CERT")}
 
 	return &GeneratesCertsMock{
-		ca:                  &ca,
+		cas:                 mockIntermediateCAs("KEY"),
 		etcdCert:            &etcdCert,
 		etcdClientCert:      &etcdClientCert,
 		IsEtcdCertGenerated: false}
@@ -45,7 +41,7 @@ func NewGeneratesCertsMock() *GeneratesCertsMock {
 
 type CertificateLoaderMock struct {
 	etcdCert *EtcdClientCert
-	ca       *CA
+	cas      IntermediateCAs
 	CertificateLoader
 }
 
@@ -55,9 +51,7 @@ func NewCertificateLoaderMock() *CertificateLoaderMock {
 		etcdCert: &EtcdClientCert{
 			PrivateKeyBytes: []byte("ETCD_CLIENT_PRIVATE"),
 			PublicKeyBytes:  []byte("ETCD_CLIENT_PUBLIC")},
-		ca: &CA{
-			CertBytes: []byte("CA_CERT"),
-			KeyBytes:  []byte("CA_PRIVATE")},
+		cas: mockIntermediateCAs("PRIVATE"),
 	}
 }
 
@@ -66,7 +60,17 @@ func (c *CertificateLoaderMock) LoadEtcdClientCert() (*EtcdClientCert, error) {
 	return c.etcdCert, nil
 }
 
-// LoadCA returns CA of mock.
-func (c *CertificateLoaderMock) LoadCA() (*CA, error) {
-	return c.ca, nil
+// LoadIntermediateCAs returns intermediate CAs of mock.
+func (c *CertificateLoaderMock) LoadIntermediateCAs() (IntermediateCAs, error) {
+	return c.cas, nil
+}
+
+func mockIntermediateCAs(keySuffix string) IntermediateCAs {
+	cas := make(IntermediateCAs)
+	for _, name := range intermediateCANames {
+		cas[name] = &CA{
+			CertBytes: []byte(name + "_CERT"),
+			KeyBytes:  []byte(name + "_" + keySuffix)}
+	}
+	return cas
 }
@@ -0,0 +1,455 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"kthw/certs"
+	"kthw/cmd/cluster/etcd"
+	"kthw/cmd/cluster/kube"
+	"kthw/cmd/common"
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/network"
+	"kthw/cmd/infra/provider"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/infra/sshkey"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Install runs all phases servers didn't complete yet: it creates the
+// servers, sets up the overlay network, installs etcd and the control plane
+// and joins the workers. Use ResetPhasesFrom to run phases again.
+func (p *Project) Install(ctx context.Context) error {
+	return p.run(ctx, func(op *operation) error {
+		err := op.create()
+		if err != nil {
+			return err
+		}
+		err = op.setupNetwork(true)
+		if err != nil {
+			return err
+		}
+		err = op.installEtcd(true)
+		if err != nil {
+			return err
+		}
+		err = op.installControlPlane(true)
+		if err != nil {
+			return err
+		}
+		return op.joinWorkers(server.SelectHostsInRole(op.servers, "worker"), true)
+	})
+}
+
+// ResetPhasesFrom removes phase and all phases following it from the phases
+// servers completed, so that Install runs them again.
+func (p *Project) ResetPhasesFrom(phase string) error {
+	err := server.IsValidPhase(phase)
+	if err != nil {
+		return err
+	}
+	if phase == server.PhaseServer {
+		fmt.Fprintln(p.out(), "Servers which already exist are not created again. Use the destroy command to delete them first.")
+	}
+	return p.withConfig(func(config *viper.Viper) error {
+		servers, err := server.AllFromConfigIn(config)
+		if err != nil {
+			return err
+		}
+		for _, serverConfig := range servers {
+			err := serverConfig.ResetPhasesFrom(phase)
+			if err != nil {
+				return err
+			}
+		}
+		return p.writeConfig(config)
+	})
+}
+
+// Create creates all servers which don't exist yet and waits until all
+// servers completed cloud-init.
+func (p *Project) Create(ctx context.Context) error {
+	return p.run(ctx, (*operation).create)
+}
+
+// CreateServer creates the server with name. Returns an error if the server
+// exists already.
+func (p *Project) CreateServer(ctx context.Context, name string) error {
+	return p.run(ctx, func(op *operation) error {
+		config, err := findServer(op.servers, name)
+		if err != nil {
+			return err
+		}
+		if config.IsPhaseCompleted(server.PhaseServer) {
+			return fmt.Errorf("Server %s already exists", name)
+		}
+		err = op.validate(config)
+		if err != nil {
+			return err
+		}
+		return op.createServer(config)
+	})
+}
+
+// Provision generates the wireguard config of all servers and sets up the
+// private overlay network.
+func (p *Project) Provision(ctx context.Context) error {
+	return p.run(ctx, func(op *operation) error {
+		return op.setupNetwork(false)
+	})
+}
+
+// InstallEtcd installs etcd on all servers in role etcd.
+func (p *Project) InstallEtcd(ctx context.Context) error {
+	return p.run(ctx, func(op *operation) error {
+		return op.installEtcd(false)
+	})
+}
+
+// InstallControlPlane initialises the cluster on the first controller and
+// joins all other controllers to the control plane.
+func (p *Project) InstallControlPlane(ctx context.Context) error {
+	return p.run(ctx, func(op *operation) error {
+		return op.installControlPlane(false)
+	})
+}
+
+// JoinWorkers joins the workers with names to the cluster or, without names,
+// all servers in role worker. Workers join concurrently.
+func (p *Project) JoinWorkers(ctx context.Context, names ...string) error {
+	return p.run(ctx, func(op *operation) error {
+		workers := server.SelectHostsInRole(op.servers, "worker")
+		if len(names) > 0 {
+			workers = nil
+			for _, name := range names {
+				config, err := findServer(op.servers, name)
+				if err != nil {
+					return err
+				}
+				if !common.ArrayContains(config.Roles, "worker") {
+					return fmt.Errorf("Server %s is not in role worker", name)
+				}
+				workers = append(workers, config)
+			}
+		}
+		return op.joinWorkers(workers, false)
+	})
+}
+
+// Destroy deletes all servers and, for projects using hcloud, the SSH key at
+// hcloud. Servers of the static provider are not deleted, only their state is
+// removed from config.
+func (p *Project) Destroy(ctx context.Context) error {
+	return p.run(ctx, func(op *operation) error {
+		for _, config := range op.servers {
+			if !config.IsPhaseCompleted(server.PhaseServer) {
+				continue
+			}
+			err := op.ctx.Err()
+			if err != nil {
+				return err
+			}
+			err = op.deleteServer(config)
+			if err != nil {
+				return err
+			}
+		}
+		return op.deleteSSHKey()
+	})
+}
+
+// DestroyServer deletes the server with name. Servers of the static provider
+// are not deleted, only their state is removed from config.
+func (p *Project) DestroyServer(ctx context.Context, name string) error {
+	return p.run(ctx, func(op *operation) error {
+		config, err := findServer(op.servers, name)
+		if err != nil {
+			return err
+		}
+		return op.deleteServer(config)
+	})
+}
+
+func (op *operation) create() error {
+	var missing []*server.Config
+	for _, config := range op.servers {
+		if config.IsPhaseCompleted(server.PhaseServer) {
+			op.printf("Server %s already created, skipping\n", config.Name)
+			continue
+		}
+		missing = append(missing, config)
+	}
+	err := op.validate(missing...)
+	if err != nil {
+		return err
+	}
+
+	for _, config := range missing {
+		err = op.ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = op.createServer(config)
+		if err != nil {
+			return err
+		}
+	}
+	return op.waitForCloudInit()
+}
+
+// validate returns an error if any of configs can't be created by the
+// provider, so that no server is created if one of them is invalid.
+func (op *operation) validate(configs ...*server.Config) error {
+	if len(configs) == 0 {
+		return nil
+	}
+	serverProvider, err := op.provider()
+	if err != nil {
+		return err
+	}
+	for _, config := range configs {
+		err := serverProvider.Validate(config)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (op *operation) createServer(config *server.Config) error {
+	serverProvider, err := op.provider()
+	if err != nil {
+		return err
+	}
+
+	op.printf("Creating server %s using provider %s\n", config.Name, provider.ReadProviderNameIn(op.config))
+	err = serverProvider.Create(config)
+	if err != nil {
+		return err
+	}
+	err = op.completePhase(server.PhaseServer, config)
+	if err != nil {
+		return err
+	}
+	op.printf("Server %s successfully created.\n", config.Name)
+	return nil
+}
+
+var (
+	cloudInitRetries  = 20
+	cloudInitInterval = 20 * time.Second
+)
+
+func (op *operation) waitForCloudInit() error {
+	serverProvider, err := op.provider()
+	if err != nil {
+		return err
+	}
+
+	var waitGroup sync.WaitGroup
+	completed := make([]bool, len(op.servers))
+	for i, config := range op.servers {
+		if config.IsPhaseCompleted(server.PhaseCloudInit) {
+			completed[i] = true
+			continue
+		}
+		waitGroup.Add(1)
+		go func(i int, config *server.Config) {
+			defer waitGroup.Done()
+			completed[i] = op.waitForCloudInitCompleted(config, serverProvider)
+		}(i, config)
+	}
+	waitGroup.Wait()
+
+	err = op.ctx.Err()
+	if err != nil {
+		return err
+	}
+	for i, config := range op.servers {
+		if !completed[i] {
+			return fmt.Errorf("%s didn't complete cloud-init in time. Run install again to resume", config.Name)
+		}
+	}
+	return op.completePhase(server.PhaseCloudInit, op.servers...)
+}
+
+func (op *operation) waitForCloudInitCompleted(config *server.Config, serverProvider provider.Provider) bool {
+	op.printf("Waiting for %s to complete cloud-init\n", config.Name)
+
+	for retries := 0; retries < cloudInitRetries; retries++ {
+		if serverProvider.IsReady(config, op.ssh) {
+			op.printf("%s completed cloud-init\n", config.Name)
+			return true
+		}
+		select {
+		case <-op.ctx.Done():
+			return false
+		case <-time.After(cloudInitInterval):
+		}
+	}
+	return false
+}
+
+// setupNetwork sets up the overlay network. If skipCompleted is 'true', it is
+// skipped if all servers completed PhaseWireguard.
+func (op *operation) setupNetwork(skipCompleted bool) error {
+	if skipCompleted && allCompleted(server.PhaseWireguard, op.servers) {
+		op.printf("Private overlay network already set up, skipping\n")
+		return nil
+	}
+	err := op.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	op.printf("Setting up private overlay network\n")
+	err = network.SetupWireguardIn(op.config, op.ssh, op.servers)
+	if err != nil {
+		return err
+	}
+
+	for _, config := range op.servers {
+		op.printf("Wireguard set up on %s.\n", config.Name)
+	}
+	return op.completePhase(server.PhaseWireguard, op.servers...)
+}
+
+// installEtcd installs etcd. If skipCompleted is 'true', it is skipped if all
+// servers in role etcd completed PhaseEtcd.
+func (op *operation) installEtcd(skipCompleted bool) error {
+	etcdConfigs := server.SelectHostsInRole(op.servers, "etcd")
+	if skipCompleted && allCompleted(server.PhaseEtcd, etcdConfigs) {
+		op.printf("etcd already installed, skipping\n")
+		return nil
+	}
+	err := op.ctx.Err()
+	if err != nil {
+		return err
+	}
+
+	op.printf("Installing etcd\n")
+	certGenerator, err := certs.LoadCertGeneratorIn(op.config)
+	if err != nil {
+		return err
+	}
+	err = etcd.InstallOnHostIn(op.ctx, op.project.out(), op.config, op.servers, op.ssh, certGenerator)
+	if err != nil {
+		return err
+	}
+	return op.completePhase(server.PhaseEtcd, etcdConfigs...)
+}
+
+// installControlPlane initialises the cluster on the first controller and
+// joins all other controllers. If skipCompleted is 'true', controllers which
+// already completed PhaseController are skipped.
+func (op *operation) installControlPlane(skipCompleted bool) error {
+	err := op.ctx.Err()
+	if err != nil {
+		return err
+	}
+	op.printf("Installing kubernetes controller\n")
+
+	certLoader := certs.NewDefaultCertificateLoaderIn(op.config)
+	certGenerator, err := certs.LoadCertGeneratorIn(op.config)
+	if err != nil {
+		return err
+	}
+	return kube.InstallControlPlane(op.ctx, op.config, op.servers, op.ssh, certLoader, certGenerator, op.phaseHooks(server.PhaseController, skipCompleted))
+}
+
+// joinWorkers joins workers to the cluster concurrently. If skipCompleted is
+// 'true', workers which already completed PhaseWorker are skipped.
+func (op *operation) joinWorkers(workers []*server.Config, skipCompleted bool) error {
+	err := op.ctx.Err()
+	if err != nil {
+		return err
+	}
+	op.printf("Joining kubernetes workers\n")
+	return kube.JoinWorkers(op.ctx, op.project.out(), op.config, workers, op.servers, op.ssh, op.phaseHooks(server.PhaseWorker, skipCompleted))
+}
+
+// phaseHooks record phase once a server completed it. If skipCompleted is
+// 'true', servers which already completed phase are skipped.
+func (op *operation) phaseHooks(phase string, skipCompleted bool) kube.NodeHooks {
+	return kube.NodeHooks{
+		Skip: func(config *server.Config) bool {
+			if skipCompleted && config.IsPhaseCompleted(phase) {
+				op.printf("%s already completed phase %s, skipping\n", config.Name, phase)
+				return true
+			}
+			return false
+		},
+		Installed: func(config *server.Config) error {
+			op.printf("%s completed phase %s.\n", config.Name, phase)
+			return op.completePhase(phase, config)
+		}}
+}
+
+func (op *operation) deleteServer(config *server.Config) error {
+	if op.project.ResetKubernetes && config.SSHHost() != "" {
+		err := kube.ResetNode(config, op.ssh)
+		if err != nil {
+			op.printf("Resetting kubernetes on %s failed, deleting it anyway: %s\n", config.Name, err)
+		}
+	}
+
+	serverProvider, err := op.provider()
+	if err != nil {
+		return err
+	}
+	op.printf("Deleting server %s using provider %s\n", config.Name, provider.ReadProviderNameIn(op.config))
+	err = serverProvider.Delete(config)
+	if err != nil {
+		return err
+	}
+	err = op.writeConfig()
+	if err != nil {
+		return err
+	}
+	op.printf("Server %s successfully deleted.\n", config.Name)
+	return nil
+}
+
+// deleteSSHKey deletes the SSH key of the project at hcloud. Projects using
+// other providers have no SSH key at a provider.
+func (op *operation) deleteSSHKey() error {
+	if provider.ReadProviderNameIn(op.config) != provider.HCloud {
+		return nil
+	}
+	if op.project.DryRun {
+		op.printf("Would delete SSH key at hcloud\n")
+		return nil
+	}
+
+	hcloudClient, err := hcloudclient.NewHCloudClient(op.project.APIToken)
+	if err != nil {
+		return err
+	}
+	sshKey, err := sshkey.ReadSSHPublicKeyIn(op.config)
+	if err != nil {
+		return err
+	}
+	deletedKey, err := sshkey.DeleteSSHKey(*sshKey, hcloudClient)
+	if err != nil {
+		return err
+	}
+	deletedKey.WriteToConfigIn(op.config)
+
+	err = op.writeConfig()
+	if err != nil {
+		return err
+	}
+	op.printf("SSH key %s deleted at hcloud.\n", sshKey.Name)
+	return nil
+}
+
+func allCompleted(phase string, configs []*server.Config) bool {
+	for _, config := range configs {
+		if !config.IsPhaseCompleted(phase) {
+			return false
+		}
+	}
+	return true
+}
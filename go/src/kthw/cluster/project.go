@@ -0,0 +1,306 @@
+// Package cluster creates servers, installs a Kubernetes cluster on them and
+// destroys them again. It is the API the kthw command line is built on and can
+// be used by other Go programs as well.
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"kthw/cmd/common"
+	"kthw/cmd/infra/provider"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/projectconfig"
+	"kthw/cmd/sshconnect"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// Project is the configuration of a cluster read from a config file, e.g.
+// project.yaml, and the settings its operations run with. Every operation
+// records the phases servers completed in the config file, so that it can be
+// resumed after a failure.
+//
+// Cancelling the context passed to an operation stops it before its next step.
+// Commands running on servers are killed, unless Project.SSH runs them.
+//
+// A Project holds its own configuration. Operations of one project run one
+// after another, operations of different projects run concurrently.
+type Project struct {
+	// APIToken authenticates at the hcloud API. Only the hcloud provider needs it.
+	APIToken string
+	// DryRun prints the commands and file uploads of every server instead of
+	// running them. No server is created or deleted and the config file isn't written.
+	DryRun bool
+	// Verbose prints the output of all commands while they run.
+	Verbose bool
+	// Out receives the progress of operations and, in dry-run mode, the
+	// commands they would run. nil means os.Stdout.
+	Out io.Writer
+	// ResetKubernetes runs 'kubeadm reset' on servers before deleting them.
+	ResetKubernetes bool
+	// SSH runs commands on servers instead of connections opened by the
+	// project, e.g. a mock in tests. It isn't closed by the project.
+	SSH sshconnect.SSHOperations
+	// Provider creates and deletes servers instead of the provider configured
+	// for the project.
+	Provider provider.Provider
+
+	configFile string
+	// mutex serializes operations, which change config.
+	mutex  sync.Mutex
+	config *viper.Viper
+	// outMutex serializes writes to Out by steps running concurrently.
+	outMutex sync.Mutex
+}
+
+// Open reads the project configured in configFile. Config files of older
+// versions are migrated, the migration is written with the first change.
+// Returns a *projectconfig.ValidationError if the config file is invalid.
+func Open(configFile string) (*Project, error) {
+	config := viper.New()
+	config.SetConfigFile(configFile)
+	err := config.ReadInConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading project from '%s': %w", configFile, err)
+	}
+	_, err = projectconfig.Load(config)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading project from '%s': %w", configFile, err)
+	}
+	return &Project{configFile: configFile, config: config}, nil
+}
+
+// ConfigFile returns the path of the config file of the project.
+func (p *Project) ConfigFile() string {
+	return p.configFile
+}
+
+// Servers returns the configuration of all servers of the project.
+func (p *Project) Servers() ([]*server.Config, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	config, err := p.copyConfig()
+	if err != nil {
+		return nil, err
+	}
+	return server.AllFromConfigIn(config)
+}
+
+// SSHClient returns SSHOperations reaching the servers of the project or, in
+// dry-run mode, printing the commands on Close. Close it when done.
+func (p *Project) SSHClient() (sshconnect.SSHOperations, error) {
+	var client sshconnect.SSHOperations
+	err := p.withConfig(func(config *viper.Viper) error {
+		servers, err := server.AllFromConfigIn(config)
+		if err != nil {
+			// Projects without servers have no routes.
+			servers = nil
+		}
+		client, err = p.newSSHClient(context.Background(), config, servers)
+		return err
+	})
+	return client, err
+}
+
+// TrustHost accepts the host key the server with name presents now. Returns
+// the fingerprint of the key.
+func (p *Project) TrustHost(ctx context.Context, name string) (string, error) {
+	err := ctx.Err()
+	if err != nil {
+		return "", err
+	}
+
+	var fingerprint string
+	err = p.withConfig(func(config *viper.Viper) error {
+		servers, err := server.AllFromConfigIn(config)
+		if err != nil {
+			return err
+		}
+		serverConfig, err := findServer(servers, name)
+		if err != nil {
+			return err
+		}
+		if serverConfig.SSHHost() == "" {
+			return fmt.Errorf("Server %s has no public IP", serverConfig.Name)
+		}
+
+		sshConnect, err := newSSHConnect(ctx, config, p.Verbose, servers)
+		if err != nil {
+			return err
+		}
+		defer sshConnect.Close()
+		fingerprint, err = sshConnect.TrustHost(serverConfig.SSHHost())
+		return err
+	})
+	return fingerprint, err
+}
+
+// withConfig calls use with the configuration of the project. Changes made by
+// use are kept by the project, unless it runs in dry-run mode. Then use gets a
+// copy of the configuration.
+func (p *Project) withConfig(use func(config *viper.Viper) error) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if !p.DryRun {
+		return use(p.config)
+	}
+	config, err := p.copyConfig()
+	if err != nil {
+		return err
+	}
+	return use(config)
+}
+
+// copyConfig returns a copy of the configuration of the project. Call it only
+// while holding the mutex of the project.
+func (p *Project) copyConfig() (*viper.Viper, error) {
+	config := viper.New()
+	config.SetConfigFile(p.configFile)
+	err := config.MergeConfigMap(p.config.AllSettings())
+	if err != nil {
+		return nil, fmt.Errorf("Error while copying config of project: %w", err)
+	}
+	return config, nil
+}
+
+// operation is what a step of an operation of a project works with.
+type operation struct {
+	ctx            context.Context
+	project        *Project
+	config         *viper.Viper
+	ssh            sshconnect.SSHOperations
+	serverProvider provider.Provider
+	servers        []*server.Config
+}
+
+// run calls step with the servers of the project and SSHOperations reaching
+// them. Returns ctx.Err() without calling step if ctx is done already.
+func (p *Project) run(ctx context.Context, step func(op *operation) error) error {
+	err := ctx.Err()
+	if err != nil {
+		return err
+	}
+	return p.withConfig(func(config *viper.Viper) error {
+		servers, err := server.AllFromConfigIn(config)
+		if err != nil {
+			return err
+		}
+
+		ssh, err := p.newSSHClient(ctx, config, servers)
+		if err != nil {
+			return err
+		}
+		defer ssh.Close()
+		return step(&operation{ctx: ctx, project: p, config: config, ssh: ssh, servers: servers})
+	})
+}
+
+// newSSHClient returns SSHOperations running commands on servers or, in
+// dry-run mode, recording them and printing them on Close. Routes to servers
+// follow changes made to servers. Commands stop once ctx is done.
+func (p *Project) newSSHClient(ctx context.Context, config *viper.Viper, servers []*server.Config) (sshconnect.SSHOperations, error) {
+	if p.SSH != nil {
+		return nopCloser{p.SSH}, nil
+	}
+	if p.DryRun {
+		return sshconnect.NewDryRun(p.out()), nil
+	}
+	return newSSHConnect(ctx, config, p.Verbose, servers)
+}
+
+// out returns Out or os.Stdout. Writes are serialized, because steps print
+// their progress concurrently.
+func (p *Project) out() io.Writer {
+	out := p.Out
+	if out == nil {
+		out = os.Stdout
+	}
+	return &lockedWriter{mutex: &p.outMutex, out: out}
+}
+
+type lockedWriter struct {
+	mutex *sync.Mutex
+	out   io.Writer
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.out.Write(p)
+}
+
+// printf prints the progress of the operation to the output of the project.
+func (op *operation) printf(format string, args ...interface{}) {
+	fmt.Fprintf(op.project.out(), format, args...)
+}
+
+// nopCloser keeps SSHOperations set by callers of a project open.
+type nopCloser struct {
+	sshconnect.SSHOperations
+}
+
+func (n nopCloser) Close() error {
+	return nil
+}
+
+// provider returns the provider of the project. In dry-run mode, providers
+// calling an API are replaced by a DryRunProvider.
+func (op *operation) provider() (provider.Provider, error) {
+	if op.serverProvider != nil {
+		return op.serverProvider, nil
+	}
+
+	var err error
+	switch {
+	case op.project.Provider != nil:
+		op.serverProvider = op.project.Provider
+	case op.project.DryRun && provider.ReadProviderNameIn(op.config) != provider.Static:
+		op.serverProvider = provider.NewDryRunProvider(provider.ReadProviderNameIn(op.config), op.project.out())
+	default:
+		op.serverProvider, err = provider.NewIn(op.config, op.project.APIToken, op.ssh)
+	}
+	return op.serverProvider, err
+}
+
+// findServer returns the server with name. Returns an error of class
+// common.ErrNotFound if there is no such server.
+func findServer(servers []*server.Config, name string) (*server.Config, error) {
+	for _, config := range servers {
+		if config.Name == name {
+			return config, nil
+		}
+	}
+	return nil, common.Classify(common.ErrNotFound, fmt.Errorf("Server %s not found in config", name))
+}
+
+// completePhase records that configs completed phase and writes the config file.
+func (op *operation) completePhase(phase string, configs ...*server.Config) error {
+	for _, config := range configs {
+		err := config.CompletePhase(phase)
+		if err != nil {
+			return err
+		}
+	}
+	return op.writeConfig()
+}
+
+// writeConfig writes the config of the operation to the config file.
+func (op *operation) writeConfig() error {
+	return op.project.writeConfig(op.config)
+}
+
+// writeConfig writes config to the config file. In dry-run mode, nothing is written.
+func (p *Project) writeConfig(config *viper.Viper) error {
+	if p.DryRun {
+		return nil
+	}
+	err := config.WriteConfig()
+	if err != nil {
+		return fmt.Errorf("Error while writing config file '%s': %w", p.configFile, err)
+	}
+	return nil
+}
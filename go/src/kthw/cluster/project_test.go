@@ -0,0 +1,210 @@
+package cluster_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"kthw/cluster"
+	"kthw/cmd/common"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var staticProjectConfig = `provider:
+  name: static
+hcloud:
+  server:
+    controller-1:
+      publicIP: 192.168.1.1
+      roles: [controller, etcd]
+    worker-1:
+      publicIP: 192.168.1.2
+      roles: [worker]
+`
+
+var otherStaticProjectConfig = `provider:
+  name: static
+hcloud:
+  server:
+    controller-1:
+      publicIP: 192.168.2.1
+      roles: [controller, etcd, worker]
+`
+
+func helperOpenProject(t *testing.T) (*cluster.Project, *sshconnect.SSHOperationsMock) {
+	return helperOpenProjectWithConfig(t, staticProjectConfig)
+}
+
+func helperOpenProjectWithConfig(t *testing.T, projectConfig string) (*cluster.Project, *sshconnect.SSHOperationsMock) {
+	tempDirName, err := ioutil.TempDir("", "Project")
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile := filepath.Join(tempDirName, "project.yaml")
+	err = ioutil.WriteFile(configFile, []byte(projectConfig), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	project, err := cluster.Open(configFile)
+	if err != nil {
+		t.Fatalf("Error while opening project: %s", err)
+	}
+	ssh := sshconnect.NewSSHOperationsMock()
+	project.SSH = ssh
+	return project, ssh
+}
+
+func helperEnsurePhaseCompleted(t *testing.T, project *cluster.Project, phase string, expected bool) {
+	servers, err := project.Servers()
+	if err != nil {
+		t.Fatalf("Error while reading servers of project: %s", err)
+	}
+	for _, config := range servers {
+		if config.IsPhaseCompleted(phase) != expected {
+			t.Errorf("Expected completion of phase %s by %s to be '%t'", phase, config.Name, expected)
+		}
+	}
+}
+
+func TestProvisionRecordsPhaseInConfigFile(t *testing.T) {
+	project, ssh := helperOpenProject(t)
+
+	err := project.Provision(context.Background())
+	if err != nil {
+		t.Fatalf("Provision returned an unexpected error: %s", err)
+	}
+	sshconnect.EnsureCommandIssued(ssh.RunCmdsCommands, "Open firewall for private overlay network", "192.168.1.1", t)
+	sshconnect.EnsureCommandIssued(ssh.RunCmdsCommands, "Open firewall for private overlay network", "192.168.1.2", t)
+	helperEnsurePhaseCompleted(t, project, server.PhaseWireguard, true)
+
+	reopened, err := cluster.Open(project.ConfigFile())
+	if err != nil {
+		t.Fatalf("Error while opening project again: %s", err)
+	}
+	helperEnsurePhaseCompleted(t, reopened, server.PhaseWireguard, true)
+}
+
+func TestProjectsKeepTheirOwnConfig(t *testing.T) {
+	project, _ := helperOpenProject(t)
+	other, _ := helperOpenProject(t)
+
+	err := project.Provision(context.Background())
+	if err != nil {
+		t.Fatalf("Provision returned an unexpected error: %s", err)
+	}
+	helperEnsurePhaseCompleted(t, other, server.PhaseWireguard, false)
+}
+
+func TestProjectsRunConcurrently(t *testing.T) {
+	viper.Reset()
+	project, ssh := helperOpenProject(t)
+	other, otherSSH := helperOpenProjectWithConfig(t, otherStaticProjectConfig)
+
+	var waitGroup sync.WaitGroup
+	errs := make([]error, 2)
+	for i, p := range []*cluster.Project{project, other} {
+		waitGroup.Add(1)
+		go func(i int, p *cluster.Project) {
+			defer waitGroup.Done()
+			errs[i] = p.Provision(context.Background())
+		}(i, p)
+	}
+	waitGroup.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			t.Fatalf("Provision returned an unexpected error: %s", err)
+		}
+	}
+	sshconnect.EnsureCommandIssued(ssh.RunCmdsCommands, "Open firewall for private overlay network", "192.168.1.2", t)
+	sshconnect.EnsureCommandIssued(otherSSH.RunCmdsCommands, "Open firewall for private overlay network", "192.168.2.1", t)
+	helperEnsurePhaseCompleted(t, project, server.PhaseWireguard, true)
+	helperEnsurePhaseCompleted(t, other, server.PhaseWireguard, true)
+	if keys := viper.AllKeys(); len(keys) != 0 {
+		t.Errorf("Expected projects to leave the global config untouched, but found %v", keys)
+	}
+}
+
+func TestCancelledOperationRunsNoCommands(t *testing.T) {
+	project, ssh := helperOpenProject(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := project.Provision(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got '%v'", err)
+	}
+	if len(ssh.RunCmdsCommands) != 0 {
+		t.Errorf("Expected no commands after cancellation, but %d were run", len(ssh.RunCmdsCommands))
+	}
+}
+
+func TestDryRunDoesNotRecordPhases(t *testing.T) {
+	project, _ := helperOpenProject(t)
+	project.DryRun = true
+
+	err := project.Provision(context.Background())
+	if err != nil {
+		t.Fatalf("Provision returned an unexpected error: %s", err)
+	}
+	helperEnsurePhaseCompleted(t, project, server.PhaseWireguard, false)
+}
+
+func TestProgressIsPrintedToOut(t *testing.T) {
+	project, _ := helperOpenProject(t)
+	var out bytes.Buffer
+	project.Out = &out
+
+	err := project.Provision(context.Background())
+	if err != nil {
+		t.Fatalf("Provision returned an unexpected error: %s", err)
+	}
+	for _, expected := range []string{"Setting up private overlay network", "Wireguard set up on controller-1.", "Wireguard set up on worker-1."} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected output to contain '%s', but was:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestDryRunPrintsServersCreatedToOut(t *testing.T) {
+	project, _ := helperOpenProjectWithConfig(t, `hcloud:
+  server:
+    controller-1:
+      roles: [controller, etcd, worker]
+`)
+	project.DryRun = true
+	var out bytes.Buffer
+	project.Out = &out
+
+	err := project.Create(context.Background())
+	if err != nil {
+		t.Fatalf("Create returned an unexpected error: %s", err)
+	}
+	if !strings.Contains(out.String(), "Would create server controller-1 using provider hcloud") {
+		t.Errorf("Expected planned server to be printed to Out, but output was:\n%s", out.String())
+	}
+}
+
+func TestJoinWorkersFailsForOtherServers(t *testing.T) {
+	project, ssh := helperOpenProject(t)
+
+	err := project.JoinWorkers(context.Background(), "controller-1")
+	if err == nil {
+		t.Errorf("Expected error, because controller-1 is not in role worker")
+	}
+	err = project.JoinWorkers(context.Background(), "worker-2")
+	if !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("Expected error of class ErrNotFound for unknown worker, but got '%v'", err)
+	}
+	if len(ssh.RunCmdsCommands) != 0 || len(ssh.RunCmdCommands) != 0 {
+		t.Errorf("Expected no commands if workers are invalid")
+	}
+}
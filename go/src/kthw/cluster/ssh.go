@@ -0,0 +1,55 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+
+	"github.com/spf13/viper"
+)
+
+// newSSHConnect returns an SSHConnect which reaches every server through the
+// jump hosts configured for it or, if none are, for the project. Servers are
+// looked up in configs, so that routes follow changes of their IPs.
+func newSSHConnect(ctx context.Context, config *viper.Viper, logOutputFromServer bool, configs []*server.Config) (*sshconnect.SSHConnect, error) {
+	sshConnect, err := sshconnect.NewSSHConnectIn(ctx, config, logOutputFromServer)
+	if err != nil {
+		return nil, err
+	}
+
+	sshConnect.SetServerLookup(serverLookup(configs))
+	for _, config := range configs {
+		if config.ProxyJump == "" {
+			continue
+		}
+		err := sshConnect.SetProxyJump(config.Name, config.ProxyJump)
+		if err != nil {
+			sshConnect.Close()
+			return nil, fmt.Errorf("Invalid proxy jump of server %s: %w", config.Name, err)
+		}
+	}
+	return sshConnect, nil
+}
+
+// serverLookup finds servers in the configs of a project. Jump hosts are
+// reached at their public IP.
+type serverLookup []*server.Config
+
+func (s serverLookup) NameOf(host string) string {
+	for _, config := range s {
+		if host == config.SSHHost() || host == config.PublicIP || host == config.PrivateIP {
+			return config.Name
+		}
+	}
+	return ""
+}
+
+func (s serverLookup) HostOf(name string) string {
+	for _, config := range s {
+		if config.Name == name {
+			return config.PublicIP
+		}
+	}
+	return ""
+}
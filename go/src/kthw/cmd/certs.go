@@ -1,14 +1,37 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"kthw/certs"
+	"kthw/cmd/cluster/etcd"
+	"kthw/cmd/cluster/kube"
 	"kthw/cmd/common"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"kthw/secrets"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
+const (
+	rotateKindEtcd       = "etcd"
+	rotateKindEtcdClient = "etcd-client"
+)
+
+var rotateKinds = []string{rotateKindEtcd, rotateKindEtcdClient}
+
+// Directories of certificates installed on nodes by etcd, kubeadm and kubelet.
+var installedCertDirs = []string{
+	"/etc/etcd/pki",
+	"/etc/kubernetes/pki",
+	"/etc/kubernetes/pki/etcd",
+	"/var/lib/kubelet/pki"}
+
 var certsCommand = &cobra.Command{Use: "certs", Short: "Provision a certificate authority and generate certificates"}
 
 var initCACommand = &cobra.Command{
@@ -16,8 +39,12 @@ var initCACommand = &cobra.Command{
 	Short: "Generates CA public and private key",
 	Run: func(cmd *cobra.Command, args []string) {
 		conf := certs.ReadConfig()
-		caCerts := certs.DefaultCACerts(conf.BaseDir)
-		err := caCerts.InitCa()
+		common.WhenErrPrintAndExit(conf.Validate())
+		caCerts := certs.DefaultCACerts(conf)
+		sealer, err := secrets.ReadSealer()
+		common.WhenErrPrintAndExit(err)
+		caCerts.Sealer = sealer
+		err = caCerts.InitCa()
 		if err != nil {
 			fmt.Printf("Error while initiation CA: %s\n", err)
 			os.Exit(1)
@@ -34,6 +61,116 @@ var genEtcdClientCertificateCommand = &cobra.Command{
 		generateAndWriteEtcdClientCert(certGenerator)
 	}}
 
+var certsStatusCommand = &cobra.Command{
+	Use:   "status",
+	Short: "Lists local certificates and certificates installed on nodes with their expiry",
+	Long:  "Projects without servers only list the local certificates in certs.baseDir.",
+	Run: func(cmd *cobra.Command, args []string) {
+		conf := certs.ReadConfig()
+		infos, err := certs.InspectDir(conf.BaseDir)
+		common.WhenErrPrintAndExit(err)
+
+		serverConfigs, err := server.AllFromConfigWithoutSecrets()
+		if errors.Is(err, common.ErrNotFound) {
+			serverConfigs = nil
+		} else {
+			common.WhenErrPrintAndExit(err)
+		}
+		var provisioned []*server.Config
+		for _, serverConfig := range serverConfigs {
+			if serverConfig.IsPhaseCompleted(server.PhaseServer) {
+				provisioned = append(provisioned, serverConfig)
+			}
+		}
+		if len(provisioned) == 0 {
+			printCertInfos(infos)
+			return
+		}
+
+		sshClient := newSSHClient()
+		defer sshClient.Close()
+		for _, serverConfig := range provisioned {
+			installedInfos, err := listInstalledCerts(serverConfig, sshClient)
+			if err != nil {
+				fmt.Printf("Error while reading certificates of server %s: %s\n", serverConfig.Name, err)
+				continue
+			}
+			infos = append(infos, installedInfos...)
+		}
+
+		printCertInfos(infos)
+	}}
+
+var certsRotateCommand = &cobra.Command{
+	Use:         "rotate <kind>",
+	Short:       "Re-issues certificates, uploads them to nodes and restarts affected services",
+	Annotations: supportsDryRun,
+	Long: "Kind etcd re-issues the server certificates of all etcd members and restarts them one after another. " +
+		"Kind etcd-client re-issues the certificate the API servers use to access etcd and restarts the API servers. " +
+		"Other certificates listed by status, i.e. the CAs and the certificates generated by kubeadm, can't be rotated by kthw.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 {
+			return fmt.Errorf("Expected exactly one argument, but found '%d'", len(args))
+		}
+		for _, kind := range rotateKinds {
+			if args[0] == kind {
+				return nil
+			}
+		}
+		return fmt.Errorf("'%s' is not a valid kind. Valid kinds are: %s", args[0], strings.Join(rotateKinds, ", "))
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		sshClient := newSSHClient()
+		defer sshClient.Close()
+		certGenerator, err := certs.LoadCertGenerator()
+		common.WhenErrPrintAndExit(err)
+		serverConfigs, err := server.AllFromConfig()
+		common.WhenErrPrintAndExit(err)
+
+		switch args[0] {
+		case rotateKindEtcd:
+			err = etcd.RotateCertificates(serverConfigs, sshClient, certGenerator)
+		case rotateKindEtcdClient:
+			err = kube.RotateEtcdClientCertificate(serverConfigs, sshClient, certGenerator)
+		}
+		common.WhenErrPrintAndExit(err)
+
+		fmt.Printf("Certificates of kind %s successfully rotated.\n", args[0])
+	}}
+
+func listInstalledCerts(config *server.Config, sshClient sshconnect.SSHOperations) ([]*certs.CertInfo, error) {
+	var patterns []string
+	for _, dir := range installedCertDirs {
+		patterns = append(patterns, dir+"/*.crt")
+	}
+	command := &sshconnect.ShellCommand{
+		CommandLine: fmt.Sprintf(
+			"for f in %s; do [ -f \"$f\" ] && echo \"%s$f\" && cat \"$f\" && echo; done; true",
+			strings.Join(patterns, " "), certs.CertBundleFileMarker),
+		Host:        config.SSHHost(),
+		Description: "Read installed certificates"}
+	output, err := sshClient.RunCmd(command, false)
+	if err != nil {
+		return nil, err
+	}
+	return certs.ParseCertBundle(config.Name, output)
+}
+
+func printCertInfos(infos []*certs.CertInfo) {
+	now := time.Now()
+	writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(writer, "LOCATION\tSUBJECT\tSANS\tEXPIRES\tDAYS REMAINING")
+	for _, info := range infos {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%d\n",
+			info.Location,
+			info.Subject,
+			strings.Join(info.SANs, ","),
+			info.NotAfter.Format("2006-01-02"),
+			info.DaysRemaining(now))
+	}
+	writer.Flush()
+}
+
 func generateAndWriteEtcdClientCert(certGenerator *certs.CertGenerator) {
 	fmt.Printf("Generating etcd client certificate.\n")
 	etcdClientCert, err := certGenerator.GenEtcdClientCertificate()
@@ -45,11 +182,7 @@ func generateAndWriteEtcdClientCert(certGenerator *certs.CertGenerator) {
 }
 
 func newCertificateGenerator() *certs.CertGenerator {
-	ca, err := certs.NewDefaultCertificateLoader().LoadCA()
-	common.WhenErrPrintAndExit(err)
-
-	conf := certs.ReadConfig()
-	certGenerator, err := certs.NewCertGenerator(ca, conf)
+	certGenerator, err := certs.LoadCertGenerator()
 	common.WhenErrPrintAndExit(err)
 	return certGenerator
 }
@@ -57,5 +190,7 @@ func newCertificateGenerator() *certs.CertGenerator {
 func certsCommands() *cobra.Command {
 	certsCommand.AddCommand(initCACommand)
 	certsCommand.AddCommand(genEtcdClientCertificateCommand)
+	certsCommand.AddCommand(certsStatusCommand)
+	certsCommand.AddCommand(certsRotateCommand)
 	return certsCommand
 }
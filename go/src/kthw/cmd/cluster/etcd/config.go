@@ -8,10 +8,23 @@ import (
 
 // SystemdServiceParameters parameters used to generate etcs systemd service.
 type SystemdServiceParameters struct {
+	PrivateIP      string
+	NodeName       string
+	ClusterMembers []ClusterMember
+}
+
+// ClusterMember is a member of the etcd cluster listed in --initial-cluster.
+type ClusterMember struct {
+	Name      string
 	PrivateIP string
-	NodeName  string
 }
 
+// PeerURL returns the URL other members use to connect to this member.
+func (m ClusterMember) PeerURL() string { return fmt.Sprintf("https://%s:2380", m.PrivateIP) }
+
+// ClientURL returns the URL clients use to connect to this member.
+func (m ClusterMember) ClientURL() string { return fmt.Sprintf("https://%s:2379", m.PrivateIP) }
+
 var etcdSystemdService = `[Unit]
 Description=etcd
 
@@ -26,7 +39,9 @@ ExecStart=/usr/local/bin/etcd \
   --data-dir /var/lib/etcd \
   --listen-client-urls "https://{{.PrivateIP}}:2379,https://localhost:2379" \
   --advertise-client-urls "https://{{.PrivateIP}}:2379" \
-  --initial-cluster "{{.NodeName}}=https://{{.PrivateIP}}:2380" \
+  --initial-cluster "{{range $i, $m := .ClusterMembers}}{{if $i}},{{end}}{{$m.Name}}={{$m.PeerURL}}{{end}}" \
+  --initial-cluster-state new \
+  --initial-cluster-token etcd-cluster \
   --initial-advertise-peer-urls "https://{{.PrivateIP}}:2380" \
   --listen-peer-urls "https://{{.PrivateIP}}:2380" \
   --heartbeat-interval 200 \
@@ -0,0 +1,27 @@
+package etcd_test
+
+import (
+	"kthw/cmd/cluster/etcd"
+	"strings"
+	"testing"
+)
+
+func TestGenerateSystemdServiceWithInitialCluster(t *testing.T) {
+	params := etcd.SystemdServiceParameters{
+		PrivateIP: "10.0.0.1",
+		NodeName:  "etcd-1",
+		ClusterMembers: []etcd.ClusterMember{
+			etcd.ClusterMember{Name: "etcd-1", PrivateIP: "10.0.0.1"},
+			etcd.ClusterMember{Name: "etcd-2", PrivateIP: "10.0.0.2"},
+			etcd.ClusterMember{Name: "etcd-3", PrivateIP: "10.0.0.3"}}}
+
+	service, err := etcd.GenerateSystemdService(params)
+	if err != nil {
+		t.Fatalf("Error while generating etcd systemd service: %s", err)
+	}
+
+	expected := `--initial-cluster "etcd-1=https://10.0.0.1:2380,etcd-2=https://10.0.0.2:2380,etcd-3=https://10.0.0.3:2380"`
+	if !strings.Contains(service, expected) {
+		t.Errorf("Expected systemd service to contain '%s', but it didn't.\n%s", expected, service)
+	}
+}
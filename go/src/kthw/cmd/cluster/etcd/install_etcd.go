@@ -2,49 +2,121 @@ package etcd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"kthw/certs"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
 	"os"
 	"strings"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 // InstallOnHost selects hosts with role 'etcd' and installs etcd on it.
-// Currently, only single node clusters are supported.
+// All hosts in role etcd become members of one cluster. Use an odd number
+// of members (1, 3 or 5) so that the cluster is able to build a quorum.
 func InstallOnHost(hostConfigs []*server.Config, ssh sshconnect.SSHOperations, generateCerts certs.GeneratesCerts) error {
+	return InstallOnHostIn(context.Background(), os.Stdout, viper.GetViper(), hostConfigs, ssh, generateCerts)
+}
+
+// InstallOnHostIn installs etcd like InstallOnHost with the concurrency
+// configured in config. Waiting for the quorum stops once ctx is done. Its
+// progress is printed to out.
+func InstallOnHostIn(ctx context.Context, out io.Writer, config *viper.Viper, hostConfigs []*server.Config, ssh sshconnect.SSHOperations, generateCerts certs.GeneratesCerts) error {
 	etcdHosts := server.SelectHostsInRole(hostConfigs, "etcd")
 	if len(etcdHosts) == 0 {
 		return fmt.Errorf("List of provided hosts didn't contain a host with role etcd")
 	}
 
-	if len(etcdHosts) > 1 {
-		return fmt.Errorf("Several hosts have role etcd. Currently, only single node etcd clusters are allowed")
-	}
+	members := clusterMembers(etcdHosts)
 
-	for _, etcdHost := range etcdHosts {
-		host := etcdHost.PublicIP
-		certHostnames := []string{"localhost", etcdHost.PrivateIP}
-		etcdCert, err := generateCerts.GenEtcdCertificate(certHostnames)
+	// Certificates are generated one after another, before installing
+	// etcd on all hosts concurrently.
+	installBatches := make([]*sshconnect.Commands, len(etcdHosts))
+	startBatches := make([]*sshconnect.Commands, len(etcdHosts))
+	for i, etcdHost := range etcdHosts {
+		host := etcdHost.SSHHost()
+		etcdCert, err := generateCerts.GenEtcdCertificate(certHostnames(etcdHost))
 		if err != nil {
-			return fmt.Errorf("Error while generating etcd certificate: %s", err)
+			return fmt.Errorf("Error while generating etcd certificate: %w", err)
 		}
-		commands := &sshconnect.Commands{
+		systemdService, err := uploadSystemdService(etcdHost, members)
+		if err != nil {
+			return err
+		}
+		installBatches[i] = &sshconnect.Commands{
 			Commands: []sshconnect.Command{
 				downloadEtcd(host),
 				unpackAndInstall(host),
 				uploadEtcdCertPrivateKey(host, etcdCert),
 				uploadEtcdCertPublicKey(host, etcdCert),
-				uploadCAPublicKey(host, generateCerts.GetCA()),
-				uploadSystemdService(etcdHost),
-				enableAndStartEtcdSystemdService(host)},
+				uploadCAPublicKey(host, generateCerts.GetIntermediateCA(certs.EtcdCA)),
+				systemdService},
 			LogOutput: true}
-		err = ssh.RunCmds(commands)
-		if err != nil {
-			return err
+		startBatches[i] = &sshconnect.Commands{
+			Commands:  []sshconnect.Command{enableAndStartEtcdSystemdService(host)},
+			LogOutput: true}
+	}
+
+	parallel := sshconnect.ReadParallelIn(config)
+	err := parallel.RunCmds(ssh, installBatches)
+	if err != nil {
+		return err
+	}
+
+	// Members block on start until a quorum was reached. Thus, start
+	// etcd on all members before waiting for the cluster to become healthy.
+	err = parallel.RunCmds(ssh, startBatches)
+	if err != nil {
+		return err
+	}
+
+	return waitForQuorum(ctx, out, etcdHosts[0], members, ssh)
+}
+
+var (
+	healthCheckRetries  = 30
+	healthCheckInterval = 10 * time.Second
+)
+
+func waitForQuorum(ctx context.Context, out io.Writer, hostConfig *server.Config, members []ClusterMember, ssh sshconnect.SSHOperations) error {
+	quorum := len(members)/2 + 1
+	command := checkEndpointHealth(hostConfig.SSHHost(), members)
+	for retries := 0; retries < healthCheckRetries; retries++ {
+		output, err := ssh.RunCmd(command, false)
+		if sshconnect.IsDryRun(ssh) {
+			return nil
+		}
+		if err == nil {
+			healthy := strings.Count(output, "is healthy")
+			if healthy >= quorum {
+				fmt.Fprintf(out, "etcd cluster is healthy. %d of %d members are available.\n", healthy, len(members))
+				return nil
+			}
+			fmt.Fprintf(out, "Waiting for etcd quorum. %d of %d required members are healthy.\n", healthy, quorum)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(healthCheckInterval):
 		}
 	}
-	return nil
+	return fmt.Errorf("etcd cluster did not reach a quorum of %d members", quorum)
+}
+
+func certHostnames(etcdHost *server.Config) []string {
+	return []string{"localhost", "127.0.0.1", etcdHost.Name, etcdHost.PrivateIP}
+}
+
+func clusterMembers(etcdHosts []*server.Config) []ClusterMember {
+	members := make([]ClusterMember, len(etcdHosts))
+	for i, etcdHost := range etcdHosts {
+		members[i] = ClusterMember{Name: etcdHost.Name, PrivateIP: etcdHost.PrivateIP}
+	}
+	return members
 }
 
 func uploadEtcdCertPublicKey(host string, etcdCert *certs.EtcdCert) *sshconnect.CopyFileCommand {
@@ -52,6 +124,7 @@ func uploadEtcdCertPublicKey(host string, etcdCert *certs.EtcdCert) *sshconnect.
 		Host:        host,
 		FileContent: bytes.NewReader(etcdCert.PublicKeyBytes),
 		FilePath:    "/etc/etcd/pki/etcd.crt",
+		Atomic:      true,
 		Description: "Upload etcd certificate public key to /etc/etcd/pki/etcd.crt"}
 }
 
@@ -60,6 +133,9 @@ func uploadEtcdCertPrivateKey(host string, etcdCert *certs.EtcdCert) *sshconnect
 		Host:        host,
 		FileContent: bytes.NewReader(etcdCert.PrivateKeyBytes),
 		FilePath:    "/etc/etcd/pki/etcd.key",
+		Mode:        sshconnect.PrivateKeyFileMode,
+		Atomic:      true,
+		Sensitive:   true,
 		Description: "Upload etcd certificate private key to /etc/etcd/pki/etcd.key"}
 }
 
@@ -68,31 +144,33 @@ func uploadCAPublicKey(host string, ca *certs.CA) *sshconnect.CopyFileCommand {
 		Host:        host,
 		FileContent: bytes.NewReader(ca.CertBytes),
 		FilePath:    "/etc/etcd/pki/ca.crt",
+		Atomic:      true,
 		Description: "Upload CA certificate public key to /etc/etcd/pki/ca.crt"}
 }
 
-func uploadSystemdService(hostConfig *server.Config) *sshconnect.CopyFileCommand {
+func uploadSystemdService(hostConfig *server.Config, members []ClusterMember) (*sshconnect.CopyFileCommand, error) {
 	params := SystemdServiceParameters{
-		PrivateIP: hostConfig.PrivateIP,
-		NodeName:  hostConfig.Name}
+		PrivateIP:      hostConfig.PrivateIP,
+		NodeName:       hostConfig.Name,
+		ClusterMembers: members}
 	systemdService, err := GenerateSystemdService(params)
 	if err != nil {
-		fmt.Printf("Error generating systemd service! %s\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error generating systemd service: %w", err)
 	}
 
 	return &sshconnect.CopyFileCommand{
-		Host:        hostConfig.PublicIP,
+		Host:        hostConfig.SSHHost(),
 		FileContent: strings.NewReader(systemdService),
 		FilePath:    "/etc/systemd/system/etcd.service",
-		Description: "Copy etcd systemd service to host"}
+		Description: "Copy etcd systemd service to host"}, nil
 }
 
 func downloadEtcd(host string) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "curl -L https://github.com/etcd-io/etcd/releases/download/v3.3.12/etcd-v3.3.12-linux-amd64.tar.gz -o /tmp/etcd-v3.3.12.tar.gz",
 		Host:        host,
-		Description: "Download etcd binary"}
+		Description: "Download etcd binary",
+		Retry:       sshconnect.DownloadRetryPolicy}
 }
 
 func unpackAndInstall(host string) *sshconnect.ShellCommand {
@@ -104,7 +182,20 @@ func unpackAndInstall(host string) *sshconnect.ShellCommand {
 
 func enableAndStartEtcdSystemdService(host string) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
-		CommandLine: "systemctl daemon-reload && systemctl enable etcd && systemctl restart etcd",
+		CommandLine: "systemctl daemon-reload && systemctl enable etcd && systemctl restart --no-block etcd",
 		Host:        host,
 		Description: "Enable and start etcd service"}
 }
+
+func checkEndpointHealth(host string, members []ClusterMember) *sshconnect.ShellCommand {
+	endpoints := make([]string, len(members))
+	for i, member := range members {
+		endpoints[i] = member.ClientURL()
+	}
+	return &sshconnect.ShellCommand{
+		CommandLine: fmt.Sprintf(
+			"ETCDCTL_API=3 etcdctl --endpoints=%s --cacert=/etc/etcd/pki/ca.crt --cert=/etc/etcd/pki/etcd.crt --key=/etc/etcd/pki/etcd.key endpoint health 2>&1 || true",
+			strings.Join(endpoints, ",")),
+		Host:        host,
+		Description: "Check health of etcd cluster members"}
+}
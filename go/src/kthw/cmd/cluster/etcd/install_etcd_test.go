@@ -1,11 +1,17 @@
 package etcd_test
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"kthw/certs"
 	"kthw/cmd/cluster/etcd"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
 	"testing"
+	"time"
+
+	"github.com/spf13/viper"
 )
 
 func TestFailInstallEtcdIfNoHostsWithRoleEtcdExist(t *testing.T) {
@@ -21,21 +27,9 @@ func TestFailInstallEtcdIfNoHostsWithRoleEtcdExist(t *testing.T) {
 	}
 }
 
-func TestFailInstallEtcdIfNoSingleNodeCluster(t *testing.T) {
-	mock := sshconnect.NewSSHOperationsMock()
-	hostConfigs := []*server.Config{
-		&server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"etcd"}},
-		&server.Config{ID: 2, PublicIP: "192.168.1.2", Roles: []string{"etcd"}}}
-
-	err := etcd.InstallOnHost(hostConfigs, mock, certs.NewGeneratesCertsMock())
-
-	if err == nil {
-		t.Errorf("Installing etcd if there is no host with role etcd is not possible.\n")
-	}
-}
-
 func TestInstallEtcd(t *testing.T) {
 	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Check health of etcd cluster members"] = "https://10.0.0.1:2379 is healthy"
 	generatesCerts := certs.NewGeneratesCertsMock()
 	hostInEtcdRole := &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"etcd", "worker"}}
 	hostConfigs := []*server.Config{
@@ -61,3 +55,37 @@ func TestInstallEtcd(t *testing.T) {
 
 	sshconnect.EnsureNoCommandsIssued(mock.RunCmdsCommands, hostConfigs[1].PublicIP, t)
 }
+
+func TestInstallEtcdCluster(t *testing.T) {
+	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Check health of etcd cluster members"] = "https://10.0.0.1:2379 is healthy\nhttps://10.0.0.2:2379 is healthy"
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "etcd-1", PublicIP: "192.168.1.1", PrivateIP: "10.0.0.1", Roles: []string{"etcd"}},
+		&server.Config{ID: 2, Name: "etcd-2", PublicIP: "192.168.1.2", PrivateIP: "10.0.0.2", Roles: []string{"etcd"}},
+		&server.Config{ID: 3, Name: "etcd-3", PublicIP: "192.168.1.3", PrivateIP: "10.0.0.3", Roles: []string{"etcd"}}}
+
+	err := etcd.InstallOnHost(hostConfigs, mock, certs.NewGeneratesCertsMock())
+	if err != nil {
+		t.Errorf("InstallEtcd returned an unexpected error: %s\n", err)
+	}
+
+	for _, hostConfig := range hostConfigs {
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Copy etcd systemd service to host", hostConfig.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Enable and start etcd service", hostConfig.PublicIP, t)
+	}
+	sshconnect.EnsureCommandIssued(mock.RunCmdCommands, "Check health of etcd cluster members", hostConfigs[0].PublicIP, t)
+}
+
+func TestWaitingForQuorumStopsWhenCancelled(t *testing.T) {
+	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Check health of etcd cluster members"] = "https://10.0.0.1:2379 is unhealthy"
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "etcd-1", PublicIP: "192.168.1.1", PrivateIP: "10.0.0.1", Roles: []string{"etcd"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := etcd.InstallOnHostIn(ctx, ioutil.Discard, viper.New(), hostConfigs, mock, certs.NewGeneratesCertsMock())
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected waiting for the quorum to stop with the context, but got '%v'", err)
+	}
+}
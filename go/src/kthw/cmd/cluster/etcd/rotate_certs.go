@@ -0,0 +1,53 @@
+package etcd
+
+import (
+	"context"
+	"fmt"
+	"kthw/certs"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"os"
+)
+
+// RotateCertificates re-issues the certificate of every etcd member. Members
+// are restarted one after another and the cluster must regain its quorum
+// before the next member is restarted.
+func RotateCertificates(hostConfigs []*server.Config, ssh sshconnect.SSHOperations, generateCerts certs.GeneratesCerts) error {
+	etcdHosts := server.SelectHostsInRole(hostConfigs, "etcd")
+	if len(etcdHosts) == 0 {
+		return fmt.Errorf("List of provided hosts didn't contain a host with role etcd")
+	}
+
+	members := clusterMembers(etcdHosts)
+
+	for _, etcdHost := range etcdHosts {
+		host := etcdHost.SSHHost()
+		etcdCert, err := generateCerts.GenEtcdCertificate(certHostnames(etcdHost))
+		if err != nil {
+			return fmt.Errorf("Error while generating etcd certificate: %w", err)
+		}
+		commands := &sshconnect.Commands{
+			Commands: []sshconnect.Command{
+				uploadEtcdCertPrivateKey(host, etcdCert),
+				uploadEtcdCertPublicKey(host, etcdCert),
+				restartEtcdSystemdService(host)},
+			LogOutput: true}
+		err = ssh.RunCmds(commands)
+		if err != nil {
+			return err
+		}
+
+		err = waitForQuorum(context.Background(), os.Stdout, etcdHost, members, ssh)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func restartEtcdSystemdService(host string) *sshconnect.ShellCommand {
+	return &sshconnect.ShellCommand{
+		CommandLine: "systemctl restart etcd",
+		Host:        host,
+		Description: "Restart etcd service"}
+}
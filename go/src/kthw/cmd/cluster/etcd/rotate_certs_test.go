@@ -0,0 +1,37 @@
+package etcd_test
+
+import (
+	"kthw/certs"
+	"kthw/cmd/cluster/etcd"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"testing"
+)
+
+func TestRotateEtcdCertificates(t *testing.T) {
+	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Check health of etcd cluster members"] = "https://10.0.0.1:2379 is healthy\nhttps://10.0.0.2:2379 is healthy"
+	generatesCerts := certs.NewGeneratesCertsMock()
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "etcd-1", PublicIP: "192.168.1.1", PrivateIP: "10.0.0.1", Roles: []string{"etcd"}},
+		&server.Config{ID: 2, Name: "etcd-2", PublicIP: "192.168.1.2", PrivateIP: "10.0.0.2", Roles: []string{"etcd"}},
+		&server.Config{ID: 3, Name: "controller", PublicIP: "192.168.1.3", PrivateIP: "10.0.0.3", Roles: []string{"controller"}}}
+
+	err := etcd.RotateCertificates(hostConfigs, mock, generatesCerts)
+	if err != nil {
+		t.Errorf("RotateCertificates returned an unexpected error: %s\n", err)
+	}
+
+	if !generatesCerts.IsEtcdCertGenerated {
+		t.Errorf("etcd certificate was not generated\n")
+	}
+
+	for _, hostConfig := range hostConfigs[:2] {
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload etcd certificate public key to /etc/etcd/pki/etcd.crt", hostConfig.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload etcd certificate private key to /etc/etcd/pki/etcd.key", hostConfig.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Restart etcd service", hostConfig.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdCommands, "Check health of etcd cluster members", hostConfig.PublicIP, t)
+	}
+	sshconnect.EnsureCommandNotIssued(mock.RunCmdsCommands, "Download etcd binary", hostConfigs[0].PublicIP, t)
+	sshconnect.EnsureNoCommandsIssued(mock.RunCmdsCommands, hostConfigs[2].PublicIP, t)
+}
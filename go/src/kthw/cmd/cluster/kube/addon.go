@@ -38,8 +38,9 @@ func (c *CalicoNetworkingAddOn) getCommands(hostConfig *server.Config) []sshconn
 	return []sshconnect.Command{
 		&sshconnect.ShellCommand{
 			CommandLine: fmt.Sprintf("%s && %s && %s", downloadManifests, changePodNetwork, installCalico),
-			Host:        hostConfig.PublicIP,
-			Description: c.Description()}}
+			Host:        hostConfig.SSHHost(),
+			Description: c.Description(),
+			Retry:       apiServerRetryPolicy}}
 }
 
 // KubernetesDashboardAddOn installs Kubernetes dashboard to a cluster and creates an admin user.
@@ -64,16 +65,18 @@ func (c *KubernetesDashboardAddOn) getCommands(hostConfig *server.Config) []sshc
 	}
 	return []sshconnect.Command{
 		&sshconnect.CopyFileCommand{
-			Host:        hostConfig.PublicIP,
+			Host:        hostConfig.SSHHost(),
 			FileContent: bytes.NewReader(fileContent),
 			FilePath:    "/tmp/dashboard-admin.yaml",
 			Description: "Copy dashboard-admin-yaml to cluster"},
 		&sshconnect.ShellCommand{
 			CommandLine: fmt.Sprintf("kubectl apply -f /tmp/dashboard-admin.yaml"),
-			Host:        hostConfig.PublicIP,
-			Description: c.Description()},
+			Host:        hostConfig.SSHHost(),
+			Description: c.Description(),
+			Retry:       apiServerRetryPolicy},
 		&sshconnect.ShellCommand{
 			CommandLine: fmt.Sprintf("kubectl apply -f %s", c.dashboardManifest),
-			Host:        hostConfig.PublicIP,
-			Description: c.Description()}}
+			Host:        hostConfig.SSHHost(),
+			Description: c.Description(),
+			Retry:       apiServerRetryPolicy}}
 }
@@ -1,6 +1,8 @@
 package kube_test
 
 import (
+	"bytes"
+	"io/ioutil"
 	"kthw/certs"
 	"kthw/cmd/cluster/kube"
 	"kthw/cmd/infra/server"
@@ -30,6 +32,10 @@ func TestInstallKubernetes(t *testing.T) {
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload etcd client certificate private key to /etc/kubernetes/pki/etcd-client.key", controllerPublicIP, t)
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload CA certificate public key to /etc/kubernetes/pki/ca.crt", controllerPublicIP, t)
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload CA certificate private key to /etc/kubernetes/pki/ca.key", controllerPublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload CA certificate public key to /etc/kubernetes/pki/front-proxy-ca.crt", controllerPublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload CA certificate private key to /etc/kubernetes/pki/front-proxy-ca.key", controllerPublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Upload CA certificate public key to /etc/kubernetes/pki/etcd-ca.crt", controllerPublicIP, t)
+	sshconnect.EnsureCommandNotIssued(sshMock.RunCmdsCommands, "Upload CA certificate private key to /etc/kubernetes/pki/etcd-ca.key", controllerPublicIP, t)
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Copy kubeadm config", controllerPublicIP, t)
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Install kubernetes cluster", controllerPublicIP, t)
 	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Setup Kubectl", controllerPublicIP, t)
@@ -59,3 +65,36 @@ func TestDoNotUntaintController(t *testing.T) {
 
 	sshconnect.EnsureCommandNotIssued(sshMock.RunCmdsCommands, "Untaint controller, allow pod scheduling on controller node", controllerPublicIP, t)
 }
+
+func TestUploadIntermediateCAs(t *testing.T) {
+	sshMock := sshconnect.NewSSHOperationsMock()
+	certLoaderMock := certs.NewCertificateLoaderMock()
+	controllerNode := &kube.ControllerNode{
+		Config: &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}}}
+	etcdNodes := []*kube.EtcdNode{&kube.EtcdNode{EndpointURL: "irrelevant"}}
+
+	err := kube.InstallControllerNode(controllerNode, etcdNodes, sshMock, certLoaderMock, certs.NewGeneratesCertsMock(), false)
+	if err != nil {
+		t.Errorf("InstallControllerNode returned an unexpected error: %s\n", err)
+	}
+
+	cas, _ := certLoaderMock.LoadIntermediateCAs()
+	expectedContents := map[string][]byte{
+		"/etc/kubernetes/pki/ca.key":             cas[certs.KubernetesCA].KeyBytes,
+		"/etc/kubernetes/pki/front-proxy-ca.key": cas[certs.FrontProxyCA].KeyBytes,
+		"/etc/kubernetes/pki/etcd-ca.crt":        cas[certs.EtcdCA].CertBytes}
+	for _, command := range sshMock.RunCmdsCommands {
+		copyCommand, ok := command.(*sshconnect.CopyFileCommand)
+		if !ok {
+			continue
+		}
+		expected, ok := expectedContents[copyCommand.FilePath]
+		if !ok {
+			continue
+		}
+		content, _ := ioutil.ReadAll(copyCommand.FileContent)
+		if !bytes.Equal(content, expected) {
+			t.Errorf("Unexpected content uploaded to %s: %s", copyCommand.FilePath, content)
+		}
+	}
+}
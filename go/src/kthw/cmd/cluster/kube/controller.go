@@ -4,15 +4,27 @@ import (
 	"bytes"
 	"fmt"
 	"kthw/certs"
-	"kthw/cmd/common"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
-	"os"
+	"path"
 	"strings"
+	"time"
 )
 
+const kubernetesPKIDir = "/etc/kubernetes/pki"
+
+// apiServerRetryPolicy retries commands using the API server, which doesn't
+// accept connections for a while after kubeadm init or a restart.
+var apiServerRetryPolicy = sshconnect.RetryPolicy{
+	Retries: 5,
+	Backoff: 5 * time.Second,
+	Timeout: 5 * time.Minute}
+
+// ControllerNode is a server in role controller. Endpoint is nil if the
+// cluster has only one controller.
 type ControllerNode struct {
-	Config *server.Config
+	Config   *server.Config
+	Endpoint ControlPlaneEndpoint
 }
 
 // InstallControllerNode installs a Kubernets controller on host.
@@ -28,7 +40,16 @@ func InstallControllerNode(
 
 	host := controllerNode.Config
 
-	allCommands := baseSetup(host, etcdNodes, certsLoader, certGenerator)
+	allCommands, err := endpointSetup(controllerNode.Endpoint, host)
+	if err != nil {
+		return err
+	}
+
+	setupCommands, err := baseSetup(controllerNode, etcdNodes, certsLoader, certGenerator)
+	if err != nil {
+		return err
+	}
+	allCommands = append(allCommands, setupCommands...)
 
 	if runPodsOnController {
 		allCommands = append(allCommands, untaintController(host))
@@ -42,114 +63,255 @@ func InstallControllerNode(
 		Commands:  allCommands,
 		LogOutput: true}
 
-	err := ssh.RunCmds(commands)
-	return err
+	return ssh.RunCmds(commands)
+}
+
+// JoinControllerNode joins a further controller to the control plane initialised on primary.
+// Certificates shared by all controllers are uploaded before the join command runs.
+func JoinControllerNode(
+	controllerNode *ControllerNode,
+	primary *ControllerNode,
+	ssh sshconnect.SSHOperations,
+	certsLoader certs.CertificateLoader,
+	certGenerator certs.GeneratesCerts) error {
+
+	host := controllerNode.Config
+	if controllerNode.Endpoint == nil {
+		return fmt.Errorf("Joining controller '%s' requires a control plane endpoint", host.Name)
+	}
+
+	cas, err := certsLoader.LoadIntermediateCAs()
+	if err != nil {
+		return fmt.Errorf("Error while loading CA certificates: %s", err)
+	}
+
+	sharedFiles, err := fetchSharedControllerFiles(primary, ssh)
+	if err != nil {
+		return err
+	}
+
+	joinCommand, err := ssh.RunCmd(getClusterJoinCommand(primary), false)
+	if err != nil {
+		return fmt.Errorf("Error while getting join command from controller '%s': %s", primary.Config.Name, err)
+	}
+
+	allCommands, err := endpointSetup(controllerNode.Endpoint, host)
+	if err != nil {
+		return err
+	}
+
+	etcdClientCertCommands, err := uploadEtcdClientCert(host.SSHHost(), certGenerator)
+	if err != nil {
+		return err
+	}
+	allCommands = append(allCommands, etcdClientCertCommands...)
+	allCommands = append(allCommands, uploadCAs(host.SSHHost(), cas)...)
+	allCommands = append(allCommands, uploadSharedControllerFiles(host.SSHHost(), sharedFiles)...)
+	allCommands = append(allCommands,
+		runControllerJoinCommand(host, joinCommand),
+		setupKubectl(host),
+		openFirewall(host))
+
+	commands := &sshconnect.Commands{
+		Commands:  allCommands,
+		LogOutput: true}
+
+	return ssh.RunCmds(commands)
+}
+
+// sharedControllerFiles are generated by 'kubeadm init' and must be
+// identical on all controllers.
+var sharedControllerFiles = []string{
+	"/etc/kubernetes/pki/sa.key",
+	"/etc/kubernetes/pki/sa.pub"}
+
+func fetchSharedControllerFiles(primary *ControllerNode, ssh sshconnect.SSHOperations) (map[string]string, error) {
+	files := make(map[string]string)
+	for _, filePath := range sharedControllerFiles {
+		command := &sshconnect.ShellCommand{
+			CommandLine: fmt.Sprintf("cat %s", filePath),
+			Host:        primary.Config.SSHHost(),
+			Description: fmt.Sprintf("Fetch %s from controller", filePath),
+			Sensitive:   path.Ext(filePath) == ".key"}
+		content, err := ssh.RunCmd(command, false)
+		if err != nil {
+			return nil, fmt.Errorf("Error while fetching '%s' from controller '%s': %s", filePath, primary.Config.Name, err)
+		}
+		files[filePath] = content
+	}
+	return files, nil
+}
+
+func uploadSharedControllerFiles(host string, files map[string]string) []sshconnect.Command {
+	var commands []sshconnect.Command
+	for _, filePath := range sharedControllerFiles {
+		commands = append(commands, &sshconnect.CopyFileCommand{
+			Host:        host,
+			FileContent: strings.NewReader(files[filePath]),
+			FilePath:    filePath,
+			Sensitive:   path.Ext(filePath) == ".key",
+			Description: fmt.Sprintf("Upload %s", filePath)})
+	}
+	return commands
+}
+
+func endpointSetup(endpoint ControlPlaneEndpoint, hostConfig *server.Config) ([]sshconnect.Command, error) {
+	if endpoint == nil {
+		return []sshconnect.Command{}, nil
+	}
+	return endpoint.getCommands(hostConfig)
 }
 
 func baseSetup(
-	config *server.Config,
+	controllerNode *ControllerNode,
 	etcdNodes []*EtcdNode,
 	certsLoader certs.CertificateLoader,
-	certGenerator certs.GeneratesCerts) []sshconnect.Command {
+	certGenerator certs.GeneratesCerts) ([]sshconnect.Command, error) {
 
-	host := config.PublicIP
-	ca, err := certsLoader.LoadCA()
+	config := controllerNode.Config
+	host := config.SSHHost()
+	cas, err := certsLoader.LoadIntermediateCAs()
 	if err != nil {
-		fmt.Printf("Error while loading CA certificate: %s", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error while loading CA certificates: %w", err)
 	}
 
-	commands := uploadEtcdClientCert(host, certGenerator)
+	kubeadmConfig, err := uploadKubeadmconfig(controllerNode, etcdNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	commands, err := uploadEtcdClientCert(host, certGenerator)
+	if err != nil {
+		return nil, err
+	}
+	commands = append(commands, uploadCAs(host, cas)...)
 	commands = append(commands,
-		uploadCAPublicKey(host, ca),
-		uploadCAPrivateKey(host, ca),
-		uploadKubeadmconfig(config, etcdNodes),
+		kubeadmConfig,
 		installKubernetesCluster(config),
 		setupKubectl(config),
 		openFirewall(config))
 
-	return commands
+	return commands, nil
 }
 
-func uploadKubeadmconfig(config *server.Config, etcdNodes []*EtcdNode) *sshconnect.CopyFileCommand {
-	kubeAdmParams := NewKubeAdmParams(config, etcdNodes)
+func uploadKubeadmconfig(controllerNode *ControllerNode, etcdNodes []*EtcdNode) (*sshconnect.CopyFileCommand, error) {
+	config := controllerNode.Config
+	kubeAdmParams := NewKubeAdmParams(controllerNode, etcdNodes)
 	kubeadmConfig, err := GenerateKubeadmControllerConfig(kubeAdmParams)
 	if err != nil {
-		fmt.Printf("Error generating kubeadm controller config! %s\n", err)
-		os.Exit(1)
+		return nil, fmt.Errorf("Error generating kubeadm controller config: %w", err)
 	}
 
 	return &sshconnect.CopyFileCommand{
-		Host:        config.PublicIP,
+		Host:        config.SSHHost(),
 		FileContent: strings.NewReader(kubeadmConfig),
 		FilePath:    "/etc/kubernetes/kubeadm-controller.conf",
-		Description: "Copy kubeadm config"}
+		Description: "Copy kubeadm config"}, nil
 }
 
-func uploadEtcdClientCert(host string, certGenerator certs.GeneratesCerts) []sshconnect.Command {
+func uploadEtcdClientCert(host string, certGenerator certs.GeneratesCerts) ([]sshconnect.Command, error) {
 	etcdClientCert, err := certGenerator.GenEtcdClientCertificate()
-	common.WhenErrPrintAndExit(err)
+	if err != nil {
+		return nil, fmt.Errorf("Error while generating etcd client certificate: %w", err)
+	}
 
 	return []sshconnect.Command{
 		&sshconnect.CopyFileCommand{
 			Host:        host,
 			FileContent: bytes.NewReader(etcdClientCert.PublicKeyBytes),
 			FilePath:    "/etc/kubernetes/pki/etcd-client.crt",
+			Atomic:      true,
 			Description: "Upload etcd client certificate public key to /etc/kubernetes/pki/etcd-client.crt"},
 		&sshconnect.CopyFileCommand{
 			Host:        host,
 			FileContent: bytes.NewReader(etcdClientCert.PrivateKeyBytes),
 			FilePath:    "/etc/kubernetes/pki/etcd-client.key",
-			Description: "Upload etcd client certificate private key to /etc/kubernetes/pki/etcd-client.key"}}
+			Mode:        sshconnect.PrivateKeyFileMode,
+			Atomic:      true,
+			Sensitive:   true,
+			Description: "Upload etcd client certificate private key to /etc/kubernetes/pki/etcd-client.key"}}, nil
+}
+
+// uploadCAs uploads the intermediate CAs used by kubeadm. The etcd CA is only
+// required to verify the certificates of etcd, thus its private key is not uploaded.
+func uploadCAs(host string, cas certs.IntermediateCAs) []sshconnect.Command {
+	return []sshconnect.Command{
+		uploadCAPublicKey(host, cas[certs.KubernetesCA], "ca.crt"),
+		uploadCAPrivateKey(host, cas[certs.KubernetesCA], "ca.key"),
+		uploadCAPublicKey(host, cas[certs.FrontProxyCA], "front-proxy-ca.crt"),
+		uploadCAPrivateKey(host, cas[certs.FrontProxyCA], "front-proxy-ca.key"),
+		uploadCAPublicKey(host, cas[certs.EtcdCA], "etcd-ca.crt")}
 }
 
-func uploadCAPublicKey(host string, ca *certs.CA) *sshconnect.CopyFileCommand {
+func uploadCAPublicKey(host string, ca *certs.CA, fileName string) *sshconnect.CopyFileCommand {
+	filePath := path.Join(kubernetesPKIDir, fileName)
 	return &sshconnect.CopyFileCommand{
 		Host:        host,
 		FileContent: bytes.NewReader(ca.CertBytes),
-		FilePath:    "/etc/kubernetes/pki/ca.crt",
-		Description: "Upload CA certificate public key to /etc/kubernetes/pki/ca.crt"}
+		FilePath:    filePath,
+		Atomic:      true,
+		Description: fmt.Sprintf("Upload CA certificate public key to %s", filePath)}
 }
 
-func uploadCAPrivateKey(host string, ca *certs.CA) *sshconnect.CopyFileCommand {
+func uploadCAPrivateKey(host string, ca *certs.CA, fileName string) *sshconnect.CopyFileCommand {
+	filePath := path.Join(kubernetesPKIDir, fileName)
 	return &sshconnect.CopyFileCommand{
 		Host:        host,
 		FileContent: bytes.NewReader(ca.KeyBytes),
-		FilePath:    "/etc/kubernetes/pki/ca.key",
-		Description: "Upload CA certificate private key to /etc/kubernetes/pki/ca.key"}
+		FilePath:    filePath,
+		Mode:        sshconnect.PrivateKeyFileMode,
+		Atomic:      true,
+		Sensitive:   true,
+		Description: fmt.Sprintf("Upload CA certificate private key to %s", filePath)}
 }
 
 func removeKubernetesCluster(config *server.Config) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "kubeadm reset -f",
-		Host:        config.PublicIP,
+		Host:        config.SSHHost(),
 		Description: "Removing kubernetes cluster"}
 }
 
 func installKubernetesCluster(config *server.Config) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "kubeadm init --config /etc/kubernetes/kubeadm-controller.conf",
-		Host:        config.PublicIP,
+		Host:        config.SSHHost(),
 		Description: "Install kubernetes cluster"}
 }
 
+func runControllerJoinCommand(config *server.Config, joinCommand string) *sshconnect.ShellCommand {
+	return &sshconnect.ShellCommand{
+		CommandLine: fmt.Sprintf("%s --control-plane --apiserver-advertise-address %s", strings.TrimSpace(joinCommand), config.PublicIP),
+		Host:        config.SSHHost(),
+		Description: "Join controller to control plane"}
+}
+
 func setupKubectl(config *server.Config) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "mkdir -p $HOME/.kube && cp -i /etc/kubernetes/admin.conf $HOME/.kube/config && sudo chown $(id -u):$(id -g) $HOME/.kube/config",
-		Host:        config.PublicIP,
+		Host:        config.SSHHost(),
 		Description: "Setup Kubectl"}
 }
 
 func openFirewall(config *server.Config) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: fmt.Sprintf("ufw allow from %s to %s && ufw allow 6443", podNetworkCIDR, config.PublicIP),
-		Host:        config.PublicIP,
+		Host:        config.SSHHost(),
 		Description: "Open firewall pod network -> public IP and :6443 -> public IP"}
 }
 
 func untaintController(config *server.Config) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "kubectl taint nodes --all node-role.kubernetes.io/master-",
-		Host:        config.PublicIP,
-		Description: "Untaint controller, allow pod scheduling on controller node"}
+		Host:        config.SSHHost(),
+		Description: "Untaint controller, allow pod scheduling on controller node",
+		Retry:       apiServerRetryPolicy}
+}
+
+// ResetNode removes all kubernetes components installed by kubeadm from a node.
+func ResetNode(config *server.Config, ssh sshconnect.SSHOperations) error {
+	commands := &sshconnect.Commands{
+		Commands:  []sshconnect.Command{removeKubernetesCluster(config)},
+		LogOutput: true}
+	return ssh.RunCmds(commands)
 }
@@ -0,0 +1,172 @@
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	confControlPlaneEndpointKey = "kubernetes.controlPlaneEndpoint"
+
+	localHAProxyAddress = "127.0.0.1:8443"
+
+	// LocalHAProxy is the control plane endpoint selecting LocalHAProxyEndpoint.
+	LocalHAProxy = "haproxy"
+)
+
+// ControlPlaneEndpoint is the address all nodes use to reach the API servers
+// of a highly available control plane. There is no virtual IP shared by the
+// controllers, e.g. with keepalived. Either a load balancer managed outside of
+// this tool is used or every node balances requests itself.
+type ControlPlaneEndpoint interface {
+	Address() string
+	Description() string
+	getCommands(hostConfig *server.Config) ([]sshconnect.Command, error)
+}
+
+// LoadBalancerEndpoint is a load balancer in front of all controllers, which is
+// managed outside of this tool, e.g. a Hetzner load balancer or a DNS name. It
+// must forward TCP traffic to port 6443 of every controller.
+type LoadBalancerEndpoint struct {
+	address string
+}
+
+// NewLoadBalancerEndpoint creates a LoadBalancerEndpoint. Address is 'host:port'.
+func NewLoadBalancerEndpoint(address string) *LoadBalancerEndpoint {
+	return &LoadBalancerEndpoint{address: address}
+}
+
+// Address returns host and port of the load balancer.
+func (l *LoadBalancerEndpoint) Address() string { return l.address }
+
+// Description returns a string used in loggings.
+func (l *LoadBalancerEndpoint) Description() string {
+	return fmt.Sprintf("Use load balancer %s as control plane endpoint", l.address)
+}
+
+func (l *LoadBalancerEndpoint) getCommands(hostConfig *server.Config) ([]sshconnect.Command, error) {
+	return []sshconnect.Command{}, nil
+}
+
+var haproxyConfigTemplate = `global
+  log /dev/log local0
+  daemon
+
+defaults
+  log global
+  mode tcp
+  option tcplog
+  timeout connect 5s
+  timeout client 1h
+  timeout server 1h
+
+frontend kube-apiserver
+  bind {{.Address}}
+  default_backend kube-apiserver
+
+backend kube-apiserver
+  option tcp-check
+  balance roundrobin
+{{range .Controllers}}  server {{.Name}} {{.PrivateIP}}:6443 check
+{{end}}`
+
+// LocalHAProxyEndpoint runs haproxy on every node of the cluster. It listens
+// on localhost and balances requests to the API servers of all controllers
+// using the private overlay network.
+type LocalHAProxyEndpoint struct {
+	Controllers []*server.Config
+}
+
+// NewLocalHAProxyEndpoint creates a LocalHAProxyEndpoint balancing requests to controllers.
+func NewLocalHAProxyEndpoint(controllers []*server.Config) *LocalHAProxyEndpoint {
+	return &LocalHAProxyEndpoint{Controllers: controllers}
+}
+
+// Address returns the address haproxy listens on.
+func (l *LocalHAProxyEndpoint) Address() string { return localHAProxyAddress }
+
+// Description returns a string used in loggings.
+func (l *LocalHAProxyEndpoint) Description() string {
+	return "Install haproxy as control plane endpoint"
+}
+
+func (l *LocalHAProxyEndpoint) generateConfig() (string, error) {
+	tmpl, err := template.New("haproxy-config").Parse(haproxyConfigTemplate)
+	if err != nil {
+		return "", err
+	}
+
+	params := struct {
+		Address     string
+		Controllers []*server.Config
+	}{Address: l.Address(), Controllers: l.Controllers}
+
+	var confBuffer bytes.Buffer
+	err = tmpl.ExecuteTemplate(&confBuffer, "haproxy-config", params)
+	if err != nil {
+		return "", err
+	}
+	return confBuffer.String(), nil
+}
+
+func (l *LocalHAProxyEndpoint) getCommands(hostConfig *server.Config) ([]sshconnect.Command, error) {
+	haproxyConfig, err := l.generateConfig()
+	if err != nil {
+		return nil, fmt.Errorf("Error generating haproxy config: %w", err)
+	}
+
+	return []sshconnect.Command{
+		&sshconnect.ShellCommand{
+			CommandLine: "DEBIAN_FRONTEND=noninteractive apt-get install -y haproxy",
+			Host:        hostConfig.SSHHost(),
+			Description: "Install haproxy",
+			Retry:       sshconnect.AptRetryPolicy},
+		&sshconnect.CopyFileCommand{
+			Host:        hostConfig.SSHHost(),
+			FileContent: strings.NewReader(haproxyConfig),
+			FilePath:    "/etc/haproxy/haproxy.cfg",
+			Description: "Copy haproxy config"},
+		&sshconnect.ShellCommand{
+			CommandLine: "systemctl enable haproxy && systemctl restart haproxy",
+			Host:        hostConfig.SSHHost(),
+			Description: "Start haproxy"}}, nil
+}
+
+// ReadControlPlaneEndpoint selects the control plane endpoint of a cluster with
+// the given controllers. A single controller needs no endpoint and nil is returned.
+// If the configured endpoint is LocalHAProxy, haproxy is installed on every node.
+// Any other address is used as load balancer. Returns an error if there are
+// several controllers, but no endpoint is configured.
+func ReadControlPlaneEndpoint(controllers []*server.Config) (ControlPlaneEndpoint, error) {
+	return ReadControlPlaneEndpointIn(viper.GetViper(), controllers)
+}
+
+// ReadControlPlaneEndpointIn selects the control plane endpoint configured in
+// config like ReadControlPlaneEndpoint.
+func ReadControlPlaneEndpointIn(config *viper.Viper, controllers []*server.Config) (ControlPlaneEndpoint, error) {
+	address := config.GetString(confControlPlaneEndpointKey)
+	switch {
+	case address == LocalHAProxy && len(controllers) <= 1:
+		return nil, nil
+	case address == LocalHAProxy:
+		return NewLocalHAProxyEndpoint(controllers), nil
+	case address != "":
+		return NewLoadBalancerEndpoint(address), nil
+	case len(controllers) > 1:
+		return nil, fmt.Errorf("There are %d controllers, but no control plane endpoint. Set a load balancer or '%s' as endpoint",
+			len(controllers), LocalHAProxy)
+	}
+	return nil, nil
+}
+
+// SetControlPlaneEndpoint sets the address of a load balancer in front of all
+// controllers or LocalHAProxy. Changes are not persisted.
+func SetControlPlaneEndpoint(address string) {
+	viper.Set(confControlPlaneEndpointKey, address)
+}
@@ -1,42 +1,179 @@
 package kube
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"kthw/certs"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
+	"os"
+	"sync"
+
+	"github.com/spf13/viper"
 )
 
-// InstallOnHosts installs kubernetes to all hosts in role controller or worker
+// NodeHooks let callers skip nodes installed before and record nodes once
+// they are installed. Nil hooks install every node and record nothing.
+type NodeHooks struct {
+	// Skip returns 'true' if node doesn't need to be installed.
+	Skip func(node *server.Config) bool
+	// Installed is called once node is installed. Calls are serialized, even
+	// though workers join concurrently. An error stops the installation.
+	Installed func(node *server.Config) error
+}
+
+func (h NodeHooks) skip(node *server.Config) bool {
+	return h.Skip != nil && h.Skip(node)
+}
+
+func (h NodeHooks) installed(node *server.Config) error {
+	if h.Installed == nil {
+		return nil
+	}
+	return h.Installed(node)
+}
+
+// InstallOnHosts installs kubernetes to all hosts in role controller or worker.
+// See InstallControlPlane and JoinWorkers.
 func InstallOnHosts(
 	serverConfigs []*server.Config,
 	ssh sshconnect.SSHOperations,
 	certsLoader certs.CertificateLoader,
 	certsGenerator certs.GeneratesCerts) error {
 
+	ctx := context.Background()
+	config := viper.GetViper()
+	err := InstallControlPlane(ctx, config, serverConfigs, ssh, certsLoader, certsGenerator, NodeHooks{})
+	if err != nil {
+		return err
+	}
+	return JoinWorkers(ctx, os.Stdout, config, server.SelectHostsInRole(serverConfigs, "worker"), serverConfigs, ssh, NodeHooks{})
+}
+
+// InstallControlPlane initialises the cluster on the first host in role
+// controller and joins all other controllers to the control plane using the
+// endpoint returned by ReadControlPlaneEndpointIn config. Controllers are
+// installed one after another. No further controller is installed once ctx is done.
+func InstallControlPlane(
+	ctx context.Context,
+	config *viper.Viper,
+	serverConfigs []*server.Config,
+	ssh sshconnect.SSHOperations,
+	certsLoader certs.CertificateLoader,
+	certsGenerator certs.GeneratesCerts,
+	hooks NodeHooks) error {
+
+	controllerNodes, err := SelectControllerNodesIn(config, serverConfigs)
+	if err != nil {
+		return err
+	}
+	primary := controllerNodes[0]
+	etcdNodes := SelectEtcdNodes(serverConfigs)
+
+	for _, controllerNode := range controllerNodes {
+		if hooks.skip(controllerNode.Config) {
+			continue
+		}
+		err = ctx.Err()
+		if err != nil {
+			return err
+		}
+		if controllerNode == primary {
+			deployPodsToControllerNode := len(serverConfigs) <= 1
+			err = InstallControllerNode(primary, etcdNodes, ssh, certsLoader, certsGenerator, deployPodsToControllerNode)
+		} else {
+			err = JoinControllerNode(controllerNode, primary, ssh, certsLoader, certsGenerator)
+		}
+		if err != nil {
+			return err
+		}
+		err = hooks.installed(controllerNode.Config)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JoinWorkers joins workers concurrently to the cluster initialised on the
+// first host in role controller of serverConfigs. Workers not started yet are
+// not joined once ctx is done. Concurrency is configured in config. Progress
+// of workers is printed to out.
+func JoinWorkers(
+	ctx context.Context,
+	out io.Writer,
+	config *viper.Viper,
+	workers []*server.Config,
+	serverConfigs []*server.Config,
+	ssh sshconnect.SSHOperations,
+	hooks NodeHooks) error {
+
+	controllerNodes, err := SelectControllerNodesIn(config, serverConfigs)
+	if err != nil {
+		return err
+	}
+
+	var joining []*server.Config
+	for _, worker := range workers {
+		if !hooks.skip(worker) {
+			joining = append(joining, worker)
+		}
+	}
+
+	err = ctx.Err()
+	if err != nil {
+		return err
+	}
+	var hooksMutex sync.Mutex
+	return sshconnect.ReadParallelIn(config).RunOnHosts(server.SSHHosts(joining), func(i int) error {
+		err := ctx.Err()
+		if err != nil {
+			return err
+		}
+		err = InstallWorkerNode(ctx, out, joining[i], controllerNodes[0], ssh)
+		if err != nil {
+			return err
+		}
+
+		hooksMutex.Lock()
+		defer hooksMutex.Unlock()
+		return hooks.installed(joining[i])
+	})
+}
+
+// SelectControllerNodes selects all hosts in role controller. The first
+// ControllerNode is the one initialising the cluster.
+func SelectControllerNodes(serverConfigs []*server.Config) ([]*ControllerNode, error) {
+	return SelectControllerNodesIn(viper.GetViper(), serverConfigs)
+}
+
+// SelectControllerNodesIn selects all hosts in role controller like
+// SelectControllerNodes with the control plane endpoint configured in config.
+func SelectControllerNodesIn(config *viper.Viper, serverConfigs []*server.Config) ([]*ControllerNode, error) {
 	controllerConfigs := server.SelectHostsInRole(serverConfigs, "controller")
 	if len(controllerConfigs) <= 0 {
-		return fmt.Errorf("List of provided hosts didn't contain a host with role controller, but one controller is required")
+		return nil, fmt.Errorf("List of provided hosts didn't contain a host with role controller, but one controller is required")
 	}
-	if len(controllerConfigs) > 1 {
-		return fmt.Errorf("List of provided hosts contains more than one host with role controller, but one controller is allowed")
+
+	endpoint, err := ReadControlPlaneEndpointIn(config, controllerConfigs)
+	if err != nil {
+		return nil, err
 	}
-	controllerNode := &ControllerNode{Config: controllerConfigs[0]}
+	controllerNodes := make([]*ControllerNode, len(controllerConfigs))
+	for i, controllerConfig := range controllerConfigs {
+		controllerNodes[i] = &ControllerNode{Config: controllerConfig, Endpoint: endpoint}
+	}
+	return controllerNodes, nil
+}
 
+// SelectEtcdNodes selects all hosts in role etcd and returns their client endpoints.
+func SelectEtcdNodes(serverConfigs []*server.Config) []*EtcdNode {
 	etcdHosts := server.SelectHostsInRole(serverConfigs, "etcd")
 	var etcdNodes []*EtcdNode
 	for _, etcdHost := range etcdHosts {
 		node := &EtcdNode{EndpointURL: fmt.Sprintf("https://%s:2379", etcdHost.PrivateIP)}
 		etcdNodes = append(etcdNodes, node)
 	}
-
-	deployPodsToControllerNode := len(serverConfigs) <= 1
-	InstallControllerNode(controllerNode, etcdNodes, ssh, certsLoader, certsGenerator, deployPodsToControllerNode)
-
-	workerConfigs := server.SelectHostsInRole(serverConfigs, "worker")
-	for _, workerConfig := range workerConfigs {
-		InstallWorkerNode(workerConfig, controllerNode, ssh)
-	}
-
-	return nil
+	return etcdNodes
 }
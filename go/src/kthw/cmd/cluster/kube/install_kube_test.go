@@ -1,11 +1,16 @@
 package kube_test
 
 import (
+	"context"
+	"errors"
+	"io/ioutil"
 	"kthw/certs"
 	"kthw/cmd/cluster/kube"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
 	"testing"
+
+	"github.com/spf13/viper"
 )
 
 func TestFailInstallIfNoHostsWithRoleControllerExist(t *testing.T) {
@@ -22,17 +27,88 @@ func TestFailInstallIfNoHostsWithRoleControllerExist(t *testing.T) {
 	}
 }
 
-func TestFailInstallIfMoreThanOneHostWithRoleControllerExist(t *testing.T) {
+func TestInstallHAControlPlane(t *testing.T) {
+	viper.Reset()
+	kube.SetControlPlaneEndpoint(kube.LocalHAProxy)
 	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Get cluster join command from controller"] = "kubeadm join 127.0.0.1:8443 --token abc"
 	certLoaderMock := certs.NewCertificateLoaderMock()
 	generatesCerts := certs.NewGeneratesCertsMock()
 	hostConfigs := []*server.Config{
-		&server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}},
-		&server.Config{ID: 2, PublicIP: "192.168.1.2", Roles: []string{"controller"}}}
+		&server.Config{ID: 1, Name: "controller-1", PublicIP: "192.168.1.1", Roles: []string{"controller", "etcd"}},
+		&server.Config{ID: 2, Name: "controller-2", PublicIP: "192.168.1.2", Roles: []string{"controller"}},
+		&server.Config{ID: 3, Name: "controller-3", PublicIP: "192.168.1.3", Roles: []string{"controller"}}}
 
 	err := kube.InstallOnHosts(hostConfigs, mock, certLoaderMock, generatesCerts)
+	if err != nil {
+		t.Fatalf("InstallOnHosts returned an unexpected error: %s\n", err)
+	}
+
+	sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Install kubernetes cluster", hostConfigs[0].PublicIP, t)
+	sshconnect.EnsureCommandNotIssued(mock.RunCmdsCommands, "Join controller to control plane", hostConfigs[0].PublicIP, t)
+	for _, joiningController := range hostConfigs[1:] {
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Install haproxy", joiningController.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload CA certificate private key to /etc/kubernetes/pki/ca.key", joiningController.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload /etc/kubernetes/pki/sa.key", joiningController.PublicIP, t)
+		sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Join controller to control plane", joiningController.PublicIP, t)
+		sshconnect.EnsureCommandNotIssued(mock.RunCmdsCommands, "Install kubernetes cluster", joiningController.PublicIP, t)
+	}
+}
 
+func TestFailInstallHAControlPlaneWithoutEndpoint(t *testing.T) {
+	viper.Reset()
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "controller-1", PublicIP: "192.168.1.1", Roles: []string{"controller", "etcd"}},
+		&server.Config{ID: 2, Name: "controller-2", PublicIP: "192.168.1.2", Roles: []string{"controller"}}}
+
+	_, err := kube.SelectControllerNodes(hostConfigs)
 	if err == nil {
-		t.Errorf("Installing kubernetes is currently only supported with on controller.\n")
+		t.Errorf("Expected error, because there are several controllers, but no control plane endpoint")
+	}
+}
+
+func TestInstallControlPlaneSkipsInstalledControllers(t *testing.T) {
+	viper.Reset()
+	kube.SetControlPlaneEndpoint(kube.LocalHAProxy)
+	mock := sshconnect.NewSSHOperationsMock()
+	mock.RunCmdResults["Get cluster join command from controller"] = "kubeadm join 127.0.0.1:8443 --token abc"
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "controller-1", PublicIP: "192.168.1.1", Roles: []string{"controller", "etcd"}},
+		&server.Config{ID: 2, Name: "controller-2", PublicIP: "192.168.1.2", Roles: []string{"controller"}}}
+
+	var installed []string
+	hooks := kube.NodeHooks{
+		Skip: func(node *server.Config) bool { return node.Name == "controller-1" },
+		Installed: func(node *server.Config) error {
+			installed = append(installed, node.Name)
+			return nil
+		}}
+	err := kube.InstallControlPlane(context.Background(), viper.GetViper(), hostConfigs, mock, certs.NewCertificateLoaderMock(), certs.NewGeneratesCertsMock(), hooks)
+	if err != nil {
+		t.Fatalf("InstallControlPlane returned an unexpected error: %s\n", err)
+	}
+
+	sshconnect.EnsureCommandNotIssued(mock.RunCmdsCommands, "Install kubernetes cluster", "192.168.1.1", t)
+	sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Join controller to control plane", "192.168.1.2", t)
+	if len(installed) != 1 || installed[0] != "controller-2" {
+		t.Errorf("Expected only controller-2 to be recorded as installed, but were %v", installed)
+	}
+}
+
+func TestJoinWorkersStopsWhenCancelled(t *testing.T) {
+	viper.Reset()
+	mock := sshconnect.NewSSHOperationsMock()
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "controller-1", PublicIP: "192.168.1.1", Roles: []string{"controller"}},
+		&server.Config{ID: 2, Name: "worker-1", PublicIP: "192.168.1.2", Roles: []string{"worker"}}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := kube.JoinWorkers(ctx, ioutil.Discard, viper.GetViper(), hostConfigs[1:], hostConfigs, mock, kube.NodeHooks{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got '%v'", err)
+	}
+	if len(mock.RunCmdsCommands) != 0 || len(mock.RunCmdCommands) != 0 {
+		t.Errorf("Expected no commands after cancellation")
 	}
 }
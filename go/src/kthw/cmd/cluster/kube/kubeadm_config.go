@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"html/template"
-	"kthw/cmd/infra/server"
 )
 
 var controllerConfigTemplate = `
@@ -30,11 +29,16 @@ nodeRegistration:
 apiVersion: kubeadm.k8s.io/v1beta1
 kind: ClusterConfiguration
 clusterName: kubernetes
+{{if .ControlPlaneEndpoint}}controlPlaneEndpoint: "{{.ControlPlaneEndpoint}}"
+{{end}}apiServer:
+  certSANs:
+  - "{{.PublicIP}}"
+  - "{{.PrivateIP}}"
 etcd:
   external:
     endpoints:{{range .EtcdNodes}}
     - {{.EndpointURL}}
-    {{end}}caFile: /etc/kubernetes/pki/ca.crt
+    {{end}}caFile: /etc/kubernetes/pki/etcd-ca.crt
     certFile: /etc/kubernetes/pki/etcd-client.crt
     keyFile: /etc/kubernetes/pki/etcd-client.key
 kubernetesVersion: v1.14.0
@@ -47,24 +51,30 @@ networking:
 const podNetworkCIDR = "10.100.0.0/16"
 
 type KubeAdmParams struct {
-	PrivateIP      string
-	PublicIP       string
-	NodeName       string
-	EtcdNodes      []*EtcdNode
-	PodNetworkCIDR string
+	PrivateIP            string
+	PublicIP             string
+	NodeName             string
+	EtcdNodes            []*EtcdNode
+	PodNetworkCIDR       string
+	ControlPlaneEndpoint string
 }
 
 type EtcdNode struct {
 	EndpointURL string
 }
 
-func NewKubeAdmParams(hostConfig *server.Config, etcdNodes []*EtcdNode) KubeAdmParams {
-	return KubeAdmParams{
+func NewKubeAdmParams(controllerNode *ControllerNode, etcdNodes []*EtcdNode) KubeAdmParams {
+	hostConfig := controllerNode.Config
+	params := KubeAdmParams{
 		PrivateIP:      hostConfig.PrivateIP,
 		PublicIP:       hostConfig.PublicIP,
 		NodeName:       hostConfig.Name,
 		EtcdNodes:      etcdNodes,
 		PodNetworkCIDR: podNetworkCIDR}
+	if controllerNode.Endpoint != nil {
+		params.ControlPlaneEndpoint = controllerNode.Endpoint.Address()
+	}
+	return params
 }
 
 // GenerateKubeadmControllerConfig generates kubeadm controller config file
@@ -0,0 +1,39 @@
+package kube_test
+
+import (
+	"kthw/cmd/cluster/kube"
+	"kthw/cmd/infra/server"
+	"strings"
+	"testing"
+)
+
+func TestGenerateKubeadmConfigWithControlPlaneEndpoint(t *testing.T) {
+	controllers := []*server.Config{
+		&server.Config{Name: "controller-1", PublicIP: "192.168.1.1", PrivateIP: "10.0.0.1"},
+		&server.Config{Name: "controller-2", PublicIP: "192.168.1.2", PrivateIP: "10.0.0.2"}}
+	controllerNode := &kube.ControllerNode{Config: controllers[0], Endpoint: kube.NewLocalHAProxyEndpoint(controllers)}
+	etcdNodes := []*kube.EtcdNode{&kube.EtcdNode{EndpointURL: "https://10.0.0.1:2379"}}
+
+	config, err := kube.GenerateKubeadmControllerConfig(kube.NewKubeAdmParams(controllerNode, etcdNodes))
+	if err != nil {
+		t.Fatalf("Error while generating kubeadm config: %s", err)
+	}
+
+	if !strings.Contains(config, `controlPlaneEndpoint: "127.0.0.1:8443"`) {
+		t.Errorf("Expected control plane endpoint in kubeadm config, but found none.\n%s", config)
+	}
+}
+
+func TestGenerateKubeadmConfigWithoutControlPlaneEndpoint(t *testing.T) {
+	controllerNode := &kube.ControllerNode{Config: &server.Config{Name: "controller-1", PublicIP: "192.168.1.1"}}
+	etcdNodes := []*kube.EtcdNode{&kube.EtcdNode{EndpointURL: "https://10.0.0.1:2379"}}
+
+	config, err := kube.GenerateKubeadmControllerConfig(kube.NewKubeAdmParams(controllerNode, etcdNodes))
+	if err != nil {
+		t.Fatalf("Error while generating kubeadm config: %s", err)
+	}
+
+	if strings.Contains(config, "controlPlaneEndpoint") {
+		t.Errorf("Expected no control plane endpoint in kubeadm config of a single controller.\n%s", config)
+	}
+}
@@ -0,0 +1,41 @@
+package kube
+
+import (
+	"kthw/certs"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+)
+
+// RotateEtcdClientCertificate re-issues the certificate the API servers use to
+// access etcd, uploads it to all controllers and restarts their API servers.
+func RotateEtcdClientCertificate(serverConfigs []*server.Config, ssh sshconnect.SSHOperations, certGenerator certs.GeneratesCerts) error {
+	controllerNodes, err := SelectControllerNodes(serverConfigs)
+	if err != nil {
+		return err
+	}
+
+	for _, controllerNode := range controllerNodes {
+		host := controllerNode.Config.SSHHost()
+		certCommands, err := uploadEtcdClientCert(host, certGenerator)
+		if err != nil {
+			return err
+		}
+		commands := &sshconnect.Commands{
+			Commands:  append(certCommands, restartAPIServer(controllerNode.Config)),
+			LogOutput: true}
+		err = ssh.RunCmds(commands)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The API server is a static pod. kubelet recreates its container, which
+// then loads the new certificate.
+func restartAPIServer(config *server.Config) *sshconnect.ShellCommand {
+	return &sshconnect.ShellCommand{
+		CommandLine: "docker ps -q --filter name=k8s_kube-apiserver | xargs -r docker stop",
+		Host:        config.SSHHost(),
+		Description: "Restart kube-apiserver"}
+}
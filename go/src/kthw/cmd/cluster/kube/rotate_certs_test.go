@@ -0,0 +1,26 @@
+package kube_test
+
+import (
+	"kthw/certs"
+	"kthw/cmd/cluster/kube"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"testing"
+)
+
+func TestRotateEtcdClientCertificate(t *testing.T) {
+	mock := sshconnect.NewSSHOperationsMock()
+	hostConfigs := []*server.Config{
+		&server.Config{ID: 1, Name: "controller", PublicIP: "192.168.1.1", Roles: []string{"controller"}},
+		&server.Config{ID: 2, Name: "worker", PublicIP: "192.168.1.2", Roles: []string{"worker", "etcd"}}}
+
+	err := kube.RotateEtcdClientCertificate(hostConfigs, mock, certs.NewGeneratesCertsMock())
+	if err != nil {
+		t.Errorf("RotateEtcdClientCertificate returned an unexpected error: %s\n", err)
+	}
+
+	sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload etcd client certificate public key to /etc/kubernetes/pki/etcd-client.crt", hostConfigs[0].PublicIP, t)
+	sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Upload etcd client certificate private key to /etc/kubernetes/pki/etcd-client.key", hostConfigs[0].PublicIP, t)
+	sshconnect.EnsureCommandIssued(mock.RunCmdsCommands, "Restart kube-apiserver", hostConfigs[0].PublicIP, t)
+	sshconnect.EnsureNoCommandsIssued(mock.RunCmdsCommands, hostConfigs[1].PublicIP, t)
+}
@@ -1,14 +1,25 @@
 package kube
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"kthw/cmd/common"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
+	"strings"
+	"time"
 )
 
-// InstallWorkerNode gets a kubeadm join command from controllerName and runs it on host
-func InstallWorkerNode(host *server.Config, controllerNode *ControllerNode, ssh sshconnect.SSHOperations) error {
+var (
+	nodeReadyRetries  = 30
+	nodeReadyInterval = 10 * time.Second
+)
+
+// InstallWorkerNode gets a fresh kubeadm join command from the controller, runs it on
+// host and waits until the node is ready. Waiting stops once ctx is done. Its
+// progress is printed to out. Errors contain the output of the remote host.
+func InstallWorkerNode(ctx context.Context, out io.Writer, host *server.Config, controllerNode *ControllerNode, ssh sshconnect.SSHOperations) error {
 	if common.ArrayContains(host.Roles, "controller") {
 		return fmt.Errorf("Installing worker to service in role controller is not allowed")
 	}
@@ -17,28 +28,69 @@ func InstallWorkerNode(host *server.Config, controllerNode *ControllerNode, ssh
 		return fmt.Errorf("Installing worker on server not possible. It is not in role worker")
 	}
 
-	res, _ := ssh.RunCmd(getClusterJoinCommand(controllerNode), false)
-	fmt.Println(res)
+	endpointCommands, err := endpointSetup(controllerNode.Endpoint, host)
+	if err != nil {
+		return err
+	}
+	setupEndpoint := &sshconnect.Commands{
+		Commands:  endpointCommands,
+		LogOutput: true}
+	err = ssh.RunCmds(setupEndpoint)
+	if err != nil {
+		return err
+	}
 
-	res, err := ssh.RunCmd(runClusterJoinCommand(host, res), false)
+	joinCommand, err := ssh.RunCmd(getClusterJoinCommand(controllerNode), false)
 	if err != nil {
-		return nil
+		return fmt.Errorf("Error while getting join command from controller '%s': %s", controllerNode.Config.Name, err)
 	}
-	fmt.Println("Result - ", res)
 
-	return nil
+	_, err = ssh.RunCmd(runClusterJoinCommand(host, strings.TrimSpace(joinCommand)), false)
+	if err != nil {
+		return fmt.Errorf("Error while joining worker '%s' to cluster: %s", host.Name, err)
+	}
+
+	return waitForNodeReady(ctx, out, host, controllerNode, ssh)
+}
+
+func waitForNodeReady(ctx context.Context, out io.Writer, host *server.Config, controllerNode *ControllerNode, ssh sshconnect.SSHOperations) error {
+	command := checkNodeReady(host, controllerNode)
+	for retries := 0; retries < nodeReadyRetries; retries++ {
+		status, err := ssh.RunCmd(command, false)
+		if sshconnect.IsDryRun(ssh) {
+			return nil
+		}
+		if err == nil && strings.TrimSpace(status) == "True" {
+			fmt.Fprintf(out, "Node %s is ready\n", host.Name)
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(nodeReadyInterval):
+		}
+	}
+	return fmt.Errorf("Node '%s' joined the cluster, but didn't become ready", host.Name)
 }
 
 func getClusterJoinCommand(controller *ControllerNode) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: "kubeadm token create --print-join-command",
-		Host:        controller.Config.PublicIP,
-		Description: "Get cluster join command from controller"}
+		Host:        controller.Config.SSHHost(),
+		Description: "Get cluster join command from controller",
+		Retry:       apiServerRetryPolicy}
 }
 
 func runClusterJoinCommand(host *server.Config, joinCommand string) *sshconnect.ShellCommand {
 	return &sshconnect.ShellCommand{
 		CommandLine: joinCommand,
-		Host:        host.PublicIP,
+		Host:        host.SSHHost(),
 		Description: "Running join cluster command on worker"}
 }
+
+func checkNodeReady(host *server.Config, controller *ControllerNode) *sshconnect.ShellCommand {
+	return &sshconnect.ShellCommand{
+		CommandLine: fmt.Sprintf("kubectl get node %s -o jsonpath='{.status.conditions[?(@.type==\"Ready\")].status}'", host.Name),
+		Host:        controller.Config.SSHHost(),
+		Description: "Check if node is ready"}
+}
@@ -1,10 +1,16 @@
 package kube_test
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"kthw/cmd/cluster/kube"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFailIfHostIsInControllerRole(t *testing.T) {
@@ -12,7 +18,7 @@ func TestFailIfHostIsInControllerRole(t *testing.T) {
 	hostConfig := &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}}
 	controllerNode := &kube.ControllerNode{}
 
-	err := kube.InstallWorkerNode(hostConfig, controllerNode, sshMock)
+	err := kube.InstallWorkerNode(context.Background(), ioutil.Discard, hostConfig, controllerNode, sshMock)
 	if err == nil {
 		t.Errorf("Installing worker nodes is only possible on worker nodes and not on servers in role controller.")
 	}
@@ -23,8 +29,57 @@ func TestFailIfHostIsNotInWorkerNode(t *testing.T) {
 	hostConfig := &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"etcd"}}
 	controllerNode := &kube.ControllerNode{}
 
-	err := kube.InstallWorkerNode(hostConfig, controllerNode, sshMock)
+	err := kube.InstallWorkerNode(context.Background(), ioutil.Discard, hostConfig, controllerNode, sshMock)
 	if err == nil {
 		t.Errorf("Installing worker requires a server to be in role worker.")
 	}
 }
+
+func TestInstallWorkerNode(t *testing.T) {
+	sshMock := sshconnect.NewSSHOperationsMock()
+	sshMock.RunCmdResults["Get cluster join command from controller"] = "kubeadm join 192.168.1.1:6443 --token abc\n"
+	sshMock.RunCmdResults["Check if node is ready"] = "True"
+	hostConfig := &server.Config{ID: 2, Name: "worker-1", PublicIP: "192.168.1.2", Roles: []string{"worker"}}
+	controllerNode := &kube.ControllerNode{Config: &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}}}
+
+	err := kube.InstallWorkerNode(context.Background(), ioutil.Discard, hostConfig, controllerNode, sshMock)
+	if err != nil {
+		t.Fatalf("InstallWorkerNode returned an unexpected error: %s", err)
+	}
+
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdCommands, "Get cluster join command from controller", controllerNode.Config.PublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdCommands, "Running join cluster command on worker", hostConfig.PublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdCommands, "Check if node is ready", controllerNode.Config.PublicIP, t)
+}
+
+func TestFailIfJoinCommandFails(t *testing.T) {
+	sshMock := sshconnect.NewSSHOperationsMock()
+	sshMock.RunCmdResults["Get cluster join command from controller"] = "kubeadm join 192.168.1.1:6443 --token abc"
+	sshMock.RunCmdErrors["Running join cluster command on worker"] = fmt.Errorf("error execution phase preflight")
+	hostConfig := &server.Config{ID: 2, Name: "worker-1", PublicIP: "192.168.1.2", Roles: []string{"worker"}}
+	controllerNode := &kube.ControllerNode{Config: &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}}}
+
+	err := kube.InstallWorkerNode(context.Background(), ioutil.Discard, hostConfig, controllerNode, sshMock)
+	if err == nil {
+		t.Fatalf("Expected an error because the join command failed")
+	}
+
+	if !strings.Contains(err.Error(), "error execution phase preflight") {
+		t.Errorf("Expected error to contain the output of the remote host, but was '%s'", err)
+	}
+}
+
+func TestWaitingForNodeReadyStopsWhenCancelled(t *testing.T) {
+	sshMock := sshconnect.NewSSHOperationsMock()
+	sshMock.RunCmdResults["Get cluster join command from controller"] = "kubeadm join 192.168.1.1:6443 --token abc"
+	sshMock.RunCmdResults["Check if node is ready"] = "False"
+	hostConfig := &server.Config{ID: 2, Name: "worker-1", PublicIP: "192.168.1.2", Roles: []string{"worker"}}
+	controllerNode := &kube.ControllerNode{Config: &server.Config{ID: 1, PublicIP: "192.168.1.1", Roles: []string{"controller"}}}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err := kube.InstallWorkerNode(ctx, ioutil.Discard, hostConfig, controllerNode, sshMock)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected waiting for the node to stop with the context, but got '%v'", err)
+	}
+}
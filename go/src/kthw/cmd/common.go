@@ -1,73 +1,49 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"kthw/certs"
-	"kthw/cmd/cluster/etcd"
-	"kthw/cmd/cluster/kube"
+	"kthw/cluster"
 	"kthw/cmd/common"
-	"kthw/cmd/hcloudclient"
-	"kthw/cmd/infra/network"
-	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
-	"sync"
-	"time"
+	"os"
+	"os/signal"
 
 	"github.com/spf13/viper"
 )
 
-func createServerAndUpdateConfig(config *server.Config) {
-	fmt.Printf("Creating server %s at Hetzner cloud\n", config.Name)
-	hcloudClient := hcloudclient.NewHCloudClient(APIToken)
-	err := server.Create(config, hcloudClient)
-	common.WhenErrPrintAndExit(err)
-
-	config.UpdateConfig()
-	viper.WriteConfig()
-}
-
-func setupWireguardAndUpdateConfig(configs []*server.Config, sshClient sshconnect.SSHOperations) {
-	fmt.Println("Setting up private overlay network")
-	err := network.SetupWireguard(sshClient, configs)
-	common.WhenErrPrintAndExit(err)
-
-	for _, config := range configs {
-		config.UpdateConfig()
-		fmt.Printf("Wireguard set up on %s.\n", config.Name)
-	}
-	viper.WriteConfig()
-}
-
-func installEtcd(configs []*server.Config, sshClient sshconnect.SSHOperations, certGenerator certs.GeneratesCerts) {
-	fmt.Println("Installing etcd")
-
-	err := etcd.InstallOnHost(configs, sshClient, certGenerator)
+// openProject opens the project in the config file with the settings of the
+// command line flags.
+func openProject() *cluster.Project {
+	project, err := cluster.Open(viper.ConfigFileUsed())
 	common.WhenErrPrintAndExit(err)
+	project.APIToken = APIToken
+	project.DryRun = DryRun
+	project.Verbose = Verbose
+	project.ResetKubernetes = ResetKubernetes
+	return project
 }
 
-func installKubernetesController(
-	configs []*server.Config,
-	sshclient sshconnect.SSHOperations,
-	certLoader certs.CertificateLoader,
-	certGenerator certs.GeneratesCerts) {
-
-	fmt.Println("Installing kubernetes controller")
-
-	err := kube.InstallOnHosts(configs, sshclient, certLoader, certGenerator)
+// newSSHClient returns SSHOperations running commands on remote hosts or, in
+// dry-run mode, recording them and printing them on Close.
+func newSSHClient() sshconnect.SSHOperations {
+	sshClient, err := openProject().SSHClient()
 	common.WhenErrPrintAndExit(err)
+	return sshClient
 }
 
-func waitForCloudInitCompleted(waitGroup *sync.WaitGroup, conf *server.Config, sshClient sshconnect.SSHOperations) {
-	fmt.Printf("Waiting for %s to complete cloud-init\n", conf.Name)
-	defer waitGroup.Done()
-
-	cloudInitRunning := true
-	for retries := 0; retries < 20 && cloudInitRunning; retries++ {
-		if server.IsCloudInitCompleted(conf.PublicIP, sshClient) {
-			fmt.Printf("%s completed cloud-init\n", conf.Name)
-			cloudInitRunning = false
-		} else {
-			time.Sleep(time.Second * 20)
-		}
-	}
+// interruptibleContext returns a context which is cancelled on the first
+// interrupt, so that operations stop before their next step. A second
+// interrupt terminates immediately.
+func interruptibleContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		fmt.Println("Interrupted. Stopping after the current step, interrupt again to terminate immediately.")
+		cancel()
+	}()
+	return ctx
 }
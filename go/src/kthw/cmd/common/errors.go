@@ -0,0 +1,43 @@
+package common
+
+import "errors"
+
+// Classes of errors returned by kthw packages. Check them with errors.Is.
+var (
+	// ErrNotFound is the class of errors caused by something which doesn't
+	// exist, e.g. a server in config or at hcloud.
+	ErrNotFound = errors.New("not found")
+	// ErrAuth is the class of errors caused by failed authentication at the
+	// hcloud API or at a host.
+	ErrAuth = errors.New("authentication failed")
+	// ErrRemoteCommand is the class of errors caused by commands which failed
+	// on a host.
+	ErrRemoteCommand = errors.New("remote command failed")
+)
+
+// classifiedError adds a class to an error without changing its message.
+type classifiedError struct {
+	class error
+	err   error
+}
+
+// Classify returns err as error of class. errors.Is(err, class) is 'true' for
+// the returned error. Returns nil if err is nil.
+func Classify(class error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &classifiedError{class: class, err: err}
+}
+
+func (e *classifiedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *classifiedError) Unwrap() error {
+	return e.err
+}
+
+func (e *classifiedError) Is(target error) bool {
+	return target == e.class
+}
@@ -0,0 +1,21 @@
+package common_test
+
+import (
+	"errors"
+	"kthw/cmd/common"
+	"testing"
+)
+
+func TestClassifiedErrorKeepsMessage(t *testing.T) {
+	err := common.Classify(common.ErrNotFound, errors.New("Server 'worker-1' not found in config"))
+
+	if !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("Expected error to be of class ErrNotFound")
+	}
+	if err.Error() != "Server 'worker-1' not found in config" {
+		t.Errorf("Message of classified error changed to '%s'", err)
+	}
+	if common.Classify(common.ErrNotFound, nil) != nil {
+		t.Errorf("Expected nil when classifying nil")
+	}
+}
@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"kthw/cmd/common"
+
+	"github.com/spf13/cobra"
+)
+
+// ResetKubernetes controls if 'kubeadm reset' runs on servers before they are deleted.
+var ResetKubernetes bool
+
+var destroyCommand = &cobra.Command{
+	Use:         "destroy",
+	Short:       "Delete servers and SSH keys and remove their state from config",
+	Annotations: supportsDryRun}
+
+var destroyServerCommand = &cobra.Command{
+	Use:   "server <name>",
+	Short: "Deletes a server and removes its id and IPs from config",
+	Long:  "Servers of the static provider are not deleted, only their state is removed from config.",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		err := openProject().DestroyServer(interruptibleContext(), args[0])
+		common.WhenErrPrintAndExit(err)
+	}}
+
+var destroyProjectCommand = &cobra.Command{
+	Use:   "project",
+	Short: "Deletes all servers and the SSH key at hcloud and removes their state from config",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := openProject().Destroy(interruptibleContext())
+		common.WhenErrPrintAndExit(err)
+	}}
+
+func destroyCommands() *cobra.Command {
+	destroyCommand.PersistentFlags().BoolVarP(&ResetKubernetes, "reset", "r", false, "Run 'kubeadm reset' on servers before deleting them.")
+	destroyCommand.AddCommand(destroyServerCommand)
+	destroyCommand.AddCommand(destroyProjectCommand)
+	return destroyCommand
+}
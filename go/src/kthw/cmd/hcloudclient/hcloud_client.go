@@ -2,17 +2,28 @@ package hcloudclient
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"os"
+	"kthw/cmd/common"
 
 	"github.com/hetznercloud/hcloud-go/hcloud"
 	"golang.org/x/crypto/ssh"
 )
 
+// errorCodeUnauthorized is returned by the hcloud API for invalid API tokens.
+const errorCodeUnauthorized hcloud.ErrorCode = "unauthorized"
+
 // HCloudOperations defines operations to be implemented by HCloudClient
 type HCloudOperations interface {
-	Create(opts hcloud.ServerCreateOpts) *CreateServerResults
-	CreateSSHKey(opts hcloud.SSHKeyCreateOpts) *CreateSSHKeyResults
+	Create(opts hcloud.ServerCreateOpts) (*CreateServerResults, error)
+	CreateSSHKey(opts hcloud.SSHKeyCreateOpts) (*CreateSSHKeyResults, error)
+	GetServer(id int) (*GetServerResults, error)
+	DeleteServer(id int) error
+	DeleteSSHKey(id int) error
+	ServerTypeExists(name string) (bool, error)
+	ImageExists(name string) (bool, error)
+	LocationExists(name string) (bool, error)
+	DatacenterLocation(name string) (string, error)
 }
 
 // HCloudClient talks to the hcloud API
@@ -22,14 +33,13 @@ type HCloudClient struct {
 }
 
 // NewHCloudClient creates a new HetznerClient using the APIToken provided as a flag
-func NewHCloudClient(apiToken string) *HCloudClient {
+func NewHCloudClient(apiToken string) (*HCloudClient, error) {
 	if apiToken == "" {
-		fmt.Println("APIToken not set. Did you set the --apiToken flag?")
-		os.Exit(1)
+		return nil, common.Classify(common.ErrAuth, fmt.Errorf("APIToken not set. Did you set the --apiToken flag?"))
 	}
 	client := hcloud.NewClient(hcloud.WithToken(apiToken))
 
-	return &HCloudClient{client: client, context: context.Background()}
+	return &HCloudClient{client: client, context: context.Background()}, nil
 }
 
 // CreateServerResults groups returned data from hcloud
@@ -41,14 +51,40 @@ type CreateServerResults struct {
 }
 
 // Create creates a server using hcloud API and the provided options
-func (hc *HCloudClient) Create(opts hcloud.ServerCreateOpts) *CreateServerResults {
+func (hc *HCloudClient) Create(opts hcloud.ServerCreateOpts) (*CreateServerResults, error) {
 	serverCreateResult, _, err := hc.client.Server.Create(hc.context, opts)
-	hc.ensureNoError(err)
+	if err != nil {
+		return nil, classify(fmt.Errorf("Error while creating server '%s': %w", opts.Name, err))
+	}
 	return &CreateServerResults{
 		ID:           serverCreateResult.Server.ID,
 		PublicIP:     serverCreateResult.Server.PublicNet.IPv4.IP.String(),
 		RootPassword: serverCreateResult.RootPassword,
-		DNSName:      serverCreateResult.Server.PublicNet.IPv4.DNSPtr}
+		DNSName:      serverCreateResult.Server.PublicNet.IPv4.DNSPtr}, nil
+}
+
+// GetServerResults groups returned data from hcloud
+type GetServerResults struct {
+	ID       int
+	PublicIP string
+	DNSName  string
+	Status   string
+}
+
+// GetServer looks up the server with the given ID. It returns nil if the server doesn't exist.
+func (hc *HCloudClient) GetServer(id int) (*GetServerResults, error) {
+	server, _, err := hc.client.Server.GetByID(hc.context, id)
+	if err != nil {
+		return nil, classify(fmt.Errorf("Error while looking up server '%d': %w", id, err))
+	}
+	if server == nil {
+		return nil, nil
+	}
+	return &GetServerResults{
+		ID:       server.ID,
+		PublicIP: server.PublicNet.IPv4.IP.String(),
+		DNSName:  server.PublicNet.IPv4.DNSPtr,
+		Status:   string(server.Status)}, nil
 }
 
 // CreateSSHKeyResults groups returned data from hcloud
@@ -57,35 +93,127 @@ type CreateSSHKeyResults struct {
 }
 
 // CreateSSHKey creates a SSH key in hcloud
-func (hc *HCloudClient) CreateSSHKey(opts hcloud.SSHKeyCreateOpts) *CreateSSHKeyResults {
-	md5Fingerprint := fingerprintMD5(opts.PublicKey)
+func (hc *HCloudClient) CreateSSHKey(opts hcloud.SSHKeyCreateOpts) (*CreateSSHKeyResults, error) {
+	md5Fingerprint, err := fingerprintMD5(opts.PublicKey)
+	if err != nil {
+		return nil, err
+	}
 
 	sshKey, _, err := hc.client.SSHKey.GetByFingerprint(hc.context, md5Fingerprint)
-	hc.ensureNoError(err)
+	if err != nil {
+		return nil, classify(fmt.Errorf("Error while looking up SSH key '%s': %w", opts.Name, err))
+	}
 
 	if sshKey == nil {
 		sshKey, _, err = hc.client.SSHKey.Create(hc.context, opts)
-		hc.ensureNoError(err)
+		if err != nil {
+			return nil, classify(fmt.Errorf("Error while creating SSH key '%s': %w", opts.Name, err))
+		}
 	}
 
 	return &CreateSSHKeyResults{
-		ID: sshKey.ID}
+		ID: sshKey.ID}, nil
+}
+
+// DeleteServer deletes the server with the given ID. Deleting a server which
+// doesn't exist anymore is not an error.
+func (hc *HCloudClient) DeleteServer(id int) error {
+	server, _, err := hc.client.Server.GetByID(hc.context, id)
+	if err != nil {
+		return classify(fmt.Errorf("Error while looking up server '%d': %w", id, err))
+	}
+	if server == nil {
+		return nil
+	}
+
+	_, err = hc.client.Server.Delete(hc.context, server)
+	if err != nil {
+		return classify(fmt.Errorf("Error while deleting server '%d': %w", id, err))
+	}
+	return nil
+}
+
+// DeleteSSHKey deletes the SSH key with the given ID. Deleting a key which
+// doesn't exist anymore is not an error.
+func (hc *HCloudClient) DeleteSSHKey(id int) error {
+	sshKey, _, err := hc.client.SSHKey.GetByID(hc.context, id)
+	if err != nil {
+		return classify(fmt.Errorf("Error while looking up SSH key '%d': %w", id, err))
+	}
+	if sshKey == nil {
+		return nil
+	}
+
+	_, err = hc.client.SSHKey.Delete(hc.context, sshKey)
+	if err != nil {
+		return classify(fmt.Errorf("Error while deleting SSH key '%d': %w", id, err))
+	}
+	return nil
 }
 
-func fingerprintMD5(publicKey string) string {
+// ServerTypeExists returns 'true' if there is a server type with name.
+func (hc *HCloudClient) ServerTypeExists(name string) (bool, error) {
+	serverType, _, err := hc.client.ServerType.GetByName(hc.context, name)
+	if err != nil {
+		return false, classify(fmt.Errorf("Error while looking up server type '%s': %w", name, err))
+	}
+	return serverType != nil, nil
+}
+
+// ImageExists returns 'true' if there is an image with name.
+func (hc *HCloudClient) ImageExists(name string) (bool, error) {
+	image, _, err := hc.client.Image.GetByName(hc.context, name)
+	if err != nil {
+		return false, classify(fmt.Errorf("Error while looking up image '%s': %w", name, err))
+	}
+	return image != nil, nil
+}
+
+// LocationExists returns 'true' if there is a location with name.
+func (hc *HCloudClient) LocationExists(name string) (bool, error) {
+	location, _, err := hc.client.Location.GetByName(hc.context, name)
+	if err != nil {
+		return false, classify(fmt.Errorf("Error while looking up location '%s': %w", name, err))
+	}
+	return location != nil, nil
+}
+
+// DatacenterLocation returns the name of the location of the datacenter with
+// name. It returns an empty string if the datacenter doesn't exist.
+func (hc *HCloudClient) DatacenterLocation(name string) (string, error) {
+	datacenter, _, err := hc.client.Datacenter.GetByName(hc.context, name)
+	if err != nil {
+		return "", classify(fmt.Errorf("Error while looking up datacenter '%s': %w", name, err))
+	}
+	if datacenter == nil || datacenter.Location == nil {
+		return "", nil
+	}
+	return datacenter.Location.Name, nil
+}
+
+func fingerprintMD5(publicKey string) (string, error) {
 	pk, _, _, _, err := ssh.ParseAuthorizedKey([]byte(publicKey))
 	if err != nil {
-		panic(err)
+		return "", fmt.Errorf("Error while parsing SSH public key: %w", err)
 	}
 
 	// Get the fingerprint
 	f := ssh.FingerprintLegacyMD5(pk)
-	return f
+	return f, nil
 }
 
-func (hc *HCloudClient) ensureNoError(err error) {
-	if err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+// classify classifies errors of the hcloud API caused by an invalid API token
+// as common.ErrAuth and missing resources as common.ErrNotFound.
+func classify(err error) error {
+	var apiErr hcloud.Error
+	if !errors.As(err, &apiErr) {
+		return err
+	}
+	switch apiErr.Code {
+	case errorCodeUnauthorized:
+		return common.Classify(common.ErrAuth, err)
+	case hcloud.ErrorCodeNotFound:
+		return common.Classify(common.ErrNotFound, err)
 	}
+	return err
 }
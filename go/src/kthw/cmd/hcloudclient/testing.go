@@ -1,22 +1,71 @@
 package hcloudclient
 
 import (
+	"kthw/cmd/common"
+
 	"github.com/hetznercloud/hcloud-go/hcloud"
 )
 
 // MockHCloudOperations mock object to test code which depends on HCloudOperations
 type MockHCloudOperations struct {
 	CreateServerResults *CreateServerResults
+	CreatedServerOpts   []hcloud.ServerCreateOpts
 	CreateSSHKeyResults *CreateSSHKeyResults
-	Err                 error
+	GetServerResults    *GetServerResults
+	DeletedServerIDs    []int
+	DeletedSSHKeyIDs    []int
+	ServerTypes         []string
+	Images              []string
+	Locations           []string
+	// Datacenters maps names of datacenters to the name of their location.
+	Datacenters map[string]string
+	Err         error
 }
 
-// Create returns createServerResults defiend in MockHCloudOperations
-func (m *MockHCloudOperations) Create(opts hcloud.ServerCreateOpts) *CreateServerResults {
-	return m.CreateServerResults
+// Create records opts and returns createServerResults defiend in MockHCloudOperations
+func (m *MockHCloudOperations) Create(opts hcloud.ServerCreateOpts) (*CreateServerResults, error) {
+	m.CreatedServerOpts = append(m.CreatedServerOpts, opts)
+	return m.CreateServerResults, m.Err
 }
 
 // CreateSSHKey returns createSSHKeyResults defiend in MockHCloudOperations
-func (m *MockHCloudOperations) CreateSSHKey(opts hcloud.SSHKeyCreateOpts) *CreateSSHKeyResults {
-	return m.CreateSSHKeyResults
+func (m *MockHCloudOperations) CreateSSHKey(opts hcloud.SSHKeyCreateOpts) (*CreateSSHKeyResults, error) {
+	return m.CreateSSHKeyResults, m.Err
+}
+
+// GetServer returns getServerResults defined in MockHCloudOperations
+func (m *MockHCloudOperations) GetServer(id int) (*GetServerResults, error) {
+	return m.GetServerResults, m.Err
+}
+
+// DeleteServer records the id of the deleted server and returns Err defined in MockHCloudOperations
+func (m *MockHCloudOperations) DeleteServer(id int) error {
+	m.DeletedServerIDs = append(m.DeletedServerIDs, id)
+	return m.Err
+}
+
+// DeleteSSHKey records the id of the deleted SSH key and returns Err defined in MockHCloudOperations
+func (m *MockHCloudOperations) DeleteSSHKey(id int) error {
+	m.DeletedSSHKeyIDs = append(m.DeletedSSHKeyIDs, id)
+	return m.Err
+}
+
+// ServerTypeExists returns 'true' if name is in ServerTypes
+func (m *MockHCloudOperations) ServerTypeExists(name string) (bool, error) {
+	return common.ArrayContains(m.ServerTypes, name), m.Err
+}
+
+// ImageExists returns 'true' if name is in Images
+func (m *MockHCloudOperations) ImageExists(name string) (bool, error) {
+	return common.ArrayContains(m.Images, name), m.Err
+}
+
+// LocationExists returns 'true' if name is in Locations
+func (m *MockHCloudOperations) LocationExists(name string) (bool, error) {
+	return common.ArrayContains(m.Locations, name), m.Err
+}
+
+// DatacenterLocation returns the location of the datacenter with name defined in Datacenters
+func (m *MockHCloudOperations) DatacenterLocation(name string) (string, error) {
+	return m.Datacenters[name], m.Err
 }
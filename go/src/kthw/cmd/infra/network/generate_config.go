@@ -6,12 +6,15 @@ import (
 	"encoding/base64"
 	"fmt"
 	"kthw/cmd/infra/server"
-	"os"
 	"text/template"
 
 	"golang.org/x/crypto/curve25519"
 )
 
+// OverlayNetworkCIDR is the network private IPs of servers in the wireguard
+// overlay network are assigned from.
+const OverlayNetworkCIDR = "10.0.0.0/24"
+
 var serverInterfaceTemplate = `[Interface]
 PrivateKey = {{.PrivateKey}}
 ListenPort = 51820
@@ -36,15 +39,14 @@ type Host struct {
 }
 
 // ToPeer generates a Peer from the fields of a Host
-func (h *Host) ToPeer() Peer {
+func (h *Host) ToPeer() (Peer, error) {
 	if h.PublicKey == "" || h.PrivateIP == "" || h.PublicIP == "" {
-		fmt.Printf("Error converting host to peer. Private key (%s), private ip (%s) and public key (%s) must be non-zero", h.PrivateKey, h.PrivateIP, h.PublicKey)
-		os.Exit(1)
+		return Peer{}, fmt.Errorf("Error converting host to peer. Public key (%s), private ip (%s) and public ip (%s) must be non-zero", h.PublicKey, h.PrivateIP, h.PublicIP)
 	}
 	return Peer{
 		PublicKey:  h.PublicKey,
 		AllowedIPs: h.PrivateIP,
-		Endpoint:   h.PublicIP}
+		Endpoint:   h.PublicIP}, nil
 }
 
 func (h *Host) generateServerConf() (string, error) {
@@ -54,7 +56,10 @@ func (h *Host) generateServerConf() (string, error) {
 	}
 
 	var confBuffer bytes.Buffer
-	tmpl.ExecuteTemplate(&confBuffer, "interface", h)
+	err = tmpl.ExecuteTemplate(&confBuffer, "interface", h)
+	if err != nil {
+		return "", err
+	}
 
 	renderedConfig := confBuffer.String()
 	return renderedConfig, nil
@@ -74,9 +79,15 @@ type WgConf struct {
 
 // GenerateWireguardConf generates wireguard configuration for all servers passed on.
 func GenerateWireguardConf(servers []*server.Config) (*WgConf, error) {
-	hosts := genAndAddKeys(servers)
+	hosts, err := genAndAddKeys(servers)
+	if err != nil {
+		return nil, err
+	}
 
-	peers := newPeers(hosts)
+	peers, err := newPeers(hosts)
+	if err != nil {
+		return nil, err
+	}
 	for _, host := range hosts {
 		allOtherPeers := peers.selectAllExcept(host)
 		host.Peers = allOtherPeers
@@ -101,12 +112,16 @@ func (p *peers) selectAllExcept(h *Host) []Peer {
 	return selected
 }
 
-func newPeers(hosts []*Host) peers {
+func newPeers(hosts []*Host) (peers, error) {
 	allPeers := make([]Peer, len(hosts))
 	for cnt, host := range hosts {
-		allPeers[cnt] = host.ToPeer()
+		peer, err := host.ToPeer()
+		if err != nil {
+			return peers{}, err
+		}
+		allPeers[cnt] = peer
 	}
-	return peers{all: allPeers}
+	return peers{all: allPeers}, nil
 }
 
 type internalIPGenerator struct {
@@ -118,14 +133,13 @@ func (i *internalIPGenerator) nextIP() string {
 	return fmt.Sprintf("10.0.0.%d", i.IPCount)
 }
 
-func genAndAddKeys(serverConfigs []*server.Config) []*Host {
+func genAndAddKeys(serverConfigs []*server.Config) ([]*Host, error) {
 	ipGen := internalIPGenerator{}
 	results := make([]*Host, len(serverConfigs))
 	for count, conf := range serverConfigs {
 		keyPair, err := generateKeyPair()
 		if err != nil {
-			fmt.Printf("Error generating private key: %s", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("Error generating private key: %w", err)
 		}
 
 		conf.PrivateIP = ipGen.nextIP()
@@ -137,7 +151,7 @@ func genAndAddKeys(serverConfigs []*server.Config) []*Host {
 			ServerConfig: conf}
 		results[count] = &host
 	}
-	return results
+	return results, nil
 }
 
 type keyPair struct {
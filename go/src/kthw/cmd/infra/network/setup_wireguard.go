@@ -4,25 +4,42 @@ import (
 	"kthw/cmd/infra/server"
 	"kthw/cmd/sshconnect"
 	"strings"
+
+	"github.com/spf13/viper"
 )
 
 // SetupWireguard generated wireguard config for each server and copies it using SCP.
+// All servers are set up concurrently.
 func SetupWireguard(sshOperations sshconnect.SSHOperations, servers []*server.Config) error {
-	wgConfs, _ := GenerateWireguardConf(servers)
-	for _, hostConf := range wgConfs.WgHosts {
-		hostIP := hostConf.PublicIP
-		conf, _ := hostConf.generateServerConf()
+	return SetupWireguardIn(viper.GetViper(), sshOperations, servers)
+}
 
-		commands := &sshconnect.Commands{
+// SetupWireguardIn sets up wireguard like SetupWireguard with the concurrency
+// configured in config.
+func SetupWireguardIn(config *viper.Viper, sshOperations sshconnect.SSHOperations, servers []*server.Config) error {
+	// Generating the config assigns private IPs, which are only reachable
+	// once wireguard is set up.
+	sshHosts := server.SSHHosts(servers)
+	wgConfs, err := GenerateWireguardConf(servers)
+	if err != nil {
+		return err
+	}
+	var batches []*sshconnect.Commands
+	for i, hostConf := range wgConfs.WgHosts {
+		hostIP := sshHosts[i]
+		conf, err := hostConf.generateServerConf()
+		if err != nil {
+			return err
+		}
+
+		batches = append(batches, &sshconnect.Commands{
 			Commands: []sshconnect.Command{
 				uploadConfigFile(hostIP, conf),
 				openFirewall(hostIP),
 				startDevice(hostIP)},
-			LogOutput: true}
-
-		sshOperations.RunCmds(commands)
+			LogOutput: true})
 	}
-	return nil
+	return sshconnect.ReadParallelIn(config).RunCmds(sshOperations, batches)
 }
 
 func openFirewall(host string) *sshconnect.ShellCommand {
@@ -44,5 +61,8 @@ func uploadConfigFile(host string, configFile string) *sshconnect.CopyFileComman
 		Host:        host,
 		FileContent: strings.NewReader(configFile),
 		FilePath:    "/etc/wireguard/wg0.conf",
+		Mode:        sshconnect.PrivateKeyFileMode,
+		Atomic:      true,
+		Sensitive:   true,
 		Description: "Upload wireguard config file of device 'wg0'"}
 }
@@ -0,0 +1,53 @@
+package provider
+
+import (
+	"fmt"
+	"io"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+)
+
+// DryRunProvider replaces providers calling an API in dry-run mode. It only
+// prints what would be done. Servers which don't exist yet get a placeholder
+// as public IP, so that the commands of later phases can be planned.
+type DryRunProvider struct {
+	name string
+	out  io.Writer
+	Provider
+}
+
+// NewDryRunProvider creates a DryRunProvider for the provider with name,
+// which prints to out.
+func NewDryRunProvider(name string, out io.Writer) *DryRunProvider {
+	return &DryRunProvider{name: name, out: out}
+}
+
+// Create prints that the server would be created.
+func (d *DryRunProvider) Create(config *server.Config) error {
+	fmt.Fprintf(d.out, "Would create server %s using provider %s\n", config.Name, d.name)
+	if config.PublicIP == "" {
+		config.PublicIP = fmt.Sprintf("<public IP of %s>", config.Name)
+	}
+	return nil
+}
+
+// Delete prints that the server would be deleted. config is not changed.
+func (d *DryRunProvider) Delete(config *server.Config) error {
+	fmt.Fprintf(d.out, "Would delete server %s using provider %s\n", config.Name, d.name)
+	return nil
+}
+
+// Get keeps the IPs in config.
+func (d *DryRunProvider) Get(config *server.Config) error {
+	return nil
+}
+
+// Validate accepts every server, as the API isn't called in dry-run mode.
+func (d *DryRunProvider) Validate(config *server.Config) error {
+	return nil
+}
+
+// IsReady returns 'true', as there is nothing to wait for.
+func (d *DryRunProvider) IsReady(config *server.Config, ssh sshconnect.SSHOperations) bool {
+	return true
+}
@@ -0,0 +1,73 @@
+package provider
+
+import (
+	"fmt"
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+)
+
+// HCloudProvider creates servers at Hetzner cloud. Servers use type, image and
+// location from server.Config and are set up with cloud-init.
+type HCloudProvider struct {
+	client     hcloudclient.HCloudOperations
+	knownHosts *sshconnect.KnownHosts
+	Provider
+}
+
+// NewHCloudProvider creates a HCloudProvider using client. Host keys of
+// created servers are recorded in knownHosts.
+func NewHCloudProvider(client hcloudclient.HCloudOperations, knownHosts *sshconnect.KnownHosts) *HCloudProvider {
+	return &HCloudProvider{client: client, knownHosts: knownHosts}
+}
+
+// Create creates a server at hcloud.
+func (h *HCloudProvider) Create(config *server.Config) error {
+	return server.Create(config, h.client, h.knownHosts)
+}
+
+// Delete deletes a server at hcloud. Its host key is removed from known hosts,
+// as the public IP may be assigned to another server later.
+func (h *HCloudProvider) Delete(config *server.Config) error {
+	publicIP := config.PublicIP
+	err := server.Delete(config, h.client)
+	if err != nil {
+		return err
+	}
+	if publicIP == "" {
+		return nil
+	}
+	return h.knownHosts.Remove(publicIP)
+}
+
+// Get refreshes the public IP of a server from hcloud.
+func (h *HCloudProvider) Get(config *server.Config) error {
+	if !config.IsProvisioned() {
+		return fmt.Errorf("Server '%s' was not created at hcloud", config.Name)
+	}
+
+	result, err := h.client.GetServer(config.ID)
+	if err != nil {
+		return err
+	}
+	if result == nil {
+		return fmt.Errorf("Server '%s' with id '%d' doesn't exist at hcloud", config.Name, config.ID)
+	}
+	config.PublicIP = result.PublicIP
+	return nil
+}
+
+// Validate returns an error if server type, image, location or placement of
+// the server don't exist at hcloud.
+func (h *HCloudProvider) Validate(config *server.Config) error {
+	err := config.ValidateAtHCloud(h.client)
+	if err != nil {
+		return fmt.Errorf("Server '%s' can't be created: %w", config.Name, err)
+	}
+	return nil
+}
+
+// IsReady returns 'true' if cloud-init completed on the server.
+func (h *HCloudProvider) IsReady(config *server.Config, ssh sshconnect.SSHOperations) bool {
+	return server.IsCloudInitCompleted(config.SSHHost(), ssh)
+}
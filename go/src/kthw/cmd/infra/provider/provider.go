@@ -0,0 +1,89 @@
+package provider
+
+import (
+	"fmt"
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	confProviderNameKey = "provider.name"
+
+	// HCloud creates servers at Hetzner cloud.
+	HCloud = "hcloud"
+	// Static uses existing hosts reachable via SSH at the public IPs from config.
+	Static = "static"
+)
+
+var validProviders = []string{HCloud, Static}
+
+// Provider creates, deletes and looks up the servers a cluster is installed on.
+// All phases after creating servers only use the IPs in server.Config and work
+// the same way with every provider.
+type Provider interface {
+	// Create creates a server and sets ID and IPs of config. Calling code is assumed to write the configuration.
+	Create(config *server.Config) error
+	// Delete deletes a server and clears its state from config. Calling code is assumed to write the configuration.
+	Delete(config *server.Config) error
+	// Get refreshes IPs of config from the provider.
+	Get(config *server.Config) error
+	// IsReady returns 'true' if the server is set up and all phases can run on it.
+	IsReady(config *server.Config, ssh sshconnect.SSHOperations) bool
+	// Validate returns an error if the server can't be created, e.g. because its
+	// server type doesn't exist. It is called for all servers before the first is created.
+	Validate(config *server.Config) error
+}
+
+// IsValidProvider returns an error if name is not a valid provider.
+func IsValidProvider(name string) error {
+	for _, validProvider := range validProviders {
+		if name == validProvider {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not a valid provider. Valid providers are: %s", name, strings.Join(validProviders, ", "))
+}
+
+// SetProviderName sets the provider of a project. Changes are not persisted.
+func SetProviderName(name string) {
+	viper.Set(confProviderNameKey, name)
+}
+
+// ReadProviderName reads the provider of a project from config. Projects without provider use hcloud.
+func ReadProviderName() string {
+	return ReadProviderNameIn(viper.GetViper())
+}
+
+// ReadProviderNameIn reads the provider of a project from config like ReadProviderName.
+func ReadProviderNameIn(config *viper.Viper) string {
+	name := config.GetString(confProviderNameKey)
+	if name == "" {
+		return HCloud
+	}
+	return name
+}
+
+// New creates the provider configured for the project. apiToken is only used by hcloud.
+func New(apiToken string, ssh sshconnect.SSHOperations) (Provider, error) {
+	return NewIn(viper.GetViper(), apiToken, ssh)
+}
+
+// NewIn creates the provider configured in config like New.
+func NewIn(config *viper.Viper, apiToken string, ssh sshconnect.SSHOperations) (Provider, error) {
+	name := ReadProviderNameIn(config)
+	switch name {
+	case HCloud:
+		client, err := hcloudclient.NewHCloudClient(apiToken)
+		if err != nil {
+			return nil, err
+		}
+		return NewHCloudProvider(client, sshconnect.DefaultKnownHostsIn(config)), nil
+	case Static:
+		return NewStaticProvider(ssh), nil
+	}
+	return nil, IsValidProvider(name)
+}
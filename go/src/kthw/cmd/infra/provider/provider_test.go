@@ -0,0 +1,116 @@
+package provider_test
+
+import (
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/provider"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"testing"
+
+	viper "github.com/spf13/viper"
+)
+
+func TestReadProviderNameDefaultsToHCloud(t *testing.T) {
+	viper.Reset()
+
+	if provider.ReadProviderName() != provider.HCloud {
+		t.Errorf("Expected provider '%s' if none is configured, but was '%s'", provider.HCloud, provider.ReadProviderName())
+	}
+
+	provider.SetProviderName(provider.Static)
+	if provider.ReadProviderName() != provider.Static {
+		t.Errorf("Expected provider '%s', but was '%s'", provider.Static, provider.ReadProviderName())
+	}
+}
+
+func TestIsValidProvider(t *testing.T) {
+	if provider.IsValidProvider("aws") == nil {
+		t.Errorf("Expected error because 'aws' is not a valid provider")
+	}
+	if provider.IsValidProvider(provider.Static) != nil {
+		t.Errorf("Got an error for valid provider '%s'", provider.Static)
+	}
+}
+
+func TestStaticProviderCreate(t *testing.T) {
+	sshMock := sshconnect.NewSSHOperationsMock()
+	staticProvider := provider.NewStaticProvider(sshMock)
+	config := &server.Config{Name: "m1", PublicIP: "192.168.1.1"}
+
+	err := staticProvider.Create(config)
+	if err != nil {
+		t.Fatalf("Unexpected error while creating static server: %s", err)
+	}
+
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Install wireguard, docker and kubernetes packages", config.PublicIP, t)
+	sshconnect.EnsureCommandIssued(sshMock.RunCmdsCommands, "Copy docker daemon config", config.PublicIP, t)
+}
+
+func TestStaticProviderCreateFailsWithoutPublicIP(t *testing.T) {
+	staticProvider := provider.NewStaticProvider(sshconnect.NewSSHOperationsMock())
+
+	err := staticProvider.Create(&server.Config{Name: "m1"})
+	if err == nil {
+		t.Errorf("Expected an error because static server has no public IP")
+	}
+}
+
+func TestStaticProviderDeleteKeepsPublicIP(t *testing.T) {
+	viper.Reset()
+	staticProvider := provider.NewStaticProvider(sshconnect.NewSSHOperationsMock())
+	config := &server.Config{Name: "m1", PublicIP: "192.168.1.1", PrivateIP: "10.0.0.1"}
+	config.CompletePhase(server.PhaseServer)
+
+	err := staticProvider.Delete(config)
+	if err != nil {
+		t.Fatalf("Unexpected error while deleting static server: %s", err)
+	}
+
+	fromConfig := server.Config{Name: "m1"}
+	fromConfig.ReadFromConfig()
+	if fromConfig.PublicIP != "192.168.1.1" {
+		t.Errorf("Expected public IP of static server to be kept, but was '%s'", fromConfig.PublicIP)
+	}
+	if fromConfig.IsPhaseCompleted(server.PhaseServer) {
+		t.Errorf("Expected completed phases to be cleared")
+	}
+}
+
+func TestHCloudProviderGet(t *testing.T) {
+	hcloudClient := &hcloudclient.MockHCloudOperations{
+		GetServerResults: &hcloudclient.GetServerResults{ID: 42, PublicIP: "192.168.1.42"}}
+	hcloudProvider := provider.NewHCloudProvider(hcloudClient, sshconnect.NewKnownHosts("known_hosts"))
+	config := &server.Config{Name: "m1", ID: 42}
+
+	err := hcloudProvider.Get(config)
+	if err != nil {
+		t.Fatalf("Unexpected error while getting server: %s", err)
+	}
+
+	if config.PublicIP != "192.168.1.42" {
+		t.Errorf("Expected public IP '192.168.1.42', but was '%s'", config.PublicIP)
+	}
+}
+
+func TestHCloudProviderValidate(t *testing.T) {
+	hcloudClient := &hcloudclient.MockHCloudOperations{
+		ServerTypes: []string{"cx21"},
+		Images:      []string{"ubuntu-18.04"},
+		Locations:   []string{"nbg1"}}
+	hcloudProvider := provider.NewHCloudProvider(hcloudClient, sshconnect.NewKnownHosts("known_hosts"))
+	config := &server.Config{Name: "m1", ServerType: "cx21", ImageName: "ubuntu-18.04", LocationName: "nbg1"}
+
+	err := hcloudProvider.Validate(config)
+	if err != nil {
+		t.Errorf("Unexpected error for valid server: %s", err)
+	}
+
+	config.ImageName = "ubuntu-99.04"
+	err = hcloudProvider.Validate(config)
+	if err == nil {
+		t.Errorf("Expected error because image ubuntu-99.04 doesn't exist")
+	}
+	if len(hcloudClient.CreatedServerOpts) != 0 {
+		t.Errorf("Expected validation not to create servers")
+	}
+}
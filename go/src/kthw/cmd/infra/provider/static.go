@@ -0,0 +1,120 @@
+package provider
+
+import (
+	"fmt"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/sshconnect"
+	"strings"
+)
+
+var dockerDaemonConfig = `{
+  "exec-opts": ["native.cgroupdriver=systemd"],
+  "log-driver": "json-file",
+  "log-opts": {
+    "max-size": "100m"
+  },
+  "storage-driver": "overlay2"
+}
+`
+
+// StaticProvider uses existing hosts, e.g. bare-metal servers or VMs, which
+// are reachable via SSH at the public IP set in config. Create installs the
+// packages servers created at hcloud get from cloud-init. Hosts must run Ubuntu.
+type StaticProvider struct {
+	ssh sshconnect.SSHOperations
+	Provider
+}
+
+// NewStaticProvider creates a StaticProvider which sets up hosts using ssh.
+func NewStaticProvider(ssh sshconnect.SSHOperations) *StaticProvider {
+	return &StaticProvider{ssh: ssh}
+}
+
+// Create installs required packages on an existing host.
+func (s *StaticProvider) Create(config *server.Config) error {
+	err := s.Validate(config)
+	if err != nil {
+		return err
+	}
+
+	commands := &sshconnect.Commands{
+		Commands:  prepareHost(config.SSHHost()),
+		LogOutput: true}
+	return s.ssh.RunCmds(commands)
+}
+
+// Validate returns an error if the host has no public IP.
+func (s *StaticProvider) Validate(config *server.Config) error {
+	if config.PublicIP == "" {
+		return fmt.Errorf("Server '%s' has no public IP. Static hosts must be added with an IP", config.Name)
+	}
+	return nil
+}
+
+// Delete clears the state of a host from config. The host itself is not touched
+// and its public IP is kept.
+func (s *StaticProvider) Delete(config *server.Config) error {
+	publicIP := config.PublicIP
+	config.ClearProvisionedState()
+	config.PublicIP = publicIP
+	return config.UpdateConfig()
+}
+
+// Get does nothing, because IPs of static hosts only exist in config.
+func (s *StaticProvider) Get(config *server.Config) error {
+	if config.PublicIP == "" {
+		return fmt.Errorf("Server '%s' has no public IP", config.Name)
+	}
+	return nil
+}
+
+// IsReady returns 'true' if all tools required to install a cluster exist on the host.
+func (s *StaticProvider) IsReady(config *server.Config, ssh sshconnect.SSHOperations) bool {
+	command := &sshconnect.ShellCommand{
+		Host:        config.SSHHost(),
+		CommandLine: "command -v wg && command -v docker && command -v kubeadm",
+		Description: "Check if required packages are installed"}
+	_, err := ssh.RunCmd(command, false)
+	return err == nil
+}
+
+func prepareHost(host string) []sshconnect.Command {
+	addRepositories := []string{
+		"apt-get update",
+		"DEBIAN_FRONTEND=noninteractive apt-get install -y apt-transport-https ca-certificates curl software-properties-common",
+		"curl -fsSL https://download.docker.com/linux/ubuntu/gpg | apt-key add -",
+		"add-apt-repository -y \"deb [arch=amd64] https://download.docker.com/linux/ubuntu $(lsb_release -cs) stable\"",
+		"add-apt-repository -y ppa:wireguard/wireguard",
+		"curl -fsSL https://packages.cloud.google.com/apt/doc/apt-key.gpg | apt-key add -",
+		"echo 'deb [arch=amd64] https://apt.kubernetes.io/ kubernetes-xenial main' > /etc/apt/sources.list.d/kubernetes.list",
+		"apt-get update"}
+
+	return []sshconnect.Command{
+		&sshconnect.ShellCommand{
+			Host:        host,
+			CommandLine: strings.Join(addRepositories, " && "),
+			Description: "Add apt repositories of docker, wireguard and kubernetes",
+			Retry:       sshconnect.AptRetryPolicy},
+		&sshconnect.ShellCommand{
+			Host:        host,
+			CommandLine: "DEBIAN_FRONTEND=noninteractive apt-get install -y wireguard linux-headers-generic docker-ce=18.06.1~ce~3-0~ubuntu kubelet kubeadm kubectl",
+			Description: "Install wireguard, docker and kubernetes packages",
+			Retry:       sshconnect.AptRetryPolicy},
+		&sshconnect.CopyFileCommand{
+			Host:        host,
+			FileContent: strings.NewReader(dockerDaemonConfig),
+			FilePath:    "/etc/docker/daemon.json",
+			Description: "Copy docker daemon config"},
+		&sshconnect.ShellCommand{
+			Host:        host,
+			CommandLine: "systemctl restart docker",
+			Description: "Restart docker"},
+		&sshconnect.ShellCommand{
+			Host:        host,
+			CommandLine: "ufw allow 22/tcp && ufw allow 51820/udp && ufw --force enable",
+			Description: "Open firewall for SSH and wireguard"},
+		&sshconnect.ShellCommand{
+			Host:        host,
+			CommandLine: "swapoff -a && mkdir -p /etc/systemd/system/docker.service.d /etc/kubernetes/pki && apt-mark hold kubelet kubeadm kubectl docker-ce",
+			Description: "Disable swap and hold kubernetes packages"}}
+}
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"kthw/cmd/common"
 	"kthw/cmd/infra/sshkey"
+	"kthw/secrets"
+	"sort"
+	"strings"
 
 	viper "github.com/spf13/viper"
 )
 
 const (
-	confHCloudServersKey           = "hcloud.server"
 	confHCloudDefaultServerTypeKey = "hcloud.default.serverType"
 	confHCloudDefaultImageNameKey  = "hcloud.default.imageName"
 	confHCloudLocationNameKey      = "hcloud.default.locationName"
@@ -20,83 +22,256 @@ const (
 	HCloudImage = "ubuntu-18.04"
 	// HCloudLocation is the default location (like datacenter) where a added server is created at.
 	HCloudLocation = "nbg1"
+
+	// SSHAddressPublic connects to a server at its public IP. This is the default.
+	SSHAddressPublic = "public"
+	// SSHAddressPrivate connects to a server at its private IP in the overlay network.
+	SSHAddressPrivate = "private"
 )
 
 // Config from config file
 type Config struct {
-	ID             int
-	Name           string
-	ServerType     string
-	ImageName      string
-	LocationName   string
-	PublicIP       string
-	PrivateIP      string
-	RootPassword   string
-	Roles          []string
-	SSHPublicKeyID int
-}
-
-// UpdateConfig updates the configuration with the current field values. Changes are not persisted.
-func (sc *Config) UpdateConfig() {
-	viper.Set(sc.confServerNameKey(), sc.Name)
-	viper.Set(sc.confServerTypeKey(), sc.ServerType)
-	viper.Set(sc.confLocationNameKey(), sc.LocationName)
-	viper.Set(sc.confImageNameKey(), sc.ImageName)
-	viper.Set(sc.confSSKPublicKeyID(), sc.SSHPublicKeyID)
-	viper.Set(sc.confRoles(), sc.Roles)
+	ID              int
+	Name            string
+	ServerType      string
+	ImageName       string
+	LocationName    string
+	PublicIP        string
+	PrivateIP       string
+	RootPassword    string
+	Roles           []string
+	SSHPublicKeyID  int
+	CompletedPhases []string
+	// SSHAddress is SSHAddressPublic, SSHAddressPrivate or any other address the
+	// server is connected to at. Empty means SSHAddressPublic.
+	SSHAddress string
+	// ProxyJump lists the jump hosts the server is connected through in the format
+	// of OpenSSH's ProxyJump. Names of servers are replaced by their public IP.
+	// Empty uses ssh.proxyJump of the project.
+	ProxyJump string
+	// Placement is the datacenter the server is created in. See Options.
+	Placement string
+	// Labels are set on the server at hcloud in addition to its roles.
+	Labels map[string]string
+
+	// source is the config the server was read from. Changes are set in it.
+	// nil means the global viper instance.
+	source *viper.Viper
+}
+
+// config returns the config changes of the server are set in.
+func (sc *Config) config() *viper.Viper {
+	return orGlobal(sc.source)
+}
+
+func orGlobal(config *viper.Viper) *viper.Viper {
+	if config == nil {
+		return viper.GetViper()
+	}
+	return config
+}
+
+// Entry is a server as written to the config file at hcloud.server.<name>.
+// Labels are key=value. The root password may be sealed. Config is read from
+// Entry, so that the format of the config file is decoded in one place.
+type Entry struct {
+	Name            string   `mapstructure:"name"`
+	ID              int      `mapstructure:"id"`
+	ServerType      string   `mapstructure:"serverType"`
+	ImageName       string   `mapstructure:"imageName"`
+	LocationName    string   `mapstructure:"locationName"`
+	PublicKeyID     int      `mapstructure:"publicKeyId"`
+	PublicIP        string   `mapstructure:"publicIP"`
+	PrivateIP       string   `mapstructure:"privateIP"`
+	RootPassword    string   `mapstructure:"rootPassword"`
+	Roles           []string `mapstructure:"roles"`
+	CompletedPhases []string `mapstructure:"completedPhases"`
+	SSHAddress      string   `mapstructure:"sshAddress"`
+	ProxyJump       string   `mapstructure:"proxyJump"`
+	Placement       string   `mapstructure:"placement"`
+	Labels          []string `mapstructure:"labels"`
+}
+
+// readEntries decodes the entries of all servers in config by their name in
+// lower case. All settings are decoded, because viper.UnmarshalKey misses keys
+// of the config file once keys of the same server are set.
+func readEntries(config *viper.Viper) (map[string]Entry, error) {
+	var file struct {
+		HCloud struct {
+			Server map[string]Entry `mapstructure:"server"`
+		} `mapstructure:"hcloud"`
+	}
+	err := config.Unmarshal(&file)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid servers in config: %w", err)
+	}
+	return file.HCloud.Server, nil
+}
+
+// SSHHost returns the address commands are run on the server at. Servers
+// connected to at their private IP are connected to at their public IP until
+// the overlay network is set up.
+func (sc *Config) SSHHost() string {
+	switch sc.SSHAddress {
+	case "", SSHAddressPublic:
+		return sc.PublicIP
+	case SSHAddressPrivate:
+		if sc.PrivateIP == "" {
+			return sc.PublicIP
+		}
+		return sc.PrivateIP
+	}
+	return sc.SSHAddress
+}
+
+// UpdateConfig updates the configuration with the current field values. The root
+// password is sealed if a passphrase or key file is configured. Changes are not persisted.
+func (sc *Config) UpdateConfig() error {
+	sc.config().Set(sc.confServerNameKey(), sc.Name)
+	sc.config().Set(sc.confServerTypeKey(), sc.ServerType)
+	sc.config().Set(sc.confLocationNameKey(), sc.LocationName)
+	sc.config().Set(sc.confImageNameKey(), sc.ImageName)
+	sc.config().Set(sc.confSSKPublicKeyID(), sc.SSHPublicKeyID)
+	sc.config().Set(sc.confRoles(), sc.Roles)
+
+	if sc.CompletedPhases == nil {
+		sc.config().Set(sc.confCompletedPhasesKey(), []string{})
+	} else {
+		sc.config().Set(sc.confCompletedPhasesKey(), sc.CompletedPhases)
+	}
 
 	if sc.ID != 0 {
-		viper.Set(sc.confIDKey(), sc.ID)
+		sc.config().Set(sc.confIDKey(), sc.ID)
 	}
 
 	if sc.PublicIP != "" {
-		viper.Set(sc.confPublicIPKey(), sc.PublicIP)
+		sc.config().Set(sc.confPublicIPKey(), sc.PublicIP)
 	}
 
 	if sc.PrivateIP != "" {
-		viper.Set(sc.confPrivateIPKey(), sc.PrivateIP)
+		sc.config().Set(sc.confPrivateIPKey(), sc.PrivateIP)
+	}
+
+	if sc.SSHAddress != "" {
+		sc.config().Set(sc.confSSHAddressKey(), sc.SSHAddress)
+	}
+
+	if sc.ProxyJump != "" {
+		sc.config().Set(sc.confProxyJumpKey(), sc.ProxyJump)
+	}
+
+	if sc.Placement != "" {
+		sc.config().Set(sc.confPlacementKey(), sc.Placement)
+	}
+
+	if len(sc.Labels) > 0 {
+		sc.config().Set(sc.confLabelsKey(), FormatLabels(sc.Labels))
 	}
 
 	if sc.RootPassword != "" {
-		viper.Set(sc.confRootPasswordKey(), sc.RootPassword)
+		err := secrets.SetSealedIn(sc.config(), sc.confRootPasswordKey(), sc.RootPassword)
+		if err != nil {
+			return fmt.Errorf("Error while storing root password of server '%s': %w", sc.Name, err)
+		}
 	}
+	return nil
 }
 
+// ClearProvisionedState removes ID, IPs, root password and completed phases of a server, which
+// was deleted at hcloud, from fields and configuration. Changes are not persisted.
+func (sc *Config) ClearProvisionedState() {
+	sc.ID = 0
+	sc.PublicIP = ""
+	sc.PrivateIP = ""
+	sc.RootPassword = ""
+	sc.CompletedPhases = []string{}
+
+	sc.config().Set(sc.confCompletedPhasesKey(), sc.CompletedPhases)
+	sc.config().Set(sc.confIDKey(), sc.ID)
+	sc.config().Set(sc.confPublicIPKey(), sc.PublicIP)
+	sc.config().Set(sc.confPrivateIPKey(), sc.PrivateIP)
+	sc.config().Set(sc.confRootPasswordKey(), sc.RootPassword)
+}
+
+// IsProvisioned checks if a server is already created in hcloud.
+func (sc *Config) IsProvisioned() bool { return sc.ID != 0 }
+
 // ReadFromConfig reads the config of a server from the configuration file.
-// Name field of Config must be set.
+// Name field of Config must be set. Returns an error of class common.ErrNotFound
+// if there is no server with this name in config.
 func (sc *Config) ReadFromConfig() error {
+	return sc.readFromConfig(true)
+}
+
+// ReadFromConfigWithoutSecrets reads the config of a server like ReadFromConfig,
+// but leaves the root password empty. Sealed secrets aren't opened, so no
+// passphrase or key file is required.
+func (sc *Config) ReadFromConfigWithoutSecrets() error {
+	return sc.readFromConfig(false)
+}
+
+func (sc *Config) readFromConfig(withSecrets bool) error {
 	if sc.Name == "" {
 		return fmt.Errorf("Could not read server from config. Server name not set")
 	}
-	// TODO verify server name exists in config and return error if not
+	entries, err := readEntries(sc.config())
+	if err != nil {
+		return err
+	}
+	entry, ok := entries[strings.ToLower(sc.Name)]
+	if !ok {
+		return common.Classify(common.ErrNotFound, fmt.Errorf("Server '%s' not found in config", sc.Name))
+	}
+	return sc.readFromEntry(entry, withSecrets)
+}
 
-	publicIP := viper.GetString(sc.confPublicIPKey())
-	if publicIP != "" {
-		sc.PublicIP = publicIP
+// readFromEntry sets the fields of the server from entry. IPs, ID and root
+// password are kept if entry has none.
+func (sc *Config) readFromEntry(entry Entry, withSecrets bool) error {
+	if entry.PublicIP != "" {
+		sc.PublicIP = entry.PublicIP
 	}
-	privateIP := viper.GetString(sc.confPrivateIPKey())
-	if privateIP != "" {
-		sc.PrivateIP = privateIP
+	if entry.PrivateIP != "" {
+		sc.PrivateIP = entry.PrivateIP
 	}
 
-	rootPassword := viper.GetString(sc.confRootPasswordKey())
-	if rootPassword != "" {
-		sc.RootPassword = rootPassword
+	if withSecrets {
+		rootPassword, err := secrets.OpenSealedIn(sc.config(), sc.confRootPasswordKey(), entry.RootPassword)
+		if err != nil {
+			return err
+		}
+		if rootPassword != "" {
+			sc.RootPassword = rootPassword
+		}
 	}
 
-	id := viper.GetInt(sc.confIDKey())
-	if id != 0 {
-		sc.ID = id
+	if entry.ID != 0 {
+		sc.ID = entry.ID
 	}
 
-	sc.SSHPublicKeyID = viper.GetInt(sc.confSSKPublicKeyID())
-	sc.ServerType = viper.GetString(sc.confServerTypeKey())
-	sc.ImageName = viper.GetString(sc.confImageNameKey())
-	sc.LocationName = viper.GetString(sc.confLocationNameKey())
-	sc.Roles = viper.GetStringSlice(sc.confRoles())
+	sc.SSHPublicKeyID = entry.PublicKeyID
+	sc.ServerType = entry.ServerType
+	sc.ImageName = entry.ImageName
+	sc.LocationName = entry.LocationName
+	sc.Roles = entry.Roles
+	sc.CompletedPhases = entry.CompletedPhases
+	sc.SSHAddress = entry.SSHAddress
+	sc.ProxyJump = entry.ProxyJump
+	sc.Placement = entry.Placement
+	labels, err := ParseLabels(entry.Labels)
+	if err != nil {
+		return fmt.Errorf("Invalid labels of server '%s': %w", sc.Name, err)
+	}
+	if len(labels) > 0 {
+		sc.Labels = labels
+	}
 	return nil
 }
 
+func (sc *Config) confServerKey() string {
+	return fmt.Sprintf("hcloud.server.%s", sc.Name)
+}
+
 func (sc *Config) confSSKPublicKeyID() string {
 	return fmt.Sprintf("hcloud.server.%s.publicKeyId", sc.Name)
 }
@@ -133,29 +308,86 @@ func (sc *Config) confRoles() string {
 	return fmt.Sprintf("hcloud.server.%s.roles", sc.Name)
 }
 
+func (sc *Config) confCompletedPhasesKey() string {
+	return fmt.Sprintf("hcloud.server.%s.completedPhases", sc.Name)
+}
+
 func (sc *Config) confIDKey() string {
 	return fmt.Sprintf("hcloud.server.%s.id", sc.Name)
 }
 
+func (sc *Config) confSSHAddressKey() string {
+	return fmt.Sprintf("hcloud.server.%s.sshAddress", sc.Name)
+}
+
+func (sc *Config) confProxyJumpKey() string {
+	return fmt.Sprintf("hcloud.server.%s.proxyJump", sc.Name)
+}
+
+func (sc *Config) confPlacementKey() string {
+	return fmt.Sprintf("hcloud.server.%s.placement", sc.Name)
+}
+
+func (sc *Config) confLabelsKey() string {
+	return fmt.Sprintf("hcloud.server.%s.labels", sc.Name)
+}
+
 // FromConfig reads settings of a specific server from config.
-func FromConfig(serverName string) Config {
+func FromConfig(serverName string) (Config, error) {
 	serverConfig := Config{Name: serverName}
 	err := serverConfig.ReadFromConfig()
-	common.WhenErrPrintAndExit(err)
-	return serverConfig
+	return serverConfig, err
+}
+
+// FromConfigWithoutSecrets reads settings of a specific server from config
+// without opening its root password. See ReadFromConfigWithoutSecrets.
+func FromConfigWithoutSecrets(serverName string) (Config, error) {
+	serverConfig := Config{Name: serverName}
+	err := serverConfig.ReadFromConfigWithoutSecrets()
+	return serverConfig, err
 }
 
-// AllFromConfig reads Config of all servers from configuration.
+// AllFromConfig reads Config of all servers from configuration. Servers are
+// sorted by name, so that the order is the same every time.
 func AllFromConfig() ([]*Config, error) {
-	serverNames := viper.GetStringMapString(confHCloudServersKey)
-	if len(serverNames) < 1 {
-		return nil, fmt.Errorf("no servers fond in config")
+	return allFromConfig(nil, true)
+}
+
+// AllFromConfigIn reads Config of all servers from config like AllFromConfig.
+// Changes of the servers are set in config.
+func AllFromConfigIn(config *viper.Viper) ([]*Config, error) {
+	return allFromConfig(config, true)
+}
+
+// AllFromConfigWithoutSecrets reads Config of all servers like AllFromConfig
+// without opening their root passwords. See ReadFromConfigWithoutSecrets.
+func AllFromConfigWithoutSecrets() ([]*Config, error) {
+	return allFromConfig(nil, false)
+}
+
+func allFromConfig(source *viper.Viper, withSecrets bool) ([]*Config, error) {
+	entries, err := readEntries(orGlobal(source))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) < 1 {
+		return nil, common.Classify(common.ErrNotFound, fmt.Errorf("no servers fond in config"))
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
 	}
+	sort.Strings(names)
 
 	serverConfigs := make([]*Config, 0)
-	for name := range serverNames {
-		current := FromConfig(name)
-		serverConfigs = append(serverConfigs, &current)
+	for _, name := range names {
+		current := &Config{Name: name, source: source}
+		err := current.readFromEntry(entries[name], withSecrets)
+		if err != nil {
+			return nil, err
+		}
+		serverConfigs = append(serverConfigs, current)
 	}
 	return serverConfigs, nil
 }
@@ -168,7 +400,12 @@ func SetHCloudServerDefaults() {
 }
 
 // AddServer uses the first argument as server name and adds this server to the configuration.
-func AddServer(serverName string, roles []string) error {
+// Empty fields of options use the defaults of the project.
+func AddServer(serverName string, roles []string, options Options) error {
+	err := ensureNotInConfig(serverName)
+	if err != nil {
+		return err
+	}
 	sshKey, err := sshkey.ReadSSHPublicKeyFromConf()
 	if err != nil {
 		return err
@@ -177,14 +414,101 @@ func AddServer(serverName string, roles []string) error {
 		return fmt.Errorf(
 			"Could not add SSH key to server '%s'. The SSH key '%s' is not available in hcloud. Use the provision command first", serverName, sshKey.Name)
 	}
+	serverConf := NewConfig(serverName, roles, options)
+	serverConf.SSHPublicKeyID = sshKey.ID
+	return serverConf.UpdateConfig()
+}
+
+// NewConfig returns the config of a new server using options or, where
+// options are empty, the defaults of the project. It isn't added to config.
+func NewConfig(serverName string, roles []string, options Options) Config {
+	defaults := ReadDefaults()
 	serverConf := Config{
-		Name:           serverName,
-		SSHPublicKeyID: sshKey.ID,
-		ServerType:     viper.GetString(confHCloudDefaultServerTypeKey),
-		ImageName:      viper.GetString(confHCloudDefaultImageNameKey),
-		LocationName:   viper.GetString(confHCloudLocationNameKey),
-		Roles:          roles}
-	serverConf.UpdateConfig()
+		Name:         serverName,
+		ServerType:   options.ServerType,
+		ImageName:    options.ImageName,
+		LocationName: options.LocationName,
+		Placement:    options.Placement,
+		Labels:       options.Labels,
+		Roles:        roles}
+	if serverConf.ServerType == "" {
+		serverConf.ServerType = defaults.ServerType
+	}
+	if serverConf.ImageName == "" {
+		serverConf.ImageName = defaults.ImageName
+	}
+	if serverConf.LocationName == "" {
+		serverConf.LocationName = defaults.LocationName
+	}
+	return serverConf
+}
+
+// AddStaticServer adds an existing host reachable at publicIP to the configuration.
+func AddStaticServer(serverName string, roles []string, publicIP string) error {
+	if publicIP == "" {
+		return fmt.Errorf("Could not add server '%s'. A public IP is required", serverName)
+	}
+	err := ensureNotInConfig(serverName)
+	if err != nil {
+		return err
+	}
+	serverConf := Config{
+		Name:     serverName,
+		PublicIP: publicIP,
+		Roles:    roles}
+	return serverConf.UpdateConfig()
+}
+
+// SetRoles validates roles and sets them as roles of the server. Roles must not
+// be listed twice. Changes are not persisted.
+func (sc *Config) SetRoles(roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("Server '%s' requires at least one role", sc.Name)
+	}
+	for i, role := range roles {
+		err := IsValidRole(role)
+		if err != nil {
+			return err
+		}
+		if common.ArrayContains(roles[:i], role) {
+			return fmt.Errorf("Role '%s' is listed twice", role)
+		}
+	}
+	sc.Roles = roles
+	return sc.UpdateConfig()
+}
+
+// RemoveFromConfig returns a copy of config without the server with
+// serverName, which is written to the same config file. config is left
+// unchanged, because viper can't unset keys. Returns an error of class
+// common.ErrNotFound if there is no such server. Changes are not persisted.
+func RemoveFromConfig(config *viper.Viper, serverName string) (*viper.Viper, error) {
+	serverConf := Config{Name: serverName}
+	if !config.IsSet(serverConf.confServerKey()) {
+		return nil, common.Classify(common.ErrNotFound, fmt.Errorf("Server '%s' not found in config", serverName))
+	}
+
+	settings := config.AllSettings()
+	hcloud, _ := settings["hcloud"].(map[string]interface{})
+	servers, _ := hcloud["server"].(map[string]interface{})
+	delete(servers, strings.ToLower(serverName))
+
+	removed := viper.New()
+	removed.SetConfigFile(config.ConfigFileUsed())
+	err := removed.MergeConfigMap(settings)
+	if err != nil {
+		return nil, fmt.Errorf("Error while removing server '%s' from config: %w", serverName, err)
+	}
+	return removed, nil
+}
+
+// ensureNotInConfig returns an error if there is a server with serverName in
+// config already. Names are compared case-insensitively like all config keys.
+func ensureNotInConfig(serverName string) error {
+	serverConf := Config{Name: serverName}
+	if viper.IsSet(serverConf.confServerKey()) {
+		return fmt.Errorf("Could not add server '%s'. There is a server with this name already", serverName)
+	}
 	return nil
 }
 
@@ -215,3 +539,12 @@ func SelectHostsInRole(hostConfigs []*Config, role string) []*Config {
 	}
 	return hostsInRole
 }
+
+// SSHHosts returns the addresses commands are run on hostConfigs at in the same order.
+func SSHHosts(hostConfigs []*Config) []string {
+	sshHosts := make([]string, len(hostConfigs))
+	for i, host := range hostConfigs {
+		sshHosts[i] = host.SSHHost()
+	}
+	return sshHosts
+}
@@ -1,8 +1,13 @@
 package server_test
 
 import (
+	"errors"
+	"kthw/cmd/common"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/infra/sshkey"
+	"kthw/secrets"
+	"os"
+	"strings"
 	"testing"
 
 	viper "github.com/spf13/viper"
@@ -18,7 +23,7 @@ func TestAddServer(t *testing.T) {
 	key := sshkey.ASSHPublicKeyWithID
 	setupConfig(key)
 	initialRoles := []string{"etcd", "worker"}
-	err := server.AddServer("controller-1", initialRoles)
+	err := server.AddServer("controller-1", initialRoles, server.Options{})
 	if err != nil {
 		t.Fatalf("Enexpected error while adding server to conf: %s", err)
 	}
@@ -59,7 +64,7 @@ func TestAddServerFailIfSSHKeyNotProvisioned(t *testing.T) {
 	key.ID = 0
 	setupConfig(key)
 	roles := []string{"etc", "worker"}
-	err := server.AddServer("controller-1", roles)
+	err := server.AddServer("controller-1", roles, server.Options{})
 	if err == nil {
 		t.Errorf("Added a server while the SSH key was not created at hcloud. This shouldn't be possible.")
 	}
@@ -75,6 +80,15 @@ func TestReadServiceConfigFailIfServerNameNotSet(t *testing.T) {
 	}
 }
 
+func TestReadServerConfigFailIfServerNotInConfig(t *testing.T) {
+	viper.Reset()
+
+	_, err := server.FromConfig("unknown")
+	if !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("Expected error of class ErrNotFound for unknown server, but got '%v'", err)
+	}
+}
+
 func TestReadInitialConfig(t *testing.T) {
 	viper.Reset()
 
@@ -191,3 +205,194 @@ func ensureValidRole(t *testing.T, validRole string) {
 		t.Errorf("Got an error for valid role '%s'", validRole)
 	}
 }
+
+func TestRootPasswordIsSealed(t *testing.T) {
+	viper.Reset()
+	os.Setenv(secrets.PassphraseEnv, "passphrase")
+	defer os.Unsetenv(secrets.PassphraseEnv)
+
+	serverConfig := server.Config{Name: "controller-1", ID: 42, RootPassword: "secret"}
+	serverConfig.UpdateConfig()
+
+	sealed := viper.GetString("hcloud.server.controller-1.rootPassword")
+	if !secrets.IsSealed([]byte(sealed)) {
+		t.Fatalf("Root password stored in plain text: '%s'", sealed)
+	}
+
+	fromConfig, err := server.FromConfig("controller-1")
+	if err != nil {
+		t.Fatalf("Error while reading server from config: %s", err)
+	}
+	if fromConfig.RootPassword != "secret" {
+		t.Errorf("RootPassword was '%s' and differs from expected 'secret'", fromConfig.RootPassword)
+	}
+}
+
+func TestSSHHost(t *testing.T) {
+	viper.Reset()
+	serverConfig := server.Config{Name: "worker-1", PublicIP: "1.2.3.4", SSHAddress: server.SSHAddressPrivate, ProxyJump: "controller-1"}
+	if serverConfig.SSHHost() != "1.2.3.4" {
+		t.Errorf("Expected public IP before overlay network is set up, but was '%s'", serverConfig.SSHHost())
+	}
+
+	serverConfig.PrivateIP = "10.0.0.2"
+	serverConfig.UpdateConfig()
+	fromConfig, err := server.FromConfig("worker-1")
+	if err != nil {
+		t.Fatalf("Error while reading server from config: %s", err)
+	}
+	if fromConfig.SSHHost() != "10.0.0.2" || fromConfig.ProxyJump != "controller-1" {
+		t.Errorf("Expected private IP and proxy jump from config, but was '%s' and '%s'", fromConfig.SSHHost(), fromConfig.ProxyJump)
+	}
+
+	fromConfig.SSHAddress = "192.168.0.2"
+	if fromConfig.SSHHost() != "192.168.0.2" {
+		t.Errorf("Expected explicit address, but was '%s'", fromConfig.SSHHost())
+	}
+}
+
+func TestAddServerFailIfServerExists(t *testing.T) {
+	viper.Reset()
+	setupConfig(sshkey.ASSHPublicKeyWithID)
+	err := server.AddServer("controller-1", []string{"controller"}, server.Options{})
+	if err != nil {
+		t.Fatalf("Enexpected error while adding server to conf: %s", err)
+	}
+
+	err = server.AddServer("Controller-1", []string{"worker"}, server.Options{})
+	if err == nil {
+		t.Errorf("Added a server with the name of an existing server")
+	}
+	roles := viper.GetStringSlice("hcloud.server.controller-1.roles")
+	if len(roles) != 1 || roles[0] != "controller" {
+		t.Errorf("Expected roles of existing server to be unchanged, but got %v", roles)
+	}
+}
+
+func TestRemoveFromConfig(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`hcloud:
+  server:
+    controller-1:
+      name: controller-1
+      roles: [controller]
+    worker-1:
+      name: worker-1
+      roles: [worker]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := server.RemoveFromConfig(viper.GetViper(), "worker-1")
+	if err != nil {
+		t.Fatalf("Unexpected error while removing server: %s", err)
+	}
+	configs, err := server.AllFromConfigIn(removed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 1 || configs[0].Name != "controller-1" {
+		t.Errorf("Expected only controller-1 to be left in config, but got %d servers", len(configs))
+	}
+	if !viper.IsSet("hcloud.server.worker-1") || viper.GetString("hcloud.server.controller-1.name") != "controller-1" {
+		t.Errorf("Expected the config removed from to be unchanged")
+	}
+
+	_, err = server.RemoveFromConfig(removed, "worker-1")
+	if !errors.Is(err, common.ErrNotFound) {
+		t.Errorf("Expected error of class ErrNotFound for removed server, but got '%v'", err)
+	}
+}
+
+func TestSetRoles(t *testing.T) {
+	viper.Reset()
+	serverConfig := server.Config{Name: "controller-1", Roles: []string{"controller"}}
+
+	err := serverConfig.SetRoles([]string{"controller", "master"})
+	if err == nil {
+		t.Errorf("Expected error because 'master' is not a valid role")
+	}
+	err = serverConfig.SetRoles([]string{})
+	if err == nil {
+		t.Errorf("Expected error because a server requires a role")
+	}
+	err = serverConfig.SetRoles([]string{"worker", "worker"})
+	if err == nil {
+		t.Errorf("Expected error because role 'worker' is listed twice")
+	}
+
+	err = serverConfig.SetRoles([]string{"etcd", "worker"})
+	if err != nil {
+		t.Fatalf("Unexpected error while setting roles: %s", err)
+	}
+	roles := viper.GetStringSlice("hcloud.server.controller-1.roles")
+	if len(roles) != 2 || roles[0] != "etcd" || roles[1] != "worker" {
+		t.Errorf("Expected roles etcd and worker in config, but got %v", roles)
+	}
+}
+
+func TestFromConfigWithoutSecretsDoesNotOpenRootPassword(t *testing.T) {
+	viper.Reset()
+	viper.Set("secrets.keyFile", "/nonexistent/kthw.key")
+	viper.Set("hcloud.server.controller-1.roles", []string{"controller"})
+	viper.Set("hcloud.server.controller-1.rootPassword", "sealed:key:c2VjcmV0")
+
+	_, err := server.FromConfig("controller-1")
+	if err == nil {
+		t.Errorf("Expected error, because the key file to open the root password is missing")
+	}
+
+	serverConfigs, err := server.AllFromConfigWithoutSecrets()
+	if err != nil {
+		t.Fatalf("Unexpected error while reading servers without secrets: %s", err)
+	}
+	if len(serverConfigs) != 1 || serverConfigs[0].RootPassword != "" || serverConfigs[0].Roles[0] != "controller" {
+		t.Errorf("Expected server without root password, but got %+v", serverConfigs)
+	}
+}
+
+func TestReadServerWithKeysFromFileAndSet(t *testing.T) {
+	viper.Reset()
+	viper.SetConfigType("yaml")
+	err := viper.ReadConfig(strings.NewReader(`
+hcloud:
+  server:
+    worker-1:
+      name: worker-1
+      id: "42"
+      serverType: cx31
+      publicIP: 192.168.1.2
+      roles: [worker]
+      labels: [team=infra]
+    worker-2:
+      name: worker-2
+      roles: [worker]
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Set("hcloud.server.worker-1.privateIP", "10.0.0.2")
+
+	serverConfigs, err := server.AllFromConfig()
+	if err != nil {
+		t.Fatalf("Unexpected error while reading servers: %s", err)
+	}
+	if len(serverConfigs) != 2 {
+		t.Fatalf("Expected 2 servers, but got %d", len(serverConfigs))
+	}
+	worker := serverConfigs[0]
+	if worker.PrivateIP != "10.0.0.2" || worker.PublicIP != "192.168.1.2" || worker.ServerType != "cx31" || worker.ID != 42 {
+		t.Errorf("Expected keys set and keys of the config file, but got %+v", worker)
+	}
+	if worker.Labels["team"] != "infra" {
+		t.Errorf("Expected label team=infra, but got %v", worker.Labels)
+	}
+
+	viper.Set("hcloud.server.worker-1.roles", "worker")
+	_, err = server.FromConfig("worker-1")
+	if err != nil {
+		t.Errorf("Expected single role to be read as list, but got '%s'", err)
+	}
+}
@@ -1,12 +1,14 @@
 package server
 
 import (
+	"fmt"
 	"kthw/cmd/hcloudclient"
 	"kthw/cmd/infra/sshkey"
 	"kthw/cmd/sshconnect"
 	"strings"
 
 	"github.com/hetznercloud/hcloud-go/hcloud"
+	"golang.org/x/crypto/ssh"
 )
 
 var basicCloudInit = `#cloud-config
@@ -57,10 +59,31 @@ runcmd:
   - [ apt-mark, hold, kubelet, kubeadm, kubectl, docker-ce ]
 `
 
+// hostKeysCloudInit makes cloud-init install a host key generated locally
+// instead of generating one, so that the key is known before the first
+// connection. No other host keys are generated.
+func hostKeysCloudInit(privateKeyPEM string, publicKey ssh.PublicKey) string {
+	indentedPrivateKey := "    " + strings.Replace(strings.TrimSpace(privateKeyPEM), "\n", "\n    ", -1)
+	return fmt.Sprintf(`ssh_deletekeys: true
+ssh_genkeytypes: []
+ssh_keys:
+  ecdsa_private: |
+%s
+  ecdsa_public: %s
+`, indentedPrivateKey, strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))))
+}
+
 // Create creates a server in hcloud using the provided config. Public ip and
 // root password are added to the conf and calling code is assumed to write the configuration.
-func Create(config *Config, client hcloudclient.HCloudOperations) error {
-	sshKeyFromConf, err := sshkey.ReadSSHPublicKeyFromConf()
+// The host key of the server is generated locally, installed by cloud-init and
+// recorded in knownHosts.
+func Create(config *Config, client hcloudclient.HCloudOperations, knownHosts *sshconnect.KnownHosts) error {
+	sshKeyFromConf, err := sshkey.ReadSSHPublicKeyIn(config.config())
+	if err != nil {
+		return err
+	}
+
+	hostPrivateKey, hostPublicKey, err := sshconnect.GenerateHostKey()
 	if err != nil {
 		return err
 	}
@@ -71,25 +94,40 @@ func Create(config *Config, client hcloudclient.HCloudOperations) error {
 	sshKey := &hcloud.SSHKey{ID: sshKeyFromConf.ID}
 	startAfterCreate := true
 	labels := make(map[string]string)
-	labels["roles"] = strings.Join(config.Roles, ",")
+	for key, value := range config.Labels {
+		labels[key] = value
+	}
+	labels[rolesLabel] = strings.Join(config.Roles, ",")
 	serverOpts := hcloud.ServerCreateOpts{
 		Name:             config.Name,
 		ServerType:       serverType,
 		Image:            image,
 		Location:         location,
-		UserData:         basicCloudInit,
+		UserData:         basicCloudInit + hostKeysCloudInit(hostPrivateKey, hostPublicKey),
 		SSHKeys:          []*hcloud.SSHKey{sshKey},
 		StartAfterCreate: &startAfterCreate,
 		Labels:           labels}
+	if config.Placement != "" {
+		// hcloud accepts either a location or a datacenter, which implies the location.
+		serverOpts.Location = nil
+		serverOpts.Datacenter = &hcloud.Datacenter{Name: config.Placement}
+	}
 
-	serverCreated := client.Create(serverOpts)
+	serverCreated, err := client.Create(serverOpts)
+	if err != nil {
+		return err
+	}
 
 	config.PublicIP = serverCreated.PublicIP
 	config.RootPassword = serverCreated.RootPassword
 	config.SSHPublicKeyID = sshKey.ID
 	config.ID = serverCreated.ID
 
-	return nil
+	err = knownHosts.Remove(config.PublicIP)
+	if err != nil {
+		return err
+	}
+	return knownHosts.Add(config.PublicIP, hostPublicKey)
 }
 
 // IsCloudInitCompleted tests if cloud-init already completed and returns 'true' if is did and otherwise 'false'
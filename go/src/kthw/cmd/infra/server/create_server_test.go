@@ -1,9 +1,13 @@
 package server_test
 
 import (
+	"io/ioutil"
 	"kthw/cmd/hcloudclient"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/infra/sshkey"
+	"kthw/cmd/sshconnect"
+	"path"
+	"strings"
 	"testing"
 
 	viper "github.com/spf13/viper"
@@ -24,12 +28,21 @@ func setupTestCreateServer() (*hcloudclient.CreateServerResults, *hcloudclient.M
 	return createServerResult, hcloudClient, config
 }
 
+func helperTempKnownHosts(t *testing.T) *sshconnect.KnownHosts {
+	tempDirName, err := ioutil.TempDir("", "CreateServer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshconnect.NewKnownHosts(path.Join(tempDirName, "known_hosts"))
+}
+
 func TestCreateServer(t *testing.T) {
 	viper.Reset()
 	sshKey := sshkey.ASSHPublicKeyWithIDInConfig()
 	createServerResult, hcloudClient, serverConfig := setupTestCreateServer()
+	knownHosts := helperTempKnownHosts(t)
 
-	err := server.Create(&serverConfig, hcloudClient)
+	err := server.Create(&serverConfig, hcloudClient, knownHosts)
 	if err != nil {
 		t.Errorf("Error while creating server: %s", err)
 	}
@@ -50,8 +63,50 @@ func TestCreateServerWhenThereIsNoSSHPublicKeyInConfig(t *testing.T) {
 	viper.Reset()
 	_, hcloudClient, serverConfig := setupTestCreateServer()
 
-	err := server.Create(&serverConfig, hcloudClient)
+	err := server.Create(&serverConfig, hcloudClient, helperTempKnownHosts(t))
 	if err == nil {
 		t.Errorf("A error should be returned as there is no SSH public key in config")
 	}
 }
+
+func TestCreateServerRecordsHostKey(t *testing.T) {
+	viper.Reset()
+	sshkey.ASSHPublicKeyWithIDInConfig()
+	createServerResult, hcloudClient, serverConfig := setupTestCreateServer()
+	knownHosts := helperTempKnownHosts(t)
+
+	err := server.Create(&serverConfig, hcloudClient, knownHosts)
+	if err != nil {
+		t.Fatalf("Error while creating server: %s", err)
+	}
+
+	userData := hcloudClient.CreatedServerOpts[0].UserData
+	if !strings.Contains(userData, "ssh_keys:") || !strings.Contains(userData, "ecdsa_private: |") {
+		t.Errorf("Expected host key in cloud-init user data, but was:\n%s", userData)
+	}
+	if algorithms := knownHosts.HostKeyAlgorithms(createServerResult.PublicIP); len(algorithms) != 1 || algorithms[0] != "ecdsa-sha2-nistp256" {
+		t.Errorf("Expected ecdsa host key of %s in known hosts, but found %v", createServerResult.PublicIP, algorithms)
+	}
+}
+
+func TestCreateServerWithPlacementAndLabels(t *testing.T) {
+	viper.Reset()
+	sshkey.ASSHPublicKeyWithIDInConfig()
+	_, hcloudClient, serverConfig := setupTestCreateServer()
+	serverConfig.Roles = []string{"worker"}
+	serverConfig.Placement = "nbg1-dc3"
+	serverConfig.Labels = map[string]string{"team": "infra"}
+
+	err := server.Create(&serverConfig, hcloudClient, helperTempKnownHosts(t))
+	if err != nil {
+		t.Fatalf("Error while creating server: %s", err)
+	}
+
+	opts := hcloudClient.CreatedServerOpts[0]
+	if opts.Datacenter == nil || opts.Datacenter.Name != "nbg1-dc3" || opts.Location != nil {
+		t.Errorf("Expected server to be created in datacenter nbg1-dc3 without location")
+	}
+	if opts.Labels["team"] != "infra" || opts.Labels["roles"] != "worker" {
+		t.Errorf("Expected labels team and roles, but got %v", opts.Labels)
+	}
+}
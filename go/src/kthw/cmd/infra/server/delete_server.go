@@ -0,0 +1,22 @@
+package server
+
+import (
+	"fmt"
+	"kthw/cmd/hcloudclient"
+)
+
+// Delete deletes a server in hcloud and clears ID, IPs and root password from
+// config. Calling code is assumed to write the configuration.
+func Delete(config *Config, client hcloudclient.HCloudOperations) error {
+	if !config.IsProvisioned() {
+		return fmt.Errorf("Server '%s' was not created at hcloud. There is nothing to delete", config.Name)
+	}
+
+	err := client.DeleteServer(config.ID)
+	if err != nil {
+		return err
+	}
+
+	config.ClearProvisionedState()
+	return nil
+}
@@ -0,0 +1,47 @@
+package server_test
+
+import (
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/server"
+	"testing"
+
+	viper "github.com/spf13/viper"
+)
+
+func TestDeleteServer(t *testing.T) {
+	viper.Reset()
+	hcloudClient := &hcloudclient.MockHCloudOperations{}
+	serverConfig := server.Config{
+		ID:           42,
+		Name:         "m1",
+		PublicIP:     "192.168.1.1",
+		PrivateIP:    "10.0.0.1",
+		RootPassword: "secret"}
+	serverConfig.UpdateConfig()
+
+	err := server.Delete(&serverConfig, hcloudClient)
+	if err != nil {
+		t.Fatalf("Error while deleting server: %s", err)
+	}
+
+	if len(hcloudClient.DeletedServerIDs) != 1 || hcloudClient.DeletedServerIDs[0] != 42 {
+		t.Errorf("Expected server '42' to be deleted, but deleted servers were %v", hcloudClient.DeletedServerIDs)
+	}
+
+	fromConfig := server.Config{Name: "m1"}
+	fromConfig.ReadFromConfig()
+	if fromConfig.ID != 0 || fromConfig.PublicIP != "" || fromConfig.PrivateIP != "" || fromConfig.RootPassword != "" {
+		t.Errorf("Expected ID, IPs and root password to be removed from config, but found %+v", fromConfig)
+	}
+}
+
+func TestDeleteServerFailIfNotProvisioned(t *testing.T) {
+	viper.Reset()
+	hcloudClient := &hcloudclient.MockHCloudOperations{}
+	serverConfig := server.Config{Name: "m1"}
+
+	err := server.Delete(&serverConfig, hcloudClient)
+	if err == nil {
+		t.Errorf("Expected an error when deleting a server which was not created.")
+	}
+}
@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"kthw/cmd/hcloudclient"
+	"regexp"
+	"sort"
+	"strings"
+
+	viper "github.com/spf13/viper"
+)
+
+// rolesLabel is set by Create on every server. It can't be set by users.
+const rolesLabel = "roles"
+
+var (
+	labelKeyPattern   = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?/)?[a-zA-Z0-9]([a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?$`)
+	labelValuePattern = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9._-]{0,61}[a-zA-Z0-9])?)?$`)
+)
+
+// Options of a server added to a project. Empty fields use the defaults of the
+// project.
+type Options struct {
+	ServerType   string
+	ImageName    string
+	LocationName string
+	// Placement is a datacenter of the location, e.g. fsn1-dc14, the server is
+	// created in. Empty lets hcloud pick the datacenter.
+	Placement string
+	// Labels are set on the server at hcloud in addition to its roles.
+	Labels map[string]string
+}
+
+// ReadDefaults reads server type, image and location used to add servers from config.
+func ReadDefaults() Options {
+	return Options{
+		ServerType:   viper.GetString(confHCloudDefaultServerTypeKey),
+		ImageName:    viper.GetString(confHCloudDefaultImageNameKey),
+		LocationName: viper.GetString(confHCloudLocationNameKey)}
+}
+
+// SetDefaults sets server type, image and location used to add servers. Empty
+// fields keep their value. Changes are not persisted.
+func SetDefaults(defaults Options) {
+	setIfNotEmpty(confHCloudDefaultServerTypeKey, defaults.ServerType)
+	setIfNotEmpty(confHCloudDefaultImageNameKey, defaults.ImageName)
+	setIfNotEmpty(confHCloudLocationNameKey, defaults.LocationName)
+}
+
+func setIfNotEmpty(key string, value string) {
+	if value != "" {
+		viper.Set(key, value)
+	}
+}
+
+// ParseLabels parses labels in the format key=value. Keys and values must be
+// valid hcloud labels. The label roles is reserved.
+func ParseLabels(labels []string) (map[string]string, error) {
+	parsed := make(map[string]string)
+	for _, label := range labels {
+		label = strings.TrimSpace(label)
+		if label == "" {
+			continue
+		}
+		keyAndValue := strings.SplitN(label, "=", 2)
+		if len(keyAndValue) != 2 {
+			return nil, fmt.Errorf("Label '%s' is not in the format key=value", label)
+		}
+		key, value := keyAndValue[0], keyAndValue[1]
+		if !labelKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("'%s' is not a valid label key", key)
+		}
+		if !labelValuePattern.MatchString(value) {
+			return nil, fmt.Errorf("'%s' is not a valid value of label '%s'", value, key)
+		}
+		if key == rolesLabel {
+			return nil, fmt.Errorf("Label '%s' is reserved for the roles of a server", rolesLabel)
+		}
+		parsed[key] = value
+	}
+	return parsed, nil
+}
+
+// FormatLabels formats labels as key=value, sorted by key. Labels are stored
+// in config in this format, because viper changes map keys to lower case.
+func FormatLabels(labels map[string]string) []string {
+	list := make([]string, 0, len(labels))
+	for key, value := range labels {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// ValidateAtHCloud returns an error if server type, image, location or
+// placement of the server don't exist at hcloud or the placement is not in the
+// location of the server.
+func (sc *Config) ValidateAtHCloud(client hcloudclient.HCloudOperations) error {
+	var problems []string
+	checks := []struct {
+		kind   string
+		name   string
+		exists func(name string) (bool, error)
+	}{
+		{"server type", sc.ServerType, client.ServerTypeExists},
+		{"image", sc.ImageName, client.ImageExists},
+		{"location", sc.LocationName, client.LocationExists}}
+	for _, check := range checks {
+		if check.name == "" {
+			continue
+		}
+		exists, err := check.exists(check.name)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			problems = append(problems, fmt.Sprintf("%s '%s' doesn't exist", check.kind, check.name))
+		}
+	}
+
+	if sc.Placement != "" {
+		location, err := client.DatacenterLocation(sc.Placement)
+		if err != nil {
+			return err
+		}
+		switch {
+		case location == "":
+			problems = append(problems, fmt.Sprintf("datacenter '%s' doesn't exist", sc.Placement))
+		case sc.LocationName != "" && location != sc.LocationName:
+			problems = append(problems, fmt.Sprintf("datacenter '%s' is in location '%s', not in '%s'", sc.Placement, location, sc.LocationName))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("Invalid options at hcloud: %s", strings.Join(problems, ", "))
+	}
+	return nil
+}
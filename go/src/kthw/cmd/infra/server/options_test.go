@@ -0,0 +1,94 @@
+package server_test
+
+import (
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/infra/sshkey"
+	"strings"
+	"testing"
+
+	viper "github.com/spf13/viper"
+)
+
+func TestParseLabels(t *testing.T) {
+	labels, err := server.ParseLabels([]string{"team=Infra", "example.com/tier=frontend", "empty="})
+	if err != nil {
+		t.Fatalf("Unexpected error while parsing labels: %s", err)
+	}
+	if labels["team"] != "Infra" || labels["example.com/tier"] != "frontend" || labels["empty"] != "" {
+		t.Errorf("Unexpected labels %v", labels)
+	}
+
+	for _, invalid := range []string{"team", "-team=infra", "team=in fra", "roles=worker"} {
+		_, err = server.ParseLabels([]string{invalid})
+		if err == nil {
+			t.Errorf("Expected error for invalid label '%s'", invalid)
+		}
+	}
+}
+
+func TestAddServerWithOptions(t *testing.T) {
+	viper.Reset()
+	setupConfig(sshkey.ASSHPublicKeyWithID)
+	options := server.Options{
+		ServerType: "cx31",
+		Placement:  "nbg1-dc3",
+		Labels:     map[string]string{"Team": "infra"}}
+
+	err := server.AddServer("worker-1", []string{"worker"}, options)
+	if err != nil {
+		t.Fatalf("Unexpected error while adding server to conf: %s", err)
+	}
+
+	serverConfig, err := server.FromConfig("worker-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverConfig.ServerType != "cx31" {
+		t.Errorf("Expected server type cx31 from options, but got '%s'", serverConfig.ServerType)
+	}
+	if serverConfig.LocationName != server.HCloudLocation {
+		t.Errorf("Expected default location '%s', but got '%s'", server.HCloudLocation, serverConfig.LocationName)
+	}
+	if serverConfig.Placement != "nbg1-dc3" {
+		t.Errorf("Expected placement nbg1-dc3, but got '%s'", serverConfig.Placement)
+	}
+	if serverConfig.Labels["Team"] != "infra" {
+		t.Errorf("Expected label Team=infra in original case, but got %v", serverConfig.Labels)
+	}
+}
+
+func TestSetDefaultsKeepsEmptyFields(t *testing.T) {
+	viper.Reset()
+	server.SetHCloudServerDefaults()
+
+	server.SetDefaults(server.Options{LocationName: "fsn1"})
+	defaults := server.ReadDefaults()
+	if defaults.LocationName != "fsn1" || defaults.ServerType != server.HCloudServerType || defaults.ImageName != server.HCloudImage {
+		t.Errorf("Unexpected defaults %+v", defaults)
+	}
+}
+
+func TestValidateAtHCloud(t *testing.T) {
+	client := &hcloudclient.MockHCloudOperations{
+		ServerTypes: []string{"cx21"},
+		Images:      []string{"ubuntu-18.04"},
+		Locations:   []string{"nbg1", "fsn1"},
+		Datacenters: map[string]string{"nbg1-dc3": "nbg1", "fsn1-dc14": "fsn1"}}
+	config := server.Config{Name: "m1", ServerType: "cx21", ImageName: "ubuntu-18.04", LocationName: "nbg1", Placement: "nbg1-dc3"}
+
+	err := config.ValidateAtHCloud(client)
+	if err != nil {
+		t.Errorf("Unexpected error for valid options: %s", err)
+	}
+
+	config.ServerType = "cx99"
+	config.Placement = "fsn1-dc14"
+	err = config.ValidateAtHCloud(client)
+	if err == nil {
+		t.Fatalf("Expected error for unknown server type and placement in other location")
+	}
+	if !strings.Contains(err.Error(), "server type 'cx99' doesn't exist") || !strings.Contains(err.Error(), "is in location 'fsn1'") {
+		t.Errorf("Expected error to list all problems, but got '%s'", err)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phases of installing a cluster. Each server records the phases it completed.
+const (
+	PhaseServer     = "server"
+	PhaseCloudInit  = "cloudInit"
+	PhaseWireguard  = "wireguard"
+	PhaseEtcd       = "etcd"
+	PhaseController = "controller"
+	PhaseWorker     = "worker"
+)
+
+var allPhases = []string{PhaseServer, PhaseCloudInit, PhaseWireguard, PhaseEtcd, PhaseController, PhaseWorker}
+
+// AllPhases returns all install phases in the order they are executed.
+func AllPhases() []string {
+	return allPhases
+}
+
+// IsValidPhase returns an error if phase is not a valid install phase.
+func IsValidPhase(phase string) error {
+	for _, validPhase := range allPhases {
+		if phase == validPhase {
+			return nil
+		}
+	}
+	return fmt.Errorf("'%s' is not a valid phase. Valid phases are: %s", phase, strings.Join(allPhases, ", "))
+}
+
+// IsPhaseCompleted returns 'true' if the server completed phase. A server which
+// has an ID completed PhaseServer, even if it wasn't recorded.
+func (sc *Config) IsPhaseCompleted(phase string) bool {
+	if phase == PhaseServer && sc.IsProvisioned() {
+		return true
+	}
+	for _, completed := range sc.CompletedPhases {
+		if completed == phase {
+			return true
+		}
+	}
+	return false
+}
+
+// CompletePhase records that the server completed phase. Changes are not persisted.
+func (sc *Config) CompletePhase(phase string) error {
+	sc.CompletedPhases = appendIfMissing(sc.CompletedPhases, phase)
+	return sc.UpdateConfig()
+}
+
+// ResetPhasesFrom removes phase and all following phases from the completed
+// phases of the server. Changes are not persisted.
+func (sc *Config) ResetPhasesFrom(phase string) error {
+	err := IsValidPhase(phase)
+	if err != nil {
+		return err
+	}
+
+	var reset bool
+	var remaining []string
+	for _, p := range allPhases {
+		if p == phase {
+			reset = true
+		}
+		if !reset && sc.IsPhaseCompleted(p) {
+			remaining = append(remaining, p)
+		}
+	}
+	sc.CompletedPhases = remaining
+	return sc.UpdateConfig()
+}
+
+func appendIfMissing(phases []string, phase string) []string {
+	for _, p := range phases {
+		if p == phase {
+			return phases
+		}
+	}
+	return append(phases, phase)
+}
@@ -0,0 +1,60 @@
+package server_test
+
+import (
+	"kthw/cmd/infra/server"
+	"testing"
+
+	viper "github.com/spf13/viper"
+)
+
+func TestCompletePhase(t *testing.T) {
+	viper.Reset()
+	serverConfig := server.Config{Name: "m1"}
+
+	serverConfig.CompletePhase(server.PhaseCloudInit)
+	serverConfig.CompletePhase(server.PhaseCloudInit)
+
+	fromConfig := server.Config{Name: "m1"}
+	fromConfig.ReadFromConfig()
+	if !fromConfig.IsPhaseCompleted(server.PhaseCloudInit) {
+		t.Errorf("Expected phase '%s' to be completed, but it wasn't", server.PhaseCloudInit)
+	}
+	if len(fromConfig.CompletedPhases) != 1 {
+		t.Errorf("Expected one completed phase, but found %v", fromConfig.CompletedPhases)
+	}
+	if fromConfig.IsPhaseCompleted(server.PhaseWireguard) {
+		t.Errorf("Phase '%s' was never completed", server.PhaseWireguard)
+	}
+}
+
+func TestPhaseServerIsCompletedIfServerHasID(t *testing.T) {
+	serverConfig := server.Config{Name: "m1", ID: 42}
+
+	if !serverConfig.IsPhaseCompleted(server.PhaseServer) {
+		t.Errorf("Server with ID is expected to have completed phase '%s'", server.PhaseServer)
+	}
+}
+
+func TestResetPhasesFrom(t *testing.T) {
+	viper.Reset()
+	serverConfig := server.Config{Name: "m1"}
+	serverConfig.CompletePhase(server.PhaseCloudInit)
+	serverConfig.CompletePhase(server.PhaseWireguard)
+	serverConfig.CompletePhase(server.PhaseEtcd)
+
+	err := serverConfig.ResetPhasesFrom(server.PhaseWireguard)
+	if err != nil {
+		t.Fatalf("Unexpected error while resetting phases: %s", err)
+	}
+
+	if !serverConfig.IsPhaseCompleted(server.PhaseCloudInit) {
+		t.Errorf("Phase '%s' is before the reset phase and should still be completed", server.PhaseCloudInit)
+	}
+	if serverConfig.IsPhaseCompleted(server.PhaseWireguard) || serverConfig.IsPhaseCompleted(server.PhaseEtcd) {
+		t.Errorf("Expected phases from '%s' on to be reset, but found %v", server.PhaseWireguard, serverConfig.CompletedPhases)
+	}
+
+	if serverConfig.ResetPhasesFrom("unknown") == nil {
+		t.Errorf("Expected an error when resetting from an unknown phase")
+	}
+}
@@ -7,11 +7,14 @@ import (
 )
 
 // CreateSSHKey creates a SSH key in hcloud
-func CreateSSHKey(key SSHPublicKey, hcloudClient hcloudclient.HCloudOperations) *SSHPublicKey {
+func CreateSSHKey(key SSHPublicKey, hcloudClient hcloudclient.HCloudOperations) (*SSHPublicKey, error) {
 	opts := hcloud.SSHKeyCreateOpts{
 		Name:      key.Name,
 		PublicKey: key.PublicKey}
-	result := hcloudClient.CreateSSHKey(opts)
+	result, err := hcloudClient.CreateSSHKey(opts)
+	if err != nil {
+		return nil, err
+	}
 	key.ID = result.ID
-	return &key
+	return &key, nil
 }
@@ -13,7 +13,10 @@ func TestProvisionSSHKeys(t *testing.T) {
 		CreateSSHKeyResults: createSSHKeyResult}
 
 	key := sshkey.SSHPublicKey{PublicKey: "key", Name: "name"}
-	updatedKey := sshkey.CreateSSHKey(key, hcloudClient)
+	updatedKey, err := sshkey.CreateSSHKey(key, hcloudClient)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
 
 	key.ID = 12
 
@@ -0,0 +1,19 @@
+package sshkey
+
+import (
+	"kthw/cmd/hcloudclient"
+)
+
+// DeleteSSHKey deletes a SSH key in hcloud and returns the key without ID.
+func DeleteSSHKey(key SSHPublicKey, hcloudClient hcloudclient.HCloudOperations) (*SSHPublicKey, error) {
+	if !key.IsProvisioned() {
+		return &key, nil
+	}
+
+	err := hcloudClient.DeleteSSHKey(key.ID)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = 0
+	return &key, nil
+}
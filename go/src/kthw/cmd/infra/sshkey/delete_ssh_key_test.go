@@ -0,0 +1,25 @@
+package sshkey_test
+
+import (
+	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/sshkey"
+	"testing"
+)
+
+func TestDeleteSSHKey(t *testing.T) {
+	hcloudClient := &hcloudclient.MockHCloudOperations{}
+
+	key := sshkey.SSHPublicKey{ID: 12, PublicKey: "key", Name: "name"}
+	updatedKey, err := sshkey.DeleteSSHKey(key, hcloudClient)
+	if err != nil {
+		t.Fatalf("Error while deleting SSH key: %s", err)
+	}
+
+	if updatedKey.IsProvisioned() {
+		t.Errorf("Deleted key still has ID '%d'", updatedKey.ID)
+	}
+
+	if len(hcloudClient.DeletedSSHKeyIDs) != 1 || hcloudClient.DeletedSSHKeyIDs[0] != 12 {
+		t.Errorf("Expected SSH key '12' to be deleted, but deleted keys were %v", hcloudClient.DeletedSSHKeyIDs)
+	}
+}
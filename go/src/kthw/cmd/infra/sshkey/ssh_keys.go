@@ -15,9 +15,14 @@ type SSHPublicKey struct {
 
 // WriteToConfig writes the state of a key to config without writing the config to disk
 func (s *SSHPublicKey) WriteToConfig() {
-	viper.Set(confSSHKeysPublicKeyKey, s.PublicKey)
-	viper.Set(confSSHKeysNameKey, s.Name)
-	viper.Set(confSSHKeysIDKey, s.ID)
+	s.WriteToConfigIn(viper.GetViper())
+}
+
+// WriteToConfigIn writes the state of a key to config like WriteToConfig.
+func (s *SSHPublicKey) WriteToConfigIn(config *viper.Viper) {
+	config.Set(confSSHKeysPublicKeyKey, s.PublicKey)
+	config.Set(confSSHKeysNameKey, s.Name)
+	config.Set(confSSHKeysIDKey, s.ID)
 }
 
 // IsProvisioned checks if a SSH key is already created in hcloud.
@@ -31,13 +36,18 @@ const (
 
 // ReadSSHPublicKeyFromConf reads public ssh key from config and returns error if non is set
 func ReadSSHPublicKeyFromConf() (*SSHPublicKey, error) {
-	if !viper.IsSet(confSSHKeysNameKey) || !viper.IsSet(confSSHKeysPublicKeyKey) {
+	return ReadSSHPublicKeyIn(viper.GetViper())
+}
+
+// ReadSSHPublicKeyIn reads public ssh key from config like ReadSSHPublicKeyFromConf.
+func ReadSSHPublicKeyIn(config *viper.Viper) (*SSHPublicKey, error) {
+	if !config.IsSet(confSSHKeysNameKey) || !config.IsSet(confSSHKeysPublicKeyKey) {
 		return nil, fmt.Errorf("No ssh keys defined to conf. Add one first")
 	}
 
 	key := &SSHPublicKey{
-		ID:        viper.GetInt(confSSHKeysIDKey),
-		Name:      viper.GetString(confSSHKeysNameKey),
-		PublicKey: viper.GetString(confSSHKeysPublicKeyKey)}
+		ID:        config.GetInt(confSSHKeysIDKey),
+		Name:      config.GetString(confSSHKeysNameKey),
+		PublicKey: config.GetString(confSSHKeysPublicKeyKey)}
 	return key, nil
 }
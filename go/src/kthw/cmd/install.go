@@ -1,49 +1,46 @@
 package cmd
 
 import (
-	"kthw/certs"
 	"kthw/cmd/common"
 	"kthw/cmd/infra/server"
-	"kthw/cmd/sshconnect"
-	"sync"
+	"strings"
 
 	"github.com/spf13/cobra"
 )
 
+// FromStep is the phase from which on all phases run again, although servers completed them before.
+var FromStep string
+
 var installCommand = &cobra.Command{
-	Use:   "install",
-	Short: "Install infrastructure and kubernetes cluster with one command"}
+	Use:         "install",
+	Short:       "Install infrastructure and kubernetes cluster with one command",
+	Annotations: supportsDryRun}
 
 var kubernetesCluster = &cobra.Command{
 	Use:   "k8s-non-ha",
 	Short: "Install a non HA cluster",
-	Run: func(cmd *cobra.Command, args []string) {
-		sshClient := sshconnect.NewSSHConnect(Verbose)
-		certGenerator, err := certs.LoadCertGenerator()
-		common.WhenErrPrintAndExit(err)
-		certLoader := certs.NewDefaultCertificateLoader()
-
-		serverConfigs, _ := server.AllFromConfig()
-		for _, conf := range serverConfigs {
-			createServerAndUpdateConfig(conf)
+	Long: "Phases completed by a server are recorded in the config. Running install again " +
+		"resumes with the first phase not completed. Use --from-step to run phases again.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if FromStep != "" {
+			return server.IsValidPhase(FromStep)
 		}
-
-		var waitGroup sync.WaitGroup
-
-		for _, conf := range serverConfigs {
-			waitGroup.Add(1)
-			go waitForCloudInitCompleted(&waitGroup, conf, sshClient)
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		project := openProject()
+		if FromStep != "" {
+			err := project.ResetPhasesFrom(FromStep)
+			common.WhenErrPrintAndExit(err)
 		}
 
-		waitGroup.Wait()
-
-		setupWireguardAndUpdateConfig(serverConfigs, sshClient)
-		installEtcd(serverConfigs, sshClient, certGenerator)
-		installKubernetesController(serverConfigs, sshClient, certLoader, certGenerator)
+		err := project.Install(interruptibleContext())
+		common.WhenErrPrintAndExit(err)
 	}}
 
 func installCommands() *cobra.Command {
 	installCommand.PersistentFlags().StringVarP(&APIToken, "apiToken", "a", "", "API token for access to hcloud (required)")
+	kubernetesCluster.Flags().StringVar(&FromStep, "from-step", "", "Run this and all following phases again. Phases are "+strings.Join(server.AllPhases(), ", "))
 	installCommand.AddCommand(kubernetesCluster)
 	return installCommand
 }
@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kthw/cmd/common"
+	"kthw/cmd/sshconnect"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var logsCommand = &cobra.Command{
+	Use:   "logs [run-dir]",
+	Short: "Shows the last failed step of a run",
+	Long: "Every run writes an event log and the output of all commands to a directory in logs beside the config file. " +
+		"Without run-dir, the latest run is shown.",
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var runDir string
+		var err error
+		if len(args) == 1 {
+			runDir = args[0]
+		} else {
+			runDir, err = sshconnect.LatestRunDir(sshconnect.DefaultRunLogsDir())
+			common.WhenErrPrintAndExit(err)
+		}
+
+		events, err := sshconnect.ReadEvents(runDir)
+		common.WhenErrPrintAndExit(err)
+
+		failed := sshconnect.LastFailed(events)
+		if failed == nil {
+			fmt.Printf("No failed step in run %s. %d commands succeeded.\n", runDir, len(events))
+			return
+		}
+
+		fmt.Printf("Run:         %s\n", runDir)
+		fmt.Printf("Host:        %s\n", failed.Host)
+		fmt.Printf("Step:        %s\n", failed.Description)
+		fmt.Printf("Command:     %s\n", failed.Command)
+		fmt.Printf("Attempt:     %d\n", failed.Attempt)
+		fmt.Printf("Exit status: %d\n", failed.ExitStatus)
+		fmt.Printf("Duration:    %s\n", time.Duration(failed.DurationMs)*time.Millisecond)
+		fmt.Printf("Error:       %s\n", failed.Error)
+		printLogFile("stdout", filepath.Join(runDir, failed.StdoutFile))
+		printLogFile("stderr", filepath.Join(runDir, failed.StderrFile))
+	}}
+
+func printLogFile(name string, file string) {
+	content, err := ioutil.ReadFile(file)
+	common.WhenErrPrintAndExit(err)
+	fmt.Printf("\n--- %s ---\n%s", name, content)
+	if len(content) > 0 && content[len(content)-1] != '\n' {
+		fmt.Println()
+	}
+}
@@ -3,10 +3,14 @@ package cmd
 import (
 	"fmt"
 	"kthw/certs"
+	"kthw/cmd/cluster/kube"
 	"kthw/cmd/common"
 	"kthw/cmd/hcloudclient"
+	"kthw/cmd/infra/provider"
 	"kthw/cmd/infra/server"
 	"kthw/cmd/infra/sshkey"
+	"kthw/cmd/projectconfig"
+	"kthw/secrets"
 	"os"
 	"strings"
 
@@ -23,12 +27,38 @@ const (
 
 var projectCommand = &cobra.Command{Use: "project", Short: "Create and manage configuration of a project"}
 
+// ProviderName is the provider used to create servers of a new project.
+var ProviderName string
+
+// PublicIP of an existing host added to a project using the static provider.
+var PublicIP string
+
+// ServerType, ImageName, LocationName, Placement and Labels of a server added
+// to a project using hcloud. Type, image and location override the defaults.
+var (
+	ServerType   string
+	ImageName    string
+	LocationName string
+	Placement    string
+	Labels       []string
+)
+
+// hcloudServerFlags are the flags of add-server only supported by the hcloud provider.
+var hcloudServerFlags = []string{"type", "image", "location", "placement", "labels"}
+
 var newProjectCommand = &cobra.Command{
 	Use:   "new <name> <ssh-public-key-file>",
 	Short: "Creates a config file of a new project and sets up everything required to provision K8s clusters.",
-	Args:  cobra.ExactArgs(2),
+	Long: "Settings of the CA in the certs section of an existing project.yaml, e.g. keyAlgo, keySize, names and expiries, " +
+		"are kept. Missing settings get their default value.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("Expected exactly two arguments, but found '%d'", len(args))
+		}
+		return provider.IsValidProvider(ProviderName)
+	},
 	Run: func(cmd *cobra.Command, args []string) {
-		if APIToken == "" {
+		if ProviderName == provider.HCloud && APIToken == "" {
 			fmt.Println("ApiToken not found. Make sure you set the --apiToken flag")
 			os.Exit(1)
 		}
@@ -36,21 +66,30 @@ var newProjectCommand = &cobra.Command{
 		viper.SetConfigFile(defaultConfigFile)
 		projectName := args[0]
 		sshPublicKeyFilePath := args[1]
+		projectconfig.SetVersion(viper.GetViper())
 		viper.Set(ConfProjectNameKey, projectName)
+		provider.SetProviderName(ProviderName)
 		server.SetHCloudServerDefaults()
+		certsConf := certs.InitDefaultConfig()
+		common.WhenErrPrintAndExit(certsConf.Validate())
 		fmt.Println("Initialised project.yaml with defaults.")
 
 		sshPublicKey, err := sshkey.AddSSHPublicKeyToConfig(projectName, sshPublicKeyFilePath)
 		common.WhenErrPrintAndExit(err)
 		fmt.Println("Added SSH key to config.")
 
-		hcloudClient := hcloudclient.NewHCloudClient(APIToken)
-		updatedConfig := sshkey.CreateSSHKey(*sshPublicKey, hcloudClient)
-		updatedConfig.WriteToConfig()
-		fmt.Println("SSH key created in hcloud.")
+		if ProviderName == provider.HCloud {
+			hcloudClient, err := hcloudclient.NewHCloudClient(APIToken)
+			common.WhenErrPrintAndExit(err)
+			updatedConfig, err := sshkey.CreateSSHKey(*sshPublicKey, hcloudClient)
+			common.WhenErrPrintAndExit(err)
+			updatedConfig.WriteToConfig()
+			fmt.Println("SSH key created in hcloud.")
+		}
 
-		certsConf := certs.InitDefaultConfig()
-		caCerts := certs.DefaultCACerts(certsConf.BaseDir)
+		caCerts := certs.DefaultCACerts(certsConf)
+		caCerts.Sealer, err = secrets.ReadSealer()
+		common.WhenErrPrintAndExit(err)
 		err = caCerts.InitCa()
 		if err != nil {
 			fmt.Printf("Error while initiation CA: %s\n", err)
@@ -66,26 +105,42 @@ var newProjectCommand = &cobra.Command{
 var addServerCommand = &cobra.Command{
 	Use:   "add-server <name> <roles>",
 	Short: "Adds a new server to the config file.",
-	Long:  "Pick a random name for this server. Valid roles are controller, worker and etcd.",
+	Long: "Pick a random name for this server. Valid roles are controller, worker and etcd. " +
+		"Projects using the static provider require the --publicIP of an existing host. " +
+		"Type, image, location and placement are checked at hcloud if --apiToken is set and always before the server is created.",
 	Args: func(cmd *cobra.Command, args []string) error {
 		if len(args) != 2 {
 			return fmt.Errorf("Expected exactly two arguments, but found '%d'", len(args))
 		}
-
-		for _, role := range strings.Split(args[1], ",") {
-			if server.IsValidRole(role) != nil {
-				validRoles := strings.Join(server.AllValidRoles(), ", ")
-				return fmt.Errorf("'%s' is not a valid role. Valid roles are: %s", role, validRoles)
-			}
-		}
-
-		return nil
+		return validateRoles(args[1])
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		serverName := args[0]
 		roles := strings.Split(args[1], ",")
 
-		err := server.AddServer(serverName, roles)
+		var err error
+		if provider.ReadProviderName() == provider.Static {
+			for _, flag := range hcloudServerFlags {
+				if cmd.Flags().Changed(flag) {
+					fmt.Printf("--%s is only supported by projects using the hcloud provider\n", flag)
+					os.Exit(1)
+				}
+			}
+			err = server.AddStaticServer(serverName, roles, PublicIP)
+		} else {
+			var labels map[string]string
+			labels, err = server.ParseLabels(Labels)
+			common.WhenErrPrintAndExit(err)
+			options := server.Options{
+				ServerType:   ServerType,
+				ImageName:    ImageName,
+				LocationName: LocationName,
+				Placement:    Placement,
+				Labels:       labels}
+			serverConfig := server.NewConfig(serverName, roles, options)
+			validateAtHCloud(&serverConfig)
+			err = server.AddServer(serverName, roles, options)
+		}
 		common.WhenErrPrintAndExit(err)
 
 		err = viper.WriteConfig()
@@ -94,8 +149,210 @@ var addServerCommand = &cobra.Command{
 		fmt.Printf("Server %s successfully added to config.\n", serverName)
 	}}
 
+var setDefaultsCommand = &cobra.Command{
+	Use:   "set-defaults",
+	Short: "Sets the server type, image and location used to add servers.",
+	Long:  "Only the given settings are changed. They are checked at hcloud if --apiToken is set.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if provider.ReadProviderName() != provider.HCloud {
+			fmt.Println("Defaults are only used by projects using the hcloud provider")
+			os.Exit(1)
+		}
+		defaults := server.ReadDefaults()
+		if cmd.Flags().Changed("type") {
+			defaults.ServerType = ServerType
+		}
+		if cmd.Flags().Changed("image") {
+			defaults.ImageName = ImageName
+		}
+		if cmd.Flags().Changed("location") {
+			defaults.LocationName = LocationName
+		}
+		validateAtHCloud(&server.Config{
+			ServerType:   defaults.ServerType,
+			ImageName:    defaults.ImageName,
+			LocationName: defaults.LocationName})
+		server.SetDefaults(defaults)
+
+		err := viper.WriteConfig()
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("Servers are added with type %s, image %s and location %s.\n",
+			defaults.ServerType, defaults.ImageName, defaults.LocationName)
+	}}
+
+// validateAtHCloud exits if type, image, location or placement of serverConfig
+// don't exist at hcloud. Without API token, they are checked before the server
+// is created.
+func validateAtHCloud(serverConfig *server.Config) {
+	if APIToken == "" {
+		fmt.Println("No --apiToken set. Server options are checked at hcloud before servers are created.")
+		return
+	}
+	hcloudClient, err := hcloudclient.NewHCloudClient(APIToken)
+	common.WhenErrPrintAndExit(err)
+	err = serverConfig.ValidateAtHCloud(hcloudClient)
+	common.WhenErrPrintAndExit(err)
+}
+
+// validateRoles returns an error if roles, separated by commas, contains a role which is not valid.
+func validateRoles(roles string) error {
+	for _, role := range strings.Split(roles, ",") {
+		if server.IsValidRole(role) != nil {
+			validRoles := strings.Join(server.AllValidRoles(), ", ")
+			return fmt.Errorf("'%s' is not a valid role. Valid roles are: %s", role, validRoles)
+		}
+	}
+	return nil
+}
+
+var setControlPlaneEndpointCommand = &cobra.Command{
+	Use:   "set-control-plane-endpoint <host:port|haproxy>",
+	Short: "Sets the endpoint of a highly available control plane.",
+	Long: "A project with several controllers requires an endpoint. It is either a load balancer, " +
+		"which must forward TCP traffic to port 6443 of all controllers, or 'haproxy'. " +
+		"Load balancers are not created by kthw, e.g. create a Hetzner load balancer or a DNS name first. " +
+		"With 'haproxy', haproxy is installed on every node and balances requests to the controllers " +
+		"over the WireGuard network. " +
+		"A virtual IP shared by the controllers, e.g. with keepalived, is not supported.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		kube.SetControlPlaneEndpoint(args[0])
+
+		err := viper.WriteConfig()
+		common.WhenErrPrintAndExit(err)
+
+		fmt.Printf("Control plane endpoint set to %s.\n", args[0])
+	}}
+
+// KeyFile is the key file used to seal secrets of a project.
+var KeyFile string
+
+var sealCommand = &cobra.Command{
+	Use:   "seal",
+	Short: "Encrypts the CA private key and root passwords of all servers.",
+	Long: "Secrets are encrypted using the passphrase in environment variable " + secrets.PassphraseEnv +
+		" or a key file. Use --key-file to create a new key file and store its path in the config file. " +
+		"Keep the key file outside of the project directory.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if KeyFile != "" {
+			if !common.FileExists(KeyFile) {
+				err := secrets.GenerateKeyFile(KeyFile)
+				common.WhenErrPrintAndExit(err)
+				fmt.Printf("Generated key file %s.\n", KeyFile)
+			}
+			secrets.SetKeyFile(KeyFile)
+		}
+
+		sealer, err := secrets.ReadSealer()
+		common.WhenErrPrintAndExit(err)
+		if sealer == nil {
+			fmt.Printf("Neither %s nor a key file is set. Set one of both to seal secrets.\n", secrets.PassphraseEnv)
+			os.Exit(1)
+		}
+
+		caCerts := certs.DefaultCACerts(certs.ReadConfig())
+		caCerts.Sealer = sealer
+		err = caCerts.SealPrivateKey()
+		common.WhenErrPrintAndExit(err)
+		fmt.Println("CA private key encrypted.")
+
+		serverConfigs, err := server.AllFromConfig()
+		if err == nil {
+			for _, serverConfig := range serverConfigs {
+				err = serverConfig.UpdateConfig()
+				common.WhenErrPrintAndExit(err)
+			}
+		}
+
+		err = viper.WriteConfig()
+		common.WhenErrPrintAndExit(err)
+		fmt.Println("Secrets in config sealed.")
+	}}
+
+var trustHostCommand = &cobra.Command{
+	Use:   "trust-host <server-name>",
+	Short: "Accepts the current host key of a server.",
+	Long: "Replaces the host key recorded in known_hosts beside the config file by the key the server presents now. " +
+		"Only use it if the key changed for a known reason, e.g. because the server was re-installed.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		fingerprint, err := openProject().TrustHost(interruptibleContext(), args[0])
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("Host key %s of server %s trusted.\n", fingerprint, args[0])
+	}}
+
+// SSHAddress of a server set by set-ssh-route.
+var SSHAddress string
+
+// ProxyJump of a server set by set-ssh-route.
+var ProxyJump string
+
+var setSSHRouteCommand = &cobra.Command{
+	Use:   "set-ssh-route <server-name>",
+	Short: "Sets the address a server is connected to at and the jump hosts it is reached through.",
+	Long: "--address is public, private or any other address of the server. private uses the IP in the overlay network " +
+		"once it is set up. --proxy-jump is a comma separated list of jump hosts [user@]host[:port], like OpenSSH's ProxyJump. " +
+		"Names of servers are replaced by their public IP. 'none' connects directly, even if ssh.proxyJump is set for the project. " +
+		"Jump hosts only route SSH. The server still needs a public IP for the overlay network.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		serverConfig, err := server.FromConfig(args[0])
+		common.WhenErrPrintAndExit(err)
+		if cmd.Flags().Changed("address") {
+			serverConfig.SSHAddress = SSHAddress
+		}
+		if cmd.Flags().Changed("proxy-jump") {
+			serverConfig.ProxyJump = ProxyJump
+		}
+		err = serverConfig.UpdateConfig()
+		common.WhenErrPrintAndExit(err)
+
+		err = viper.WriteConfig()
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("Server %s is connected to at %s", serverConfig.Name, serverConfig.SSHHost())
+		if serverConfig.ProxyJump != "" {
+			fmt.Printf(" via %s", serverConfig.ProxyJump)
+		}
+		fmt.Println()
+	}}
+
+var validateCommand = &cobra.Command{
+	Use:   "validate",
+	Short: "Checks the config file for unknown keys, invalid values and inconsistent servers.",
+	Long:  "Prints all problems found. Exits with status 1 if there are any.",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if configReadErr != nil {
+			fmt.Printf("Error while reading %s: %s\n", viper.ConfigFileUsed(), configReadErr)
+			os.Exit(1)
+		}
+		_, err := projectconfig.Load(viper.GetViper())
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("%s is valid.\n", viper.ConfigFileUsed())
+	}}
+
 func projectCommands() *cobra.Command {
+	newProjectCommand.Flags().StringVar(&ProviderName, "provider", provider.HCloud, "Provider used to create servers. Either hcloud or static")
+	addServerCommand.Flags().StringVar(&PublicIP, "publicIP", "", "Public IP of an existing host (static provider only)")
+	for _, command := range []*cobra.Command{addServerCommand, setDefaultsCommand} {
+		command.Flags().StringVar(&ServerType, "type", "", "Server type, e.g. cx21")
+		command.Flags().StringVar(&ImageName, "image", "", "Image, e.g. ubuntu-18.04")
+		command.Flags().StringVar(&LocationName, "location", "", "Location, e.g. nbg1")
+	}
+	addServerCommand.Flags().StringVar(&Placement, "placement", "", "Datacenter of the location the server is created in, e.g. nbg1-dc3")
+	addServerCommand.Flags().StringSliceVar(&Labels, "labels", nil, "Labels key=value, separated by commas, set on the server at hcloud")
 	projectCommand.AddCommand(newProjectCommand)
 	projectCommand.AddCommand(addServerCommand)
+	projectCommand.AddCommand(setDefaultsCommand)
+	projectCommand.AddCommand(setControlPlaneEndpointCommand)
+	sealCommand.Flags().StringVar(&KeyFile, "key-file", "", "Key file used instead of a passphrase. Created if it doesn't exist")
+	projectCommand.AddCommand(sealCommand)
+	projectCommand.AddCommand(trustHostCommand)
+	setSSHRouteCommand.Flags().StringVar(&SSHAddress, "address", server.SSHAddressPublic, "Address the server is connected to at: public, private or an IP")
+	setSSHRouteCommand.Flags().StringVar(&ProxyJump, "proxy-jump", "", "Jump hosts the server is reached through")
+	projectCommand.AddCommand(setSSHRouteCommand)
+	projectCommand.AddCommand(validateCommand)
+	projectCommand.AddCommand(serversCommands())
 	return projectCommand
 }
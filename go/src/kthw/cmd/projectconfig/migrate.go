@@ -0,0 +1,97 @@
+package projectconfig
+
+import (
+	"fmt"
+	"kthw/cmd/cluster/kube"
+	"kthw/cmd/common"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// migration upgrades a config file by one version.
+type migration struct {
+	description string
+	migrate     func(config *viper.Viper) error
+}
+
+// migrations[i] upgrades config files from version i to version i+1. Append a
+// migration for every new version.
+var migrations = []migration{
+	{
+		// Files written before the format was versioned only differ by the
+		// missing version.
+		description: "record the version of the format",
+		migrate:     func(config *viper.Viper) error { return nil },
+	},
+	{
+		// Servers may have a placement and labels, which older versions of
+		// kthw reject. Existing servers have neither.
+		description: "add placement and labels of servers",
+		migrate:     func(config *viper.Viper) error { return nil },
+	},
+	{
+		// Several controllers without endpoint used to install haproxy on
+		// every node. The endpoint is required now.
+		description: "select haproxy as endpoint of control planes without endpoint",
+		migrate:     selectHAProxyEndpoint,
+	},
+}
+
+func selectHAProxyEndpoint(config *viper.Viper) error {
+	if config.GetString(confControlPlaneEndpointKey) != "" {
+		return nil
+	}
+	controllers := 0
+	for _, name := range serverKeys(config) {
+		roles := config.GetStringSlice(confServersKey + "." + name + ".roles")
+		if common.ArrayContains(roles, "controller") {
+			controllers++
+		}
+	}
+	if controllers > 1 {
+		config.Set(confControlPlaneEndpointKey, kube.LocalHAProxy)
+	}
+	return nil
+}
+
+// Migrate upgrades config to Version. Returns the version config had before.
+// Returns an error if config was written by a newer version of kthw. Changes
+// are not persisted.
+func Migrate(config *viper.Viper) (int, error) {
+	from := config.GetInt(confVersionKey)
+	if from > Version {
+		return from, fmt.Errorf("Config file has version %d, but this version of kthw supports versions up to %d. Update kthw", from, Version)
+	}
+	if from < 0 {
+		return from, fmt.Errorf("Config file has invalid version %d", from)
+	}
+
+	for version := from; version < Version; version++ {
+		err := migrations[version].migrate(config)
+		if err != nil {
+			return from, fmt.Errorf("Error while migrating config file to version %d (%s): %w",
+				version+1, migrations[version].description, err)
+		}
+		config.Set(confVersionKey, version+1)
+	}
+	return from, nil
+}
+
+// serverKeys returns the keys of all servers in config. They are taken from all
+// keys, as GetStringMap misses servers if a single key of a server was set.
+func serverKeys(config *viper.Viper) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, key := range config.AllKeys() {
+		if !strings.HasPrefix(key, confServersKey+".") {
+			continue
+		}
+		name := strings.SplitN(strings.TrimPrefix(key, confServersKey+"."), ".", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,49 @@
+package projectconfig_test
+
+import (
+	"kthw/cmd/projectconfig"
+	"strings"
+	"testing"
+)
+
+func TestMigrateUnversionedConfig(t *testing.T) {
+	config := helperReadConfig(t, strings.TrimPrefix(validConfig, "version: 1\n"))
+
+	from, err := projectconfig.Migrate(config)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating config: %s", err)
+	}
+	if from != 0 {
+		t.Errorf("Expected config without version to have version 0, but got %d", from)
+	}
+	if config.GetInt("version") != projectconfig.Version {
+		t.Errorf("Expected version %d after migration, but got %d", projectconfig.Version, config.GetInt("version"))
+	}
+	if config.GetString("hcloud.server.worker-1.publicIP") != "192.168.1.2" {
+		t.Errorf("Expected servers to be kept by migration")
+	}
+}
+
+func TestMigrateFailsForNewerVersion(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("version", projectconfig.Version+1)
+
+	_, err := projectconfig.Migrate(config)
+	if err == nil {
+		t.Errorf("Expected error, because config was written by a newer version")
+	}
+}
+
+func TestMigrateSelectsHAProxyForSeveralControllersWithoutEndpoint(t *testing.T) {
+	config := helperReadConfig(t, strings.Replace(validConfig, "controlPlaneEndpoint: lb.example.com:6443", "", 1))
+	config.Set("version", 2)
+	config.Set("hcloud.server.worker-1.roles", []string{"controller", "worker"})
+
+	_, err := projectconfig.Migrate(config)
+	if err != nil {
+		t.Fatalf("Unexpected error while migrating config: %s", err)
+	}
+	if config.GetString("kubernetes.controlPlaneEndpoint") != "haproxy" {
+		t.Errorf("Expected haproxy as endpoint, but got '%s'", config.GetString("kubernetes.controlPlaneEndpoint"))
+	}
+}
@@ -0,0 +1,143 @@
+// Package projectconfig defines the format of project.yaml. Config files are
+// read strictly into a File, validated and migrated from older versions of the
+// format.
+package projectconfig
+
+import (
+	"fmt"
+	"kthw/cmd/infra/server"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	confVersionKey              = "version"
+	confServersKey              = "hcloud.server"
+	confControlPlaneEndpointKey = "kubernetes.controlPlaneEndpoint"
+
+	// Version of the format of config files written by this version of kthw.
+	// Increment it and add a migration whenever the format changes.
+	Version = 3
+)
+
+// File is the content of a config file. Keys are case-insensitive, because
+// viper writes them in lower case.
+type File struct {
+	// Version of the format. Files without version have version 0.
+	Version    int               `mapstructure:"version"`
+	Project    ProjectSection    `mapstructure:"project"`
+	Provider   ProviderSection   `mapstructure:"provider"`
+	SSHKeys    SSHKeysSection    `mapstructure:"sshKeys"`
+	HCloud     HCloudSection     `mapstructure:"hcloud"`
+	SSH        SSHSection        `mapstructure:"ssh"`
+	Kubernetes KubernetesSection `mapstructure:"kubernetes"`
+	Certs      CertsSection      `mapstructure:"certs"`
+	Secrets    SecretsSection    `mapstructure:"secrets"`
+}
+
+// ProjectSection names the project.
+type ProjectSection struct {
+	Name string `mapstructure:"name"`
+}
+
+// ProviderSection selects the provider creating servers. Empty means hcloud.
+type ProviderSection struct {
+	Name string `mapstructure:"name"`
+}
+
+// SSHKeysSection is the SSH key servers are created with at hcloud.
+type SSHKeysSection struct {
+	PublicKey string `mapstructure:"publicKey"`
+	Name      string `mapstructure:"name"`
+	ID        int    `mapstructure:"id"`
+}
+
+// HCloudSection holds the defaults of new servers and all servers of the
+// project by name.
+type HCloudSection struct {
+	Default ServerDefaults          `mapstructure:"default"`
+	Server  map[string]server.Entry `mapstructure:"server"`
+}
+
+// ServerDefaults are used to add servers.
+type ServerDefaults struct {
+	ServerType   string `mapstructure:"serverType"`
+	ImageName    string `mapstructure:"imageName"`
+	LocationName string `mapstructure:"locationName"`
+}
+
+// SSHSection controls how servers are connected to. See sshconnect.Settings.
+type SSHSection struct {
+	User           string `mapstructure:"user"`
+	Port           int    `mapstructure:"port"`
+	IdentityFile   string `mapstructure:"identityFile"`
+	Agent          bool   `mapstructure:"agent"`
+	Sudo           bool   `mapstructure:"sudo"`
+	ProxyJump      string `mapstructure:"proxyJump"`
+	MaxConcurrency int    `mapstructure:"maxConcurrency"`
+	FailFast       bool   `mapstructure:"failFast"`
+}
+
+// KubernetesSection configures the cluster. ControlPlaneEndpoint is host:port
+// of a load balancer or 'haproxy'. It is required with several controllers.
+type KubernetesSection struct {
+	ControlPlaneEndpoint string `mapstructure:"controlPlaneEndpoint"`
+}
+
+// CertsSection configures the CA and certificates. See certs.Config.
+type CertsSection struct {
+	BaseDir    string       `mapstructure:"baseDir"`
+	KeyAlgo    string       `mapstructure:"keyAlgo"`
+	KeySize    int          `mapstructure:"keySize"`
+	CAExpiry   string       `mapstructure:"caExpiry"`
+	CertExpiry string       `mapstructure:"certExpiry"`
+	Names      CertsSubject `mapstructure:"names"`
+}
+
+// CertsSubject is the subject of the CA and all certificates.
+type CertsSubject struct {
+	Country            string `mapstructure:"country"`
+	State              string `mapstructure:"state"`
+	Locality           string `mapstructure:"locality"`
+	Organization       string `mapstructure:"organization"`
+	OrganizationalUnit string `mapstructure:"organizationalUnit"`
+}
+
+// SecretsSection configures how secrets are sealed.
+type SecretsSection struct {
+	KeyFile string `mapstructure:"keyFile"`
+}
+
+// Read decodes config into a File. Returns an error if config contains keys
+// which are not part of the format or values of the wrong type.
+func Read(config *viper.Viper) (*File, error) {
+	var file File
+	err := config.UnmarshalExact(&file)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid config file: %w", err)
+	}
+	return &file, nil
+}
+
+// Load migrates config to Version, decodes and validates it. Migrations are
+// not persisted.
+func Load(config *viper.Viper) (*File, error) {
+	_, err := Migrate(config)
+	if err != nil {
+		return nil, err
+	}
+	file, err := Read(config)
+	if err != nil {
+		return nil, err
+	}
+	err = file.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// SetVersion sets the version of config to Version. Use it for new config files.
+func SetVersion(config *viper.Viper) {
+	config.Set(confVersionKey, Version)
+}
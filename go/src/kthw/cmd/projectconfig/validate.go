@@ -0,0 +1,186 @@
+package projectconfig
+
+import (
+	"fmt"
+	"kthw/certs"
+	"kthw/cmd/cluster/kube"
+	"kthw/cmd/infra/network"
+	"kthw/cmd/infra/provider"
+	"kthw/cmd/infra/server"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError lists all problems found in a config file.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("Invalid config file:\n  %s", strings.Join(e.Problems, "\n  "))
+}
+
+// validation collects problems of a config file.
+type validation struct {
+	problems []string
+}
+
+func (v *validation) addf(format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf(format, args...))
+}
+
+// Validate checks the config file is consistent. Returns a *ValidationError
+// listing all problems found.
+func (f *File) Validate() error {
+	v := &validation{}
+	if f.Version != Version {
+		v.addf("version is %d, but must be %d", f.Version, Version)
+	}
+	if f.Provider.Name != "" {
+		if err := provider.IsValidProvider(f.Provider.Name); err != nil {
+			v.addf("provider.name: %s", err)
+		}
+	}
+	f.validateServers(v)
+	f.validateSSH(v)
+	f.validateKubernetes(v)
+	f.validateCerts(v)
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// ServerNames returns the keys of all servers in the config file, sorted.
+func (f *File) ServerNames() []string {
+	names := make([]string, 0, len(f.HCloud.Server))
+	for name := range f.HCloud.Server {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (f *File) validateServers(v *validation) {
+	_, overlayNetwork, _ := net.ParseCIDR(network.OverlayNetworkCIDR)
+	publicIPsSeen := map[string]string{}
+	privateIPsSeen := map[string]string{}
+	controllers := 0
+
+	for _, key := range f.ServerNames() {
+		s := f.HCloud.Server[key]
+		// Servers are named by their key, which is unique. A name differing
+		// from it is most likely a copy and paste error.
+		if s.Name != "" && !strings.EqualFold(s.Name, key) {
+			v.addf("server %s: name '%s' differs from its key", key, s.Name)
+		}
+
+		if len(s.Roles) == 0 {
+			v.addf("server %s: no roles. Valid roles are: %s", key, strings.Join(server.AllValidRoles(), ", "))
+		}
+		rolesSeen := map[string]bool{}
+		for _, role := range s.Roles {
+			if err := server.IsValidRole(role); err != nil {
+				v.addf("server %s: %s", key, err)
+			}
+			if rolesSeen[role] {
+				v.addf("server %s: role %s is listed twice", key, role)
+			}
+			rolesSeen[role] = true
+		}
+		if rolesSeen["controller"] {
+			controllers++
+		}
+
+		if _, err := server.ParseLabels(s.Labels); err != nil {
+			v.addf("server %s: %s", key, err)
+		}
+
+		for _, phase := range s.CompletedPhases {
+			if err := server.IsValidPhase(phase); err != nil {
+				v.addf("server %s: %s", key, err)
+			}
+		}
+
+		if s.PublicIP != "" {
+			if net.ParseIP(s.PublicIP) == nil {
+				v.addf("server %s: public IP '%s' is not an IP", key, s.PublicIP)
+			} else if other, ok := publicIPsSeen[s.PublicIP]; ok {
+				v.addf("server %s: public IP %s is used by server %s as well", key, s.PublicIP, other)
+			}
+			publicIPsSeen[s.PublicIP] = key
+		} else if f.Provider.Name == provider.Static {
+			v.addf("server %s: public IP is required by the static provider", key)
+		}
+		if s.PrivateIP != "" {
+			ip := net.ParseIP(s.PrivateIP)
+			if ip == nil || !overlayNetwork.Contains(ip) {
+				v.addf("server %s: private IP '%s' is not in the overlay network %s", key, s.PrivateIP, network.OverlayNetworkCIDR)
+			} else if other, ok := privateIPsSeen[s.PrivateIP]; ok {
+				v.addf("server %s: private IP %s is used by server %s as well", key, s.PrivateIP, other)
+			}
+			privateIPsSeen[s.PrivateIP] = key
+		}
+	}
+
+	if len(f.HCloud.Server) > 0 && controllers == 0 {
+		v.addf("no server has role controller. A cluster requires at least one controller")
+	}
+	if controllers > 1 && f.Kubernetes.ControlPlaneEndpoint == "" {
+		v.addf("kubernetes.controlPlaneEndpoint: %d servers have role controller, but no endpoint is set. "+
+			"Set a load balancer or '%s'", controllers, kube.LocalHAProxy)
+	}
+}
+
+func (f *File) validateSSH(v *validation) {
+	if f.SSH.Port < 0 || f.SSH.Port > 65535 {
+		v.addf("ssh.port: %d is not a valid port", f.SSH.Port)
+	}
+	if f.SSH.MaxConcurrency < 0 {
+		v.addf("ssh.maxConcurrency: must not be negative, but was %d", f.SSH.MaxConcurrency)
+	}
+}
+
+func (f *File) validateKubernetes(v *validation) {
+	endpoint := f.Kubernetes.ControlPlaneEndpoint
+	if endpoint == "" || endpoint == kube.LocalHAProxy {
+		return
+	}
+	host, port, err := net.SplitHostPort(endpoint)
+	if err != nil || host == "" {
+		v.addf("kubernetes.controlPlaneEndpoint: '%s' is not in the format host:port", endpoint)
+		return
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil || portNumber < 1 || portNumber > 65535 {
+		v.addf("kubernetes.controlPlaneEndpoint: '%s' is not a valid port", port)
+	}
+}
+
+// validateCerts validates the certs settings, unless the section is missing.
+// Missing settings have their default value.
+func (f *File) validateCerts(v *validation) {
+	c := f.Certs
+	if c == (CertsSection{}) {
+		return
+	}
+	conf := certs.DefaultConfig()
+	setIfNotEmpty(&conf.KeyAlgo, c.KeyAlgo)
+	if c.KeySize != 0 {
+		conf.KeySize = c.KeySize
+	}
+	setIfNotEmpty(&conf.CAExpiry, c.CAExpiry)
+	setIfNotEmpty(&conf.CertExpiry, c.CertExpiry)
+	if err := conf.Validate(); err != nil {
+		v.addf("certs: %s", err)
+	}
+}
+
+func setIfNotEmpty(value *string, setting string) {
+	if setting != "" {
+		*value = setting
+	}
+}
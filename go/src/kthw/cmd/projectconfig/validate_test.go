@@ -0,0 +1,140 @@
+package projectconfig_test
+
+import (
+	"errors"
+	"kthw/cmd/projectconfig"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+var validConfig = `version: 1
+project:
+  name: test
+provider:
+  name: static
+hcloud:
+  server:
+    controller-1:
+      name: controller-1
+      publicIP: 192.168.1.1
+      privateIP: 10.0.0.1
+      roles: [controller, etcd]
+      completedPhases: [wireguard]
+    worker-1:
+      name: worker-1
+      publicIP: 192.168.1.2
+      roles: [worker]
+kubernetes:
+  controlPlaneEndpoint: lb.example.com:6443
+certs:
+  keyAlgo: ecdsa
+  keySize: 256
+`
+
+func helperReadConfig(t *testing.T, content string) *viper.Viper {
+	config := viper.New()
+	config.SetConfigType("yaml")
+	err := config.ReadConfig(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config
+}
+
+func helperEnsureProblem(t *testing.T, err error, expected string) {
+	var validationErr *projectconfig.ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, but got '%v'", err)
+	}
+	for _, problem := range validationErr.Problems {
+		if strings.Contains(problem, expected) {
+			return
+		}
+	}
+	t.Errorf("Expected problem containing '%s', but got %v", expected, validationErr.Problems)
+}
+
+func TestLoadValidConfig(t *testing.T) {
+	file, err := projectconfig.Load(helperReadConfig(t, validConfig))
+	if err != nil {
+		t.Fatalf("Unexpected error while loading valid config: %s", err)
+	}
+	if file.HCloud.Server["controller-1"].PrivateIP != "10.0.0.1" {
+		t.Errorf("Expected private IP 10.0.0.1, but got '%s'", file.HCloud.Server["controller-1"].PrivateIP)
+	}
+	if file.Kubernetes.ControlPlaneEndpoint != "lb.example.com:6443" {
+		t.Errorf("Expected control plane endpoint lb.example.com:6443, but got '%s'", file.Kubernetes.ControlPlaneEndpoint)
+	}
+}
+
+func TestReadFailsForUnknownKeys(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("kubernetes.controlPlaneEndpiont", "lb.example.com:6443")
+	_, err := projectconfig.Read(config)
+	if err == nil || !strings.Contains(err.Error(), "controlplaneendpiont") {
+		t.Errorf("Expected error naming the misspelled key, but got '%v'", err)
+	}
+}
+
+func TestReadFailsForValuesOfWrongType(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("ssh.port", "twenty-two")
+	_, err := projectconfig.Read(config)
+	if err == nil {
+		t.Errorf("Expected error, because ssh.port is not a number")
+	}
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("hcloud.server.controller-1.roles", []string{"master"})
+	config.Set("hcloud.server.controller-1.completedPhases", []string{"done"})
+	config.Set("hcloud.server.worker-1.publicIP", "192.168.1.1")
+	config.Set("hcloud.server.worker-1.privateIP", "192.168.2.1")
+	config.Set("kubernetes.controlPlaneEndpoint", "lb.example.com")
+
+	_, err := projectconfig.Load(config)
+	helperEnsureProblem(t, err, "'master' is not a valid role")
+	helperEnsureProblem(t, err, "'done' is not a valid phase")
+	helperEnsureProblem(t, err, "public IP 192.168.1.1 is used by server controller-1 as well")
+	helperEnsureProblem(t, err, "is not in the overlay network 10.0.0.0/24")
+	helperEnsureProblem(t, err, "no server has role controller")
+	helperEnsureProblem(t, err, "not in the format host:port")
+}
+
+func TestValidateFailsForServerNameDifferingFromKey(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("hcloud.server.worker-1.name", "worker-2")
+
+	_, err := projectconfig.Load(config)
+	helperEnsureProblem(t, err, "name 'worker-2' differs from its key")
+}
+
+func TestValidateFailsForInvalidCerts(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("certs.keySize", 1024)
+
+	_, err := projectconfig.Load(config)
+	helperEnsureProblem(t, err, "ECDSA key size must be 256, 384 or 521")
+}
+
+func TestValidateFailsForSeveralControllersWithoutEndpoint(t *testing.T) {
+	config := helperReadConfig(t, validConfig)
+	config.Set("kubernetes.controlPlaneEndpoint", "")
+	config.Set("hcloud.server.worker-1.roles", []string{"controller", "worker"})
+	file, err := projectconfig.Read(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Version = projectconfig.Version
+
+	helperEnsureProblem(t, file.Validate(), "2 servers have role controller, but no endpoint is set")
+
+	file.Kubernetes.ControlPlaneEndpoint = "haproxy"
+	err = file.Validate()
+	if err != nil {
+		t.Errorf("Unexpected error for haproxy as endpoint: %s", err)
+	}
+}
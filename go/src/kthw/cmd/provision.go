@@ -2,80 +2,76 @@ package cmd
 
 import (
 	"fmt"
-	"kthw/certs"
 	"kthw/cmd/common"
-	"kthw/cmd/infra/server"
-	"kthw/cmd/sshconnect"
-	"os"
 
 	"github.com/spf13/cobra"
 )
 
 var provisionCommand = &cobra.Command{
-	Use:   "provision",
-	Short: "Commands for provisioning servers and clusters"}
+	Use:         "provision",
+	Short:       "Commands for provisioning servers and clusters",
+	Annotations: supportsDryRun}
 
 var createServerCommand = &cobra.Command{
 	Use:   "server <name>",
 	Short: "Creates a server previously added to the config",
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		serverName := args[0]
-		serverConfig := server.FromConfig(serverName)
-		createServerAndUpdateConfig(&serverConfig)
-
-		fmt.Printf("Server %s successfully created.\n", serverName)
+		err := openProject().CreateServer(interruptibleContext(), args[0])
+		common.WhenErrPrintAndExit(err)
 	}}
 
 var configureWireguardCommand = &cobra.Command{
 	Use:   "network",
 	Short: "Generates wireguard config and establishes private overlay network",
 	Run: func(cmd *cobra.Command, args []string) {
-		sshClient := sshconnect.NewSSHConnect(Verbose)
-		serverConfigs, err := server.AllFromConfig()
-		if err != nil {
-			fmt.Printf("Error while loading servers from configuration: %s\n", err)
-			os.Exit(1)
-		}
-
-		setupWireguardAndUpdateConfig(serverConfigs, sshClient)
+		err := openProject().Provision(interruptibleContext())
+		common.WhenErrPrintAndExit(err)
 	}}
 
 var installEtcdCommand = &cobra.Command{
 	Use:   "etcd",
 	Short: "Downloads and installs etcd",
 	Run: func(cmd *cobra.Command, args []string) {
-		sshClient := sshconnect.NewSSHConnect(Verbose)
-		serverConfigs, err := server.AllFromConfig()
-		if err != nil {
-			fmt.Printf("Error while loading servers from configuration: %s\n", err)
-			os.Exit(1)
-		}
-
-		certGenerator, err := certs.LoadCertGenerator()
+		err := openProject().InstallEtcd(interruptibleContext())
 		common.WhenErrPrintAndExit(err)
-
-		installEtcd(serverConfigs, sshClient, certGenerator)
 	}}
 
 var installKubernetesControllerCommand = &cobra.Command{
 	Use:   "k8s-controller",
 	Short: "Generate config, upload certificates and install controller on node",
 	Run: func(cmd *cobra.Command, args []string) {
-		sshClient := sshconnect.NewSSHConnect(Verbose)
-		certLoader := certs.NewDefaultCertificateLoader()
-		certGenerator, err := certs.LoadCertGenerator()
+		ctx := interruptibleContext()
+		project := openProject()
+		err := project.InstallControlPlane(ctx)
 		common.WhenErrPrintAndExit(err)
-		serverConfigs, err := server.AllFromConfig()
-		if err != nil {
-			fmt.Printf("Error while loading servers from configuration: %s\n", err)
-			os.Exit(1)
-		}
+		err = project.JoinWorkers(ctx)
+		common.WhenErrPrintAndExit(err)
+	}}
+
+// AllWorkers selects all servers in role worker.
+var AllWorkers bool
 
-		installKubernetesController(serverConfigs, sshClient, certLoader, certGenerator)
+var installKubernetesWorkerCommand = &cobra.Command{
+	Use:   "k8s-worker [name]",
+	Short: "Joins a worker to the cluster and waits until the node is ready",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if AllWorkers && len(args) != 0 {
+			return fmt.Errorf("Either pass the name of a worker or --all, but not both")
+		}
+		if !AllWorkers && len(args) != 1 {
+			return fmt.Errorf("Expected the name of a worker or --all")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		err := openProject().JoinWorkers(interruptibleContext(), args...)
+		common.WhenErrPrintAndExit(err)
 	}}
 
 func provisionCommands() *cobra.Command {
+	installKubernetesWorkerCommand.Flags().BoolVar(&AllWorkers, "all", false, "Join all servers in role worker")
+	provisionCommand.AddCommand(installKubernetesWorkerCommand)
 	provisionCommand.AddCommand(createServerCommand)
 	provisionCommand.AddCommand(configureWireguardCommand)
 	provisionCommand.AddCommand(installEtcdCommand)
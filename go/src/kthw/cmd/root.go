@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"fmt"
+	"kthw/cmd/common"
+	"kthw/cmd/projectconfig"
+	"kthw/cmd/sshconnect"
 	"os"
 
 	"github.com/spf13/viper"
@@ -10,7 +13,21 @@ import (
 )
 
 var rootCmd = &cobra.Command{
-	Long: "First create a project, add ssh-keys and some servers. Then use the install commands to install the cluster."}
+	Long: "First create a project, add ssh-keys and some servers. Then use the install commands to install the cluster.",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		if DryRun && !isDryRunSupported(cmd) {
+			fmt.Printf("Command '%s' doesn't support --dry-run\n", cmd.CommandPath())
+			os.Exit(1)
+		}
+		migrateConfigFile()
+		if cmd.Flags().Changed("max-concurrency") {
+			sshconnect.SetMaxConcurrency(MaxConcurrency)
+		}
+		if cmd.Flags().Changed("fail-fast") {
+			sshconnect.SetFailFast(FailFast)
+		}
+		applySSHFlags(cmd)
+	}}
 
 // APIToken used to authenticate with Hetzer Cloud API.
 var APIToken string
@@ -18,13 +35,97 @@ var APIToken string
 // Verbose controls the verbosity during command execution. If 'true' you'll see more output which might help for debugging.
 var Verbose bool
 
+// MaxConcurrency is the number of hosts commands run on concurrently. Overrides ssh.maxConcurrency in config.
+var MaxConcurrency int
+
+// FailFast skips hosts not started yet as soon as one host failed. Overrides ssh.failFast in config.
+var FailFast bool
+
+// SSHUser, SSHPort, SSHIdentityFile, SSHAgent, SSHSudo and SSHProxyJump override the ssh.* settings in config.
+var (
+	SSHUser         string
+	SSHPort         int
+	SSHIdentityFile string
+	SSHAgent        bool
+	SSHSudo         bool
+	SSHProxyJump    string
+)
+
+// DryRun prints the commands and file uploads of every host instead of running them. No
+// server is created or deleted and the config file isn't written.
+var DryRun bool
+
+const dryRunAnnotation = "dryRun"
+
+// supportsDryRun annotates commands supporting --dry-run, including all their subcommands.
+var supportsDryRun = map[string]string{dryRunAnnotation: "true"}
+
+func isDryRunSupported(cmd *cobra.Command) bool {
+	for ; cmd != nil; cmd = cmd.Parent() {
+		if cmd.Annotations[dryRunAnnotation] == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+func applySSHFlags(cmd *cobra.Command) {
+	if cmd.Flags().Changed("ssh-user") {
+		sshconnect.SetUser(SSHUser)
+	}
+	if cmd.Flags().Changed("ssh-port") {
+		sshconnect.SetPort(SSHPort)
+	}
+	if cmd.Flags().Changed("ssh-identity-file") {
+		sshconnect.SetIdentityFile(SSHIdentityFile)
+	}
+	if cmd.Flags().Changed("ssh-agent") {
+		sshconnect.SetAgent(SSHAgent)
+	}
+	if cmd.Flags().Changed("ssh-sudo") {
+		sshconnect.SetSudo(SSHSudo)
+	}
+	if cmd.Flags().Changed("ssh-proxy-jump") {
+		sshconnect.SetProxyJump(SSHProxyJump)
+	}
+}
+
+// configReadErr is the error reading the config file failed with. Commands
+// creating a project run without config file.
+var configReadErr error
+
+// migrateConfigFile upgrades the config file to the current version of its
+// format. In dry-run mode, the migration isn't written.
+func migrateConfigFile() {
+	if configReadErr != nil {
+		return
+	}
+	from, err := projectconfig.Migrate(viper.GetViper())
+	common.WhenErrPrintAndExit(err)
+	if from == projectconfig.Version || DryRun {
+		return
+	}
+	err = viper.WriteConfig()
+	common.WhenErrPrintAndExit(err)
+	fmt.Printf("Migrated %s from version %d to %d.\n", viper.ConfigFileUsed(), from, projectconfig.Version)
+}
+
 // Execute runs commands child commands
 func Execute() {
 	viper.SetConfigFile(defaultConfigFile)
-	viper.ReadInConfig()
+	configReadErr = viper.ReadInConfig()
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "Set 'true' for more output.")
+	rootCmd.PersistentFlags().IntVar(&MaxConcurrency, "max-concurrency", 10, "Number of hosts commands run on concurrently. 0 runs on all hosts at once.")
+	rootCmd.PersistentFlags().BoolVar(&FailFast, "fail-fast", false, "Skip remaining hosts as soon as one host failed.")
+	rootCmd.PersistentFlags().StringVar(&SSHUser, "ssh-user", "root", "User to log in to hosts as.")
+	rootCmd.PersistentFlags().IntVar(&SSHPort, "ssh-port", 22, "Port of the SSH server of hosts.")
+	rootCmd.PersistentFlags().StringVar(&SSHIdentityFile, "ssh-identity-file", "", "Private key file. Defaults to ~/.ssh/id_ed25519 or ~/.ssh/id_rsa.")
+	rootCmd.PersistentFlags().BoolVar(&SSHAgent, "ssh-agent", false, "Use keys of the ssh-agent at SSH_AUTH_SOCK.")
+	rootCmd.PersistentFlags().BoolVar(&SSHSudo, "ssh-sudo", false, "Run commands with sudo. Defaults to 'true' for users other than root.")
+	rootCmd.PersistentFlags().StringVar(&SSHProxyJump, "ssh-proxy-jump", "", "Jump hosts [user@]host[:port], separated by commas, all hosts are reached through.")
+	rootCmd.PersistentFlags().BoolVar(&DryRun, "dry-run", false, "Print commands and file uploads per host instead of running them.")
 	rootCmd.PersistentFlags().StringVarP(&APIToken, "apiToken", "a", "", "API token for access to hcloud (required)")
-	rootCmd.AddCommand(projectCommands(), provisionCommands(), installCommands())
+	rootCmd.AddCommand(projectCommands(), provisionCommands(), installCommands(), destroyCommands(), logsCommand)
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
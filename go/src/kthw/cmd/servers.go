@@ -0,0 +1,245 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"kthw/cmd/common"
+	"kthw/cmd/infra/server"
+	"kthw/cmd/projectconfig"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+const (
+	outputTable = "table"
+	outputJSON  = "json"
+)
+
+var serversCommand = &cobra.Command{Use: "servers", Short: "List, show, remove servers and change their roles"}
+
+// ServersOutput is the format servers are printed in, either table or json.
+var ServersOutput string
+
+// ForceRemove removes provisioned servers from config.
+var ForceRemove bool
+
+// serverInfo is what list and show print about a server. Secrets like the
+// root password are left out.
+type serverInfo struct {
+	Name            string            `json:"name"`
+	Roles           []string          `json:"roles"`
+	ServerType      string            `json:"serverType,omitempty"`
+	ImageName       string            `json:"imageName,omitempty"`
+	LocationName    string            `json:"locationName,omitempty"`
+	Placement       string            `json:"placement,omitempty"`
+	Labels          map[string]string `json:"labels,omitempty"`
+	PublicIP        string            `json:"publicIP,omitempty"`
+	PrivateIP       string            `json:"privateIP,omitempty"`
+	ID              int               `json:"id,omitempty"`
+	SSHHost         string            `json:"sshHost,omitempty"`
+	ProxyJump       string            `json:"proxyJump,omitempty"`
+	CompletedPhases []string          `json:"completedPhases"`
+}
+
+func newServerInfo(config *server.Config) serverInfo {
+	completed := []string{}
+	for _, phase := range server.AllPhases() {
+		if config.IsPhaseCompleted(phase) {
+			completed = append(completed, phase)
+		}
+	}
+	return serverInfo{
+		Name:            config.Name,
+		Roles:           config.Roles,
+		ServerType:      config.ServerType,
+		ImageName:       config.ImageName,
+		LocationName:    config.LocationName,
+		Placement:       config.Placement,
+		Labels:          config.Labels,
+		PublicIP:        config.PublicIP,
+		PrivateIP:       config.PrivateIP,
+		ID:              config.ID,
+		SSHHost:         config.SSHHost(),
+		ProxyJump:       config.ProxyJump,
+		CompletedPhases: completed}
+}
+
+// phaseStatus returns the number of completed phases and the last of them, e.g. '3/6 wireguard'.
+func (info serverInfo) phaseStatus() string {
+	status := fmt.Sprintf("%d/%d", len(info.CompletedPhases), len(server.AllPhases()))
+	if len(info.CompletedPhases) > 0 {
+		status += " " + info.CompletedPhases[len(info.CompletedPhases)-1]
+	}
+	return status
+}
+
+func validateOutput(cmd *cobra.Command, args []string) error {
+	if ServersOutput != outputTable && ServersOutput != outputJSON {
+		return fmt.Errorf("'%s' is not a valid output format. Valid formats are: %s, %s", ServersOutput, outputTable, outputJSON)
+	}
+	return nil
+}
+
+var listServersCommand = &cobra.Command{
+	Use:     "list",
+	Short:   "Lists all servers with roles, IPs and install phases completed.",
+	Args:    cobra.NoArgs,
+	PreRunE: validateOutput,
+	Run: func(cmd *cobra.Command, args []string) {
+		serverConfigs, err := server.AllFromConfigWithoutSecrets()
+		if errors.Is(err, common.ErrNotFound) {
+			serverConfigs = nil
+		} else {
+			common.WhenErrPrintAndExit(err)
+		}
+
+		infos := make([]serverInfo, len(serverConfigs))
+		for i, serverConfig := range serverConfigs {
+			infos[i] = newServerInfo(serverConfig)
+		}
+		if ServersOutput == outputJSON {
+			printJSON(os.Stdout, infos)
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "NAME\tROLES\tTYPE\tLOCATION\tPUBLIC IP\tPRIVATE IP\tID\tPHASES")
+		for _, info := range infos {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				info.Name,
+				strings.Join(info.Roles, ","),
+				orDash(info.ServerType),
+				orDash(info.LocationName),
+				orDash(info.PublicIP),
+				orDash(info.PrivateIP),
+				orDash(idString(info.ID)),
+				info.phaseStatus())
+		}
+		writer.Flush()
+	}}
+
+var showServerCommand = &cobra.Command{
+	Use:     "show <server-name>",
+	Short:   "Shows the configuration and install phases of a server.",
+	Args:    cobra.ExactArgs(1),
+	PreRunE: validateOutput,
+	Run: func(cmd *cobra.Command, args []string) {
+		serverConfig, err := server.FromConfigWithoutSecrets(args[0])
+		common.WhenErrPrintAndExit(err)
+		info := newServerInfo(&serverConfig)
+		if ServersOutput == outputJSON {
+			printJSON(os.Stdout, info)
+			return
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(writer, "Name:\t%s\n", info.Name)
+		fmt.Fprintf(writer, "Roles:\t%s\n", strings.Join(info.Roles, ","))
+		fmt.Fprintf(writer, "Type:\t%s\n", orDash(info.ServerType))
+		fmt.Fprintf(writer, "Image:\t%s\n", orDash(info.ImageName))
+		fmt.Fprintf(writer, "Location:\t%s\n", orDash(info.LocationName))
+		fmt.Fprintf(writer, "Placement:\t%s\n", orDash(info.Placement))
+		fmt.Fprintf(writer, "Labels:\t%s\n", orDash(strings.Join(server.FormatLabels(info.Labels), ",")))
+		fmt.Fprintf(writer, "ID:\t%s\n", orDash(idString(info.ID)))
+		fmt.Fprintf(writer, "Public IP:\t%s\n", orDash(info.PublicIP))
+		fmt.Fprintf(writer, "Private IP:\t%s\n", orDash(info.PrivateIP))
+		fmt.Fprintf(writer, "SSH host:\t%s\n", orDash(info.SSHHost))
+		fmt.Fprintf(writer, "Proxy jump:\t%s\n", orDash(info.ProxyJump))
+		fmt.Fprintln(writer, "Phases:")
+		for _, phase := range server.AllPhases() {
+			status := "pending"
+			if serverConfig.IsPhaseCompleted(phase) {
+				status = "completed"
+			}
+			fmt.Fprintf(writer, "  %s\t%s\n", phase, status)
+		}
+		writer.Flush()
+	}}
+
+var removeServerCommand = &cobra.Command{
+	Use:   "remove <server-name>",
+	Short: "Removes a server from the config file.",
+	Long: "Servers created at hcloud or with completed install phases are only removed with --force. " +
+		"Use 'destroy server' to delete them instead. --force leaves the server running.",
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		serverConfig, err := server.FromConfigWithoutSecrets(args[0])
+		common.WhenErrPrintAndExit(err)
+		if !ForceRemove && (serverConfig.IsProvisioned() || len(serverConfig.CompletedPhases) > 0) {
+			fmt.Printf("Server %s is provisioned. Destroy it with 'destroy server %s' or use --force to remove it from config anyway.\n",
+				serverConfig.Name, serverConfig.Name)
+			os.Exit(1)
+		}
+
+		config, err := server.RemoveFromConfig(viper.GetViper(), serverConfig.Name)
+		common.WhenErrPrintAndExit(err)
+		err = writeValidConfig(config)
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("Server %s removed from config.\n", serverConfig.Name)
+	}}
+
+var setRolesCommand = &cobra.Command{
+	Use:   "set-roles <server-name> <roles>",
+	Short: "Replaces the roles of a server.",
+	Long:  "Roles are separated by commas. Valid roles are controller, worker and etcd.",
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 2 {
+			return fmt.Errorf("Expected exactly two arguments, but found '%d'", len(args))
+		}
+		return validateRoles(args[1])
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		serverConfig, err := server.FromConfigWithoutSecrets(args[0])
+		common.WhenErrPrintAndExit(err)
+		err = serverConfig.SetRoles(strings.Split(args[1], ","))
+		common.WhenErrPrintAndExit(err)
+
+		err = writeValidConfig(viper.GetViper())
+		common.WhenErrPrintAndExit(err)
+		fmt.Printf("Roles of server %s set to %s.\n", serverConfig.Name, args[1])
+	}}
+
+// writeValidConfig writes config to the config file, unless the changed config
+// is invalid, e.g. because no server is left in role controller.
+func writeValidConfig(config *viper.Viper) error {
+	_, err := projectconfig.Load(config)
+	if err != nil {
+		return err
+	}
+	return config.WriteConfig()
+}
+
+func printJSON(writer io.Writer, value interface{}) {
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	common.WhenErrPrintAndExit(encoder.Encode(value))
+}
+
+func orDash(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+func idString(id int) string {
+	if id == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", id)
+}
+
+func serversCommands() *cobra.Command {
+	for _, command := range []*cobra.Command{listServersCommand, showServerCommand} {
+		command.Flags().StringVarP(&ServersOutput, "output", "o", outputTable, "Output format: table or json")
+	}
+	removeServerCommand.Flags().BoolVar(&ForceRemove, "force", false, "Remove the server even if it is provisioned")
+	serversCommand.AddCommand(listServersCommand, showServerCommand, removeServerCommand, setRolesCommand)
+	return serversCommand
+}
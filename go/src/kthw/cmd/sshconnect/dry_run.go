@@ -0,0 +1,159 @@
+package sshconnect
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// DryRun records commands and file uploads instead of running them on remote
+// hosts. Close prints the recorded plan per host. Commands succeed and return
+// a placeholder as output.
+type DryRun struct {
+	out   io.Writer
+	hosts []string
+	plans map[string][]string
+	mutex sync.Mutex
+	SSHOperations
+}
+
+// NewDryRun creates a DryRun printing the plan to out.
+func NewDryRun(out io.Writer) *DryRun {
+	return &DryRun{out: out, plans: make(map[string][]string)}
+}
+
+// IsDryRun returns 'true' if ssh only records commands. Code waiting for a
+// remote state uses it to stop waiting, as the state is never reached.
+func IsDryRun(ssh SSHOperations) bool {
+	_, ok := ssh.(*DryRun)
+	return ok
+}
+
+// RunCmd records command and returns a placeholder for its output.
+func (d *DryRun) RunCmd(command Command, logOutput bool) (string, error) {
+	err := d.record(command)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("<output of '%s'>", command.GetDescription()), nil
+}
+
+// RunCmds records all commands.
+func (d *DryRun) RunCmds(commands *Commands) error {
+	for _, command := range commands.Commands {
+		err := d.record(command)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteReadOnlyFileTo records the upload of a file with permission 0444.
+func (d *DryRun) WriteReadOnlyFileTo(host string, contentReader io.Reader, filePathOnHost string) error {
+	return d.recordUpload(host, "", contentReader, false, func([]byte) fileUpload {
+		return fileUpload{path: filePathOnHost, mode: readOnlyFileMode}
+	})
+}
+
+// WriteExecutableFileTo records the upload of a file with permission 0744.
+func (d *DryRun) WriteExecutableFileTo(host string, contentReader io.Reader, filePathOnHost string) error {
+	return d.recordUpload(host, "", contentReader, false, func([]byte) fileUpload {
+		return fileUpload{path: filePathOnHost, mode: executableFileMode}
+	})
+}
+
+// Close prints the plan of every host in the order hosts were contacted first.
+func (d *DryRun) Close() error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	for _, host := range d.hosts {
+		fmt.Fprintf(d.out, "Plan for %s:\n", host)
+		for _, step := range d.plans[host] {
+			fmt.Fprintln(d.out, step)
+		}
+		fmt.Fprintln(d.out)
+	}
+	d.hosts = nil
+	d.plans = make(map[string][]string)
+	return nil
+}
+
+func (d *DryRun) record(command Command) error {
+	switch c := command.(type) {
+	case *ShellCommand:
+		d.add(c.Host, fmt.Sprintf("  # %s\n  $ %s", describe(c), c.CommandLine))
+		return nil
+	case *CopyFileCommand:
+		return d.recordUpload(c.Host, describe(c), c.FileContent, c.Sensitive, func(content []byte) fileUpload {
+			return uploadOf(c, content)
+		})
+	}
+	d.add(command.GetHost(), fmt.Sprintf("  # %s", describe(command)))
+	return nil
+}
+
+// recordUpload records path, mode, owner and digest of a file. uploadOf
+// returns how the file is written given its content. Text files readable by
+// everyone, e.g. rendered templates, are recorded with their content, unless
+// they are sensitive or contain private keys.
+func (d *DryRun) recordUpload(host string, description string, contentReader io.Reader, sensitive bool, uploadOf func(content []byte) fileUpload) error {
+	content, err := ioutil.ReadAll(contentReader)
+	upload := uploadOf(content)
+	if err != nil {
+		return fmt.Errorf("Error while reading content of '%s': %s", upload.path, err)
+	}
+
+	var step strings.Builder
+	if description != "" {
+		fmt.Fprintf(&step, "  # %s\n", description)
+	}
+	fmt.Fprintf(&step, "  upload %s (%s, %d bytes, sha256 %x)", upload.path, upload, len(content), sha256.Sum256(content))
+	if !sensitive && isWorldReadable(upload.mode) && isPrintable(content) {
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			fmt.Fprintf(&step, "\n    | %s", line)
+		}
+	}
+	d.add(host, step.String())
+	return nil
+}
+
+func (d *DryRun) add(host string, step string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if _, ok := d.plans[host]; !ok {
+		d.hosts = append(d.hosts, host)
+	}
+	d.plans[host] = append(d.plans[host], step)
+}
+
+// describe returns the description of command followed by its retry policy.
+func describe(command Command) string {
+	policy := command.GetRetryPolicy()
+	if policy.isZero() {
+		return command.GetDescription()
+	}
+	return fmt.Sprintf("%s (%s)", command.GetDescription(), policy)
+}
+
+// isWorldReadable returns 'true' if files with mode are readable by everyone.
+// Files readable by their owner only, like configs containing keys, aren't
+// printed.
+func isWorldReadable(mode string) bool {
+	parsed, err := strconv.ParseUint(mode, 8, 32)
+	return err == nil && parsed&0004 != 0
+}
+
+func isPrintable(content []byte) bool {
+	return len(content) > 0 &&
+		utf8.Valid(content) &&
+		!strings.ContainsRune(string(content), 0) &&
+		!isPrivateKey(content)
+}
@@ -0,0 +1,57 @@
+package sshconnect
+
+import (
+	"fmt"
+	"kthw/cmd/common"
+	"strings"
+)
+
+// RemoteCommandError is returned if a command failed on a host. ExitStatus is
+// -1 if the command didn't exit, e.g. because it timed out. Output contains
+// stdout and stderr of the command. errors.Is(err, common.ErrRemoteCommand) is
+// 'true' for it.
+type RemoteCommandError struct {
+	Host        string
+	CommandLine string
+	ExitStatus  int
+	Output      string
+	Err         error
+}
+
+func (e *RemoteCommandError) Error() string {
+	return fmt.Sprintf("error while running command on remote host %s. Exit status %d. Error output %s. Error %s",
+		e.Host, e.ExitStatus, e.Output, e.Err)
+}
+
+// maxSummaryOutputLength is the number of bytes at the end of the output
+// included in the summary of a RemoteCommandError.
+const maxSummaryOutputLength = 512
+
+// Summary describes the error like Error, but only includes the end of the
+// output. It is short enough for logs, even if the command wrote a lot.
+func (e *RemoteCommandError) Summary() string {
+	output := e.Output
+	if len(output) > maxSummaryOutputLength {
+		output = "..." + output[len(output)-maxSummaryOutputLength:]
+	}
+	return fmt.Sprintf("Exit status %d. Error output %s. Error %s", e.ExitStatus, output, e.Err)
+}
+
+// Unwrap returns the error returned by the SSH session.
+func (e *RemoteCommandError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns 'true' if target is common.ErrRemoteCommand.
+func (e *RemoteCommandError) Is(target error) bool {
+	return target == common.ErrRemoteCommand
+}
+
+// classifyDialError classifies errors of hosts rejecting all keys as
+// common.ErrAuth. The ssh package doesn't return a typed error for them.
+func classifyDialError(err error) error {
+	if strings.Contains(err.Error(), "unable to authenticate") {
+		return common.Classify(common.ErrAuth, err)
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package sshconnect_test
+
+import (
+	"errors"
+	"fmt"
+	"kthw/cmd/common"
+	"kthw/cmd/sshconnect"
+	"testing"
+)
+
+func TestRemoteCommandErrorIsErrRemoteCommand(t *testing.T) {
+	sessionErr := errors.New("Process exited with status 100")
+	var err error = &sshconnect.RemoteCommandError{Host: "10.0.0.1", CommandLine: "apt-get install -y haproxy", ExitStatus: 100, Err: sessionErr}
+	err = fmt.Errorf("Error while installing haproxy: %w", err)
+
+	if !errors.Is(err, common.ErrRemoteCommand) {
+		t.Errorf("Expected wrapped RemoteCommandError to be of class ErrRemoteCommand")
+	}
+	if errors.Is(err, common.ErrAuth) {
+		t.Errorf("Expected RemoteCommandError not to be of class ErrAuth")
+	}
+	if !errors.Is(err, sessionErr) {
+		t.Errorf("Expected RemoteCommandError to wrap the error of the session")
+	}
+
+	var remoteErr *sshconnect.RemoteCommandError
+	if !errors.As(err, &remoteErr) || remoteErr.ExitStatus != 100 {
+		t.Errorf("Expected exit status 100 of RemoteCommandError")
+	}
+}
@@ -0,0 +1,235 @@
+package sshconnect
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+const knownHostsFileName = "known_hosts"
+
+// knownHostsMutex serialises access to known_hosts files, as hosts are
+// contacted by several goroutines at once.
+var knownHostsMutex sync.Mutex
+
+// KnownHosts records the host keys of all servers of a project in a file in
+// known_hosts format. The key of a host is recorded on first contact, unless
+// it was added before, and enforced on every later connection.
+type KnownHosts struct {
+	file string
+}
+
+// HostKeyChangedError is returned if the key presented by a host differs from
+// the key recorded in known_hosts.
+type HostKeyChangedError struct {
+	Host        string
+	Fingerprint string
+	File        string
+}
+
+func (e *HostKeyChangedError) Error() string {
+	return fmt.Sprintf(
+		"Host key of %s changed to %s and doesn't match the key recorded in %s. "+
+			"Someone could be eavesdropping on you. If the server was re-created, run 'kthw project trust-host %s'",
+		e.Host, e.Fingerprint, e.File, e.Host)
+}
+
+// NewKnownHosts creates KnownHosts stored in file.
+func NewKnownHosts(file string) *KnownHosts {
+	return &KnownHosts{file: file}
+}
+
+// DefaultKnownHosts returns KnownHosts stored beside the config file of the project.
+func DefaultKnownHosts() *KnownHosts {
+	return DefaultKnownHostsIn(viper.GetViper())
+}
+
+// DefaultKnownHostsIn returns KnownHosts stored beside the file config was read from.
+func DefaultKnownHostsIn(config *viper.Viper) *KnownHosts {
+	return NewKnownHosts(filepath.Join(filepath.Dir(config.ConfigFileUsed()), knownHostsFileName))
+}
+
+// File returns the path of the known_hosts file.
+func (k *KnownHosts) File() string { return k.file }
+
+// HostKeyCallback verifies host keys against the known_hosts file. Keys of
+// unknown hosts are recorded. A changed key results in HostKeyChangedError.
+func (k *KnownHosts) HostKeyCallback() ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+
+		err := k.ensureFileExists()
+		if err != nil {
+			return err
+		}
+		callback, err := knownhosts.New(k.file)
+		if err != nil {
+			return fmt.Errorf("Error while reading known hosts from '%s': %s", k.file, err)
+		}
+
+		err = callback(hostname, remote, key)
+		keyErr, ok := err.(*knownhosts.KeyError)
+		if !ok {
+			return err
+		}
+		host := hostOf(hostname)
+		if len(keyErr.Want) > 0 {
+			return &HostKeyChangedError{Host: host, Fingerprint: ssh.FingerprintSHA256(key), File: k.file}
+		}
+
+		fmt.Printf("Recorded host key %s of %s in %s\n", ssh.FingerprintSHA256(key), host, k.file)
+		return k.appendKey(host, key)
+	}
+}
+
+// Add records key as host key of host.
+func (k *KnownHosts) Add(host string, key ssh.PublicKey) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+	return k.appendKey(host, key)
+}
+
+// Remove removes all keys recorded for host.
+func (k *KnownHosts) Remove(host string) error {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	content, err := ioutil.ReadFile(k.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error while reading known hosts from '%s': %s", k.file, err)
+	}
+
+	normalized := knownhosts.Normalize(host)
+	var remaining []string
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if !containsHost(fields[0], normalized) {
+			remaining = append(remaining, line)
+		}
+	}
+
+	var newContent string
+	if len(remaining) > 0 {
+		newContent = strings.Join(remaining, "\n") + "\n"
+	}
+	return ioutil.WriteFile(k.file, []byte(newContent), 0644)
+}
+
+// HostKeyAlgorithms returns the types of all keys recorded for host. Returns
+// nil if no key is recorded, so that every algorithm is accepted.
+func (k *KnownHosts) HostKeyAlgorithms(host string) []string {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	content, err := ioutil.ReadFile(k.file)
+	if err != nil {
+		return nil
+	}
+
+	normalized := knownhosts.Normalize(hostOf(host))
+	var algorithms []string
+	for len(content) > 0 {
+		_, hosts, key, _, rest, err := ssh.ParseKnownHosts(content)
+		if err != nil {
+			break
+		}
+		content = rest
+		for _, h := range hosts {
+			if h == normalized {
+				algorithms = append(algorithms, algorithmsOf(key)...)
+			}
+		}
+	}
+	return algorithms
+}
+
+func (k *KnownHosts) ensureFileExists() error {
+	if _, err := os.Stat(k.file); os.IsNotExist(err) {
+		err = ioutil.WriteFile(k.file, []byte{}, 0644)
+		if err != nil {
+			return fmt.Errorf("Error while creating known hosts file '%s': %s", k.file, err)
+		}
+	}
+	return nil
+}
+
+func (k *KnownHosts) appendKey(host string, key ssh.PublicKey) error {
+	file, err := os.OpenFile(k.file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error while opening known hosts file '%s': %s", k.file, err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintln(file, knownhosts.Line([]string{knownhosts.Normalize(host)}, key))
+	return err
+}
+
+func algorithmsOf(key ssh.PublicKey) []string {
+	if key.Type() == ssh.KeyAlgoRSA {
+		return []string{"rsa-sha2-512", "rsa-sha2-256", ssh.KeyAlgoRSA}
+	}
+	return []string{key.Type()}
+}
+
+func containsHost(hostsField string, normalized string) bool {
+	for _, h := range strings.Split(hostsField, ",") {
+		if h == normalized {
+			return true
+		}
+	}
+	return false
+}
+
+// hostOf strips the default SSH port from an address.
+func hostOf(address string) string {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || port != "22" {
+		return address
+	}
+	return host
+}
+
+// GenerateHostKey generates an ECDSA host key. It returns the private key in
+// PEM format, as expected by sshd and cloud-init, and the public key.
+func GenerateHostKey() (string, ssh.PublicKey, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error generating host key: %s", err)
+	}
+	der, err := x509.MarshalECPrivateKey(privateKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error encoding host key: %s", err)
+	}
+	publicKey, err := ssh.NewPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error encoding host public key: %s", err)
+	}
+
+	var privateKeyPEM bytes.Buffer
+	err = pem.Encode(&privateKeyPEM, &pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	if err != nil {
+		return "", nil, fmt.Errorf("Error encoding host key: %s", err)
+	}
+	return privateKeyPEM.String(), publicKey, nil
+}
@@ -0,0 +1,88 @@
+package sshconnect_test
+
+import (
+	"io/ioutil"
+	"kthw/cmd/sshconnect"
+	"net"
+	"path"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var remoteAddr = &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+func helperTempKnownHosts(t *testing.T) *sshconnect.KnownHosts {
+	tempDirName, err := ioutil.TempDir("", "KnownHosts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sshconnect.NewKnownHosts(path.Join(tempDirName, "known_hosts"))
+}
+
+func helperHostKey(t *testing.T) ssh.PublicKey {
+	_, publicKey, err := sshconnect.GenerateHostKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return publicKey
+}
+
+func TestHostKeyIsRecordedOnFirstConnection(t *testing.T) {
+	knownHosts := helperTempKnownHosts(t)
+	hostKey := helperHostKey(t)
+	callback := knownHosts.HostKeyCallback()
+
+	err := callback("10.0.0.1:22", remoteAddr, hostKey)
+	if err != nil {
+		t.Fatalf("Unexpected error for unknown host: %s", err)
+	}
+	err = callback("10.0.0.1:22", remoteAddr, hostKey)
+	if err != nil {
+		t.Errorf("Unexpected error for recorded host key: %s", err)
+	}
+
+	algorithms := knownHosts.HostKeyAlgorithms("10.0.0.1")
+	if len(algorithms) != 1 || algorithms[0] != hostKey.Type() {
+		t.Errorf("Expected host key algorithm '%s', but was %v", hostKey.Type(), algorithms)
+	}
+}
+
+func TestChangedHostKeyIsRejected(t *testing.T) {
+	knownHosts := helperTempKnownHosts(t)
+	err := knownHosts.Add("10.0.0.1", helperHostKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = knownHosts.HostKeyCallback()("10.0.0.1:22", remoteAddr, helperHostKey(t))
+	if _, ok := err.(*sshconnect.HostKeyChangedError); !ok {
+		t.Errorf("Expected HostKeyChangedError, but was: %v", err)
+	}
+}
+
+func TestRemoveHostKey(t *testing.T) {
+	knownHosts := helperTempKnownHosts(t)
+	for _, host := range []string{"10.0.0.1", "10.0.0.2"} {
+		err := knownHosts.Add(host, helperHostKey(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := knownHosts.Remove("10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error while removing host key: %s", err)
+	}
+
+	if knownHosts.HostKeyAlgorithms("10.0.0.1") != nil {
+		t.Errorf("Expected no host key of 10.0.0.1 after removing it")
+	}
+	if knownHosts.HostKeyAlgorithms("10.0.0.2") == nil {
+		t.Errorf("Expected host key of 10.0.0.2 to be kept")
+	}
+	err = knownHosts.HostKeyCallback()("10.0.0.1:22", remoteAddr, helperHostKey(t))
+	if err != nil {
+		t.Errorf("Expected new host key to be accepted after removing the old one: %s", err)
+	}
+}
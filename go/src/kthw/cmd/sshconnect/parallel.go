@@ -0,0 +1,153 @@
+package sshconnect
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	confMaxConcurrencyKey = "ssh.maxConcurrency"
+	confFailFastKey       = "ssh.failFast"
+
+	defaultMaxConcurrency = 10
+)
+
+// Parallel runs work for several hosts concurrently. At most MaxConcurrency
+// hosts are handled at the same time. If FailFast is 'true', hosts which
+// didn't start yet are skipped as soon as one host failed. Hosts already
+// running are never interrupted. Otherwise, all hosts are handled.
+type Parallel struct {
+	MaxConcurrency int
+	FailFast       bool
+}
+
+// Overrides of concurrency settings in config, set from command line flags.
+var (
+	maxConcurrencyOverride *int
+	failFastOverride       *bool
+)
+
+// ReadParallel reads concurrency settings from config. MaxConcurrency
+// defaults to 10. Values set by SetMaxConcurrency or SetFailFast take
+// precedence.
+func ReadParallel() *Parallel {
+	return ReadParallelIn(viper.GetViper())
+}
+
+// ReadParallelIn reads concurrency settings from config like ReadParallel.
+func ReadParallelIn(config *viper.Viper) *Parallel {
+	parallel := &Parallel{MaxConcurrency: defaultMaxConcurrency, FailFast: config.GetBool(confFailFastKey)}
+	if config.IsSet(confMaxConcurrencyKey) {
+		parallel.MaxConcurrency = config.GetInt(confMaxConcurrencyKey)
+	}
+	if maxConcurrencyOverride != nil {
+		parallel.MaxConcurrency = *maxConcurrencyOverride
+	}
+	if failFastOverride != nil {
+		parallel.FailFast = *failFastOverride
+	}
+	return parallel
+}
+
+// SetMaxConcurrency overrides the number of hosts handled concurrently
+// configured at ssh.maxConcurrency. The override isn't written to config.
+func SetMaxConcurrency(maxConcurrency int) {
+	maxConcurrencyOverride = &maxConcurrency
+}
+
+// SetFailFast overrides whether hosts are skipped after one failed,
+// configured at ssh.failFast. The override isn't written to config.
+func SetFailFast(failFast bool) {
+	failFastOverride = &failFast
+}
+
+// HostErrors contains the errors of all hosts which failed and the hosts
+// skipped because of them.
+type HostErrors struct {
+	Errors  map[string]error
+	Skipped []string
+}
+
+func (e *HostErrors) Error() string {
+	hosts := make([]string, 0, len(e.Errors))
+	for host := range e.Errors {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	messages := make([]string, len(hosts))
+	for i, host := range hosts {
+		messages[i] = fmt.Sprintf("%s: %s", host, e.Errors[host])
+	}
+	message := fmt.Sprintf("Failed on %d host(s):\n%s", len(hosts), strings.Join(messages, "\n"))
+	if len(e.Skipped) > 0 {
+		message += fmt.Sprintf("\nSkipped hosts: %s", strings.Join(e.Skipped, ", "))
+	}
+	return message
+}
+
+// RunOnHosts calls run with the index of every host in hosts. Returns
+// HostErrors if run failed for at least one host.
+func (p *Parallel) RunOnHosts(hosts []string, run func(i int) error) error {
+	maxConcurrency := p.MaxConcurrency
+	if maxConcurrency <= 0 || maxConcurrency > len(hosts) {
+		maxConcurrency = len(hosts)
+	}
+
+	var waitGroup sync.WaitGroup
+	var mutex sync.Mutex
+	hostErrors := &HostErrors{Errors: make(map[string]error)}
+	slots := make(chan struct{}, maxConcurrency)
+
+	for i, host := range hosts {
+		slots <- struct{}{}
+
+		mutex.Lock()
+		skip := p.FailFast && len(hostErrors.Errors) > 0
+		if skip {
+			hostErrors.Skipped = append(hostErrors.Skipped, host)
+		}
+		mutex.Unlock()
+		if skip {
+			<-slots
+			continue
+		}
+
+		waitGroup.Add(1)
+		go func(i int, host string) {
+			defer waitGroup.Done()
+			defer func() { <-slots }()
+
+			err := run(i)
+			if err != nil {
+				mutex.Lock()
+				hostErrors.Errors[host] = err
+				mutex.Unlock()
+			}
+		}(i, host)
+	}
+	waitGroup.Wait()
+
+	if len(hostErrors.Errors) > 0 {
+		return hostErrors
+	}
+	return nil
+}
+
+// RunCmds runs every batch of commands using ssh. All commands of a batch
+// must belong to the same host, which is the host of its first command.
+func (p *Parallel) RunCmds(ssh SSHOperations, batches []*Commands) error {
+	hosts := make([]string, len(batches))
+	for i, batch := range batches {
+		if len(batch.Commands) > 0 {
+			hosts[i] = batch.Commands[0].GetHost()
+		}
+	}
+	return p.RunOnHosts(hosts, func(i int) error {
+		return ssh.RunCmds(batches[i])
+	})
+}
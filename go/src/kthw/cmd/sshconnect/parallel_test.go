@@ -0,0 +1,90 @@
+package sshconnect_test
+
+import (
+	"fmt"
+	"kthw/cmd/sshconnect"
+	"sync"
+	"testing"
+	"time"
+)
+
+var parallelHosts = []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}
+
+func TestRunOnHostsRespectsMaxConcurrency(t *testing.T) {
+	parallel := &sshconnect.Parallel{MaxConcurrency: 2}
+	var mutex sync.Mutex
+	running, maxRunning, calls := 0, 0, 0
+
+	err := parallel.RunOnHosts(parallelHosts, func(i int) error {
+		mutex.Lock()
+		running++
+		calls++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if calls != len(parallelHosts) {
+		t.Errorf("Expected %d hosts to be handled, but were %d", len(parallelHosts), calls)
+	}
+	if maxRunning > 2 {
+		t.Errorf("Expected at most 2 hosts handled concurrently, but were %d", maxRunning)
+	}
+}
+
+func TestRunOnHostsAggregatesErrors(t *testing.T) {
+	parallel := &sshconnect.Parallel{MaxConcurrency: 0}
+
+	err := parallel.RunOnHosts(parallelHosts, func(i int) error {
+		if i%2 == 0 {
+			return fmt.Errorf("failed")
+		}
+		return nil
+	})
+
+	hostErrors, ok := err.(*sshconnect.HostErrors)
+	if !ok {
+		t.Fatalf("Expected HostErrors, but was: %v", err)
+	}
+	if len(hostErrors.Errors) != 3 {
+		t.Errorf("Expected errors of 3 hosts, but were %d", len(hostErrors.Errors))
+	}
+	if hostErrors.Errors["10.0.0.3"] == nil {
+		t.Errorf("Expected an error of host 10.0.0.3")
+	}
+	if len(hostErrors.Skipped) != 0 {
+		t.Errorf("Expected no skipped hosts, but were %v", hostErrors.Skipped)
+	}
+}
+
+func TestRunOnHostsFailFastSkipsRemainingHosts(t *testing.T) {
+	parallel := &sshconnect.Parallel{MaxConcurrency: 1, FailFast: true}
+	calls := 0
+
+	err := parallel.RunOnHosts(parallelHosts, func(i int) error {
+		calls++
+		return fmt.Errorf("failed")
+	})
+
+	hostErrors, ok := err.(*sshconnect.HostErrors)
+	if !ok {
+		t.Fatalf("Expected HostErrors, but was: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected only the first host to be handled, but were %d", calls)
+	}
+	if len(hostErrors.Skipped) != len(parallelHosts)-1 {
+		t.Errorf("Expected %d skipped hosts, but were %v", len(parallelHosts)-1, hostErrors.Skipped)
+	}
+}
@@ -0,0 +1,181 @@
+package sshconnect
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// NoProxyJump as proxy jump of a host connects to it directly, even if a proxy
+// jump is configured for the project.
+const NoProxyJump = "none"
+
+// ServerLookup finds the servers of a project. Jump hosts are configured per
+// server and may be names of servers, so that routes follow the IPs of servers
+// when they change, e.g. once the overlay network is set up.
+type ServerLookup interface {
+	// NameOf returns the name of the server connected to at host or "" if host
+	// is no server.
+	NameOf(host string) string
+	// HostOf returns the address the server name is reached at as jump host or
+	// "" if name is no server or has no address yet.
+	HostOf(name string) string
+}
+
+// endpoint is a host connected to, either directly or through the endpoints
+// before it in a route.
+type endpoint struct {
+	user string
+	host string
+	port int
+}
+
+func (e endpoint) address() string {
+	return net.JoinHostPort(e.host, strconv.Itoa(e.port))
+}
+
+// parseProxyJump parses a comma separated list of jump hosts in the format of
+// OpenSSH's ProxyJump, [user@]host[:port]. User and port default to settings.
+func (s *Settings) parseProxyJump(proxyJump string) ([]endpoint, error) {
+	proxyJump = strings.TrimSpace(proxyJump)
+	if proxyJump == "" || proxyJump == NoProxyJump {
+		return nil, nil
+	}
+
+	var jumps []endpoint
+	for _, jump := range strings.Split(proxyJump, ",") {
+		hop := endpoint{user: s.User, port: s.Port}
+		hostPort := strings.TrimSpace(jump)
+		if at := strings.LastIndex(hostPort, "@"); at >= 0 {
+			hop.user = hostPort[:at]
+			hostPort = hostPort[at+1:]
+		}
+
+		hop.host = hostPort
+		if strings.HasPrefix(hostPort, "[") || strings.Count(hostPort, ":") == 1 {
+			host, port, err := net.SplitHostPort(hostPort)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid jump host '%s': %s", jump, err)
+			}
+			hop.host = host
+			hop.port, err = strconv.Atoi(port)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid port of jump host '%s': %s", jump, err)
+			}
+		}
+		if hop.host == "" || hop.user == "" {
+			return nil, fmt.Errorf("Invalid jump host '%s'", jump)
+		}
+		jumps = append(jumps, hop)
+	}
+	return jumps, nil
+}
+
+// SetServerLookup sets the servers hosts and jump hosts are looked up in.
+func (c *SSHConnect) SetServerLookup(servers ServerLookup) {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+	c.servers = servers
+}
+
+// SetProxyJump sets the jump hosts host is connected through, overriding
+// ssh.proxyJump. host is the name of a server, if a ServerLookup is set. Its
+// jump hosts are used at whatever address the server is connected to.
+// NoProxyJump connects to host directly.
+func (c *SSHConnect) SetProxyJump(host string, proxyJump string) error {
+	jumps, err := c.settings.parseProxyJump(proxyJump)
+	if err != nil {
+		return err
+	}
+
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+	c.proxyJumps[host] = jumps
+	return nil
+}
+
+// route returns the endpoints connected to one after another to reach host,
+// ending with host itself. Jump hosts named like a server are replaced by its
+// address. A jump host configured for the project is reached directly, so
+// that it can be a server of the project as well.
+func (c *SSHConnect) route(host string) ([]endpoint, error) {
+	c.clientsMutex.Lock()
+	servers := c.servers
+	c.clientsMutex.Unlock()
+
+	key := host
+	if servers != nil {
+		if name := servers.NameOf(host); name != "" {
+			key = name
+		}
+	}
+	c.clientsMutex.Lock()
+	jumps, ok := c.proxyJumps[key]
+	c.clientsMutex.Unlock()
+	if !ok {
+		var err error
+		jumps, err = c.settings.parseProxyJump(c.settings.ProxyJump)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	route := make([]endpoint, 0, len(jumps)+1)
+	for _, jump := range jumps {
+		if servers != nil {
+			if address := servers.HostOf(jump.host); address != "" {
+				jump.host = address
+			}
+		}
+		if jump.host == host {
+			break
+		}
+		route = append(route, jump)
+	}
+	return append(route, endpoint{user: c.settings.User, host: host, port: c.settings.Port}), nil
+}
+
+func routeKey(route []endpoint) string {
+	hops := make([]string, len(route))
+	for i, hop := range route {
+		hops[i] = fmt.Sprintf("%s@%s", hop.user, hop.address())
+	}
+	return strings.Join(hops, ",")
+}
+
+// dial connects to address directly or, if via isn't nil, through via. The
+// timeout of config limits the SSH handshake as well, because the ssh package
+// only applies it to the TCP connect.
+func dial(via *ssh.Client, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var connection net.Conn
+	var err error
+	if via == nil {
+		connection, err = net.DialTimeout("tcp", address, config.Timeout)
+	} else {
+		connection, err = via.Dial("tcp", address)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Connections through jump hosts don't support deadlines. Closing the
+	// connection stops the handshake instead.
+	var timer *time.Timer
+	if config.Timeout > 0 {
+		timer = time.AfterFunc(config.Timeout, func() { connection.Close() })
+	}
+	clientConnection, channels, requests, err := ssh.NewClientConn(connection, address, config)
+	timedOut := timer != nil && !timer.Stop()
+	if err != nil || timedOut {
+		connection.Close()
+		if timedOut {
+			return nil, fmt.Errorf("SSH handshake with %s timed out after %s", address, config.Timeout)
+		}
+		return nil, err
+	}
+	return ssh.NewClient(clientConnection, channels, requests), nil
+}
@@ -0,0 +1,109 @@
+package sshconnect_test
+
+import (
+	"kthw/cmd/sshconnect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testServers looks up servers by name. Each has its public and private IP.
+type testServers map[string][2]string
+
+func (s testServers) NameOf(host string) string {
+	for name, ips := range s {
+		if ips[0] == host || ips[1] == host {
+			return name
+		}
+	}
+	return ""
+}
+
+func (s testServers) HostOf(name string) string {
+	return s[name][0]
+}
+
+func TestParseProxyJump(t *testing.T) {
+	tests := []struct {
+		proxyJump string
+		route     string
+		err       string
+	}{
+		{"", "root@10.0.0.2:22", ""},
+		{"none", "root@10.0.0.2:22", ""},
+		{"bastion.example.com", "root@bastion.example.com:22,root@10.0.0.2:22", ""},
+		{"admin@bastion:2222", "admin@bastion:2222,root@10.0.0.2:22", ""},
+		{" jump@1.2.3.4 , [fd00::1]:2200 ", "jump@1.2.3.4:22,root@[fd00::1]:2200,root@10.0.0.2:22", ""},
+		{"fd00::1", "root@[fd00::1]:22,root@10.0.0.2:22", ""},
+		{"bastion:ssh", "", "Invalid port"},
+		{"bastion:", "", "Invalid port"},
+		{"[fd00::1", "", "Invalid jump host"},
+		{"bastion,,gateway", "", "Invalid jump host"},
+		{"@bastion", "", "Invalid jump host"},
+		{"admin@:22", "", "Invalid jump host"}}
+
+	for _, test := range tests {
+		sshConnect := sshconnect.NewTestSSHConnect(t, &sshconnect.Settings{User: "root", Port: 22}, time.Second)
+		err := sshConnect.SetProxyJump("10.0.0.2", test.proxyJump)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("Expected error '%s' for '%s', but got '%v'", test.err, test.proxyJump, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for '%s': %s", test.proxyJump, err)
+			continue
+		}
+		route, err := sshConnect.Route("10.0.0.2")
+		if err != nil || route != test.route {
+			t.Errorf("Expected route '%s' for '%s', but got '%s' and '%v'", test.route, test.proxyJump, route, err)
+		}
+	}
+}
+
+func TestRouteOfServers(t *testing.T) {
+	servers := testServers{
+		"controller-1": {"1.1.1.1", "10.0.0.1"},
+		"worker-1":     {"2.2.2.2", "10.0.0.2"},
+		"worker-2":     {"3.3.3.3", ""}}
+	settings := &sshconnect.Settings{User: "root", Port: 22, ProxyJump: "admin@controller-1:2222"}
+	sshConnect := sshconnect.NewTestSSHConnect(t, settings, time.Second)
+	sshConnect.SetServerLookup(servers)
+	err := sshConnect.SetProxyJump("worker-1", "bastion,controller-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sshConnect.SetProxyJump("worker-2", sshconnect.NoProxyJump)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host  string
+		route string
+	}{
+		// Jump hosts of a server apply at its public and its private IP.
+		{"2.2.2.2", "root@bastion:22,root@1.1.1.1:22,root@2.2.2.2:22"},
+		{"10.0.0.2", "root@bastion:22,root@1.1.1.1:22,root@10.0.0.2:22"},
+		{"3.3.3.3", "root@3.3.3.3:22"},
+		// Servers without jump hosts of their own use those of the project.
+		{"10.0.0.1", "admin@1.1.1.1:2222,root@10.0.0.1:22"},
+		{"4.4.4.4", "admin@1.1.1.1:2222,root@4.4.4.4:22"},
+		// Jump hosts aren't reached through themselves.
+		{"1.1.1.1", "root@1.1.1.1:22"}}
+
+	for _, test := range tests {
+		route, err := sshConnect.Route(test.host)
+		if err != nil || route != test.route {
+			t.Errorf("Expected route '%s' to %s, but got '%s' and '%v'", test.route, test.host, route, err)
+		}
+	}
+
+	// Routes follow IPs set after jump hosts, e.g. once the overlay network is set up.
+	servers["worker-1"] = [2]string{"2.2.2.2", "10.0.0.5"}
+	route, err := sshConnect.Route("10.0.0.5")
+	if err != nil || route != "root@bastion:22,root@1.1.1.1:22,root@10.0.0.5:22" {
+		t.Errorf("Expected route through jump hosts of worker-1 to its new private IP, but got '%s' and '%v'", route, err)
+	}
+}
@@ -0,0 +1,279 @@
+package sshconnect
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// RetryPolicy controls how often a command is attempted and how long each
+// attempt may take. The zero value runs a command once without deadline.
+type RetryPolicy struct {
+	// Retries is the number of attempts after the first one failed.
+	Retries int
+	// Backoff is the delay before the first retry. It doubles with every retry.
+	Backoff time.Duration
+	// Timeout is the deadline of every attempt. Zero means no deadline.
+	Timeout time.Duration
+	// RetryIf restricts retries to failed attempts it returns 'true' for when
+	// called with their output. If nil, every failed attempt is retried.
+	RetryIf func(output string) bool
+}
+
+// AptRetryPolicy retries apt commands failing because another process, e.g.
+// unattended-upgrades, holds the dpkg lock.
+var AptRetryPolicy = RetryPolicy{
+	Retries: 10,
+	Backoff: 5 * time.Second,
+	Timeout: 15 * time.Minute,
+	RetryIf: OutputContains("Could not get lock", "Unable to acquire the dpkg frontend lock")}
+
+// DownloadRetryPolicy retries downloads which failed or took too long.
+var DownloadRetryPolicy = RetryPolicy{
+	Retries: 3,
+	Backoff: 5 * time.Second,
+	Timeout: 5 * time.Minute}
+
+// OutputContains returns a RetryIf predicate matching output which contains
+// at least one of substrings.
+func OutputContains(substrings ...string) func(output string) bool {
+	return func(output string) bool {
+		for _, substring := range substrings {
+			if strings.Contains(output, substring) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (p RetryPolicy) isZero() bool {
+	return p.Retries == 0 && p.Timeout == 0
+}
+
+// shouldRetry returns 'true' if the failed attempt with number attempt,
+// starting at 1, is retried.
+func (p RetryPolicy) shouldRetry(attempt int, output string) bool {
+	if attempt > p.Retries {
+		return false
+	}
+	return p.RetryIf == nil || p.RetryIf(output)
+}
+
+// delay returns how long to wait before retrying attempt.
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.Backoff * time.Duration(1<<uint(attempt-1))
+}
+
+func (p RetryPolicy) String() string {
+	var settings []string
+	if p.Retries > 0 {
+		settings = append(settings, fmt.Sprintf("retries %d, backoff %s", p.Retries, p.Backoff))
+	}
+	if p.RetryIf != nil {
+		settings = append(settings, "only on matching output")
+	}
+	if p.Timeout > 0 {
+		settings = append(settings, fmt.Sprintf("timeout %s", p.Timeout))
+	}
+	return strings.Join(settings, ", ")
+}
+
+// run runs command and retries it according to its RetryPolicy. Returns
+// stdout of the last attempt. Output of every attempt is written to the run
+// log while the command runs and, if stream is 'true', printed. Every retry
+// is printed. No further attempt is started once the context of c is done.
+func (c *SSHConnect) run(command Command, stream bool) (string, error) {
+	policy := command.GetRetryPolicy()
+	for attempt := 1; ; attempt++ {
+		err := c.ctx.Err()
+		if err != nil {
+			return "", err
+		}
+		started := time.Now()
+		attemptLog := c.beginAttempt(command.GetHost())
+		sink, flush := newOutputSink(command.GetHost(), attemptLog, stream)
+		output, err := command.runWith(c, sink)
+		flush()
+
+		retry := err != nil && policy.shouldRetry(attempt, output.Combined)
+		c.finishAttempt(attemptLog, command, attempt, started, output, err, retry)
+		if !retry {
+			return output.Stdout, err
+		}
+
+		delay := policy.delay(attempt)
+		printForHost(command.GetHost(), "%s -> Attempt %d of %d failed, retrying in %s: %s",
+			command.GetDescription(), attempt, policy.Retries+1, delay, err)
+		err = c.sleep(c.ctx, delay)
+		if err != nil {
+			return output.Stdout, err
+		}
+	}
+}
+
+// sleepContext waits for delay. Returns ctx.Err() if ctx is done earlier.
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(delay):
+		return nil
+	}
+}
+
+// beginAttempt starts logging an attempt to run a command on host. Returns
+// nil if the run log can't be written, which doesn't stop the run.
+func (c *SSHConnect) beginAttempt(host string) *Attempt {
+	attemptLog, err := c.runLog.Begin(host)
+	if err != nil {
+		printForHost(host, "Error while writing run log: %s", err)
+		return nil
+	}
+	return attemptLog
+}
+
+// newOutputSink writes output to attemptLog and, if stream is 'true', prints
+// it line by line. Stdout is printed to stdout and stderr to stderr. Call
+// flush after the command completed.
+func newOutputSink(host string, attemptLog *Attempt, stream bool) (outputSink, func()) {
+	stdout := []io.Writer{}
+	stderr := []io.Writer{}
+	if attemptLog != nil {
+		stdout = append(stdout, attemptLog.Stdout())
+		stderr = append(stderr, attemptLog.Stderr())
+	}
+	if !stream {
+		return outputSink{Stdout: io.MultiWriter(stdout...), Stderr: io.MultiWriter(stderr...)}, func() {}
+	}
+
+	stdoutLines := newLineWriter(host, os.Stdout)
+	stderrLines := newLineWriter(host, os.Stderr)
+	sink := outputSink{
+		Stdout: io.MultiWriter(append(stdout, stdoutLines)...),
+		Stderr: io.MultiWriter(append(stderr, stderrLines)...)}
+	return sink, func() {
+		stdoutLines.Flush()
+		stderrLines.Flush()
+	}
+}
+
+// finishAttempt adds an attempt to the run log.
+func (c *SSHConnect) finishAttempt(attemptLog *Attempt, command Command, attempt int, started time.Time, output commandOutput, err error, retried bool) {
+	if attemptLog == nil {
+		return
+	}
+
+	event := Event{
+		Host:        command.GetHost(),
+		Description: command.GetDescription(),
+		Command:     commandLineOf(command),
+		Attempt:     attempt,
+		ExitStatus:  output.ExitStatus,
+		DurationMs:  int64(time.Since(started) / time.Millisecond),
+		Retried:     retried}
+	if err != nil {
+		event.Error = eventErrorOf(err)
+		if event.ExitStatus == 0 {
+			event.ExitStatus = exitStatusUnknown
+		}
+	}
+
+	logErr := attemptLog.Finish(event)
+	if logErr != nil {
+		printForHost(command.GetHost(), "Error while writing run log: %s", logErr)
+	}
+}
+
+// eventErrorOf returns err as recorded in the run log. Errors of remote
+// commands only include the end of the output, which is in the output files
+// of the attempt.
+func eventErrorOf(err error) string {
+	var remoteErr *RemoteCommandError
+	if errors.As(err, &remoteErr) {
+		return remoteErr.Summary()
+	}
+	return err.Error()
+}
+
+func commandLineOf(command Command) string {
+	switch c := command.(type) {
+	case *ShellCommand:
+		return c.CommandLine
+	case *CopyFileCommand:
+		return fmt.Sprintf("scp %s", c.FilePath)
+	}
+	return ""
+}
+
+// exitStatusOf returns the exit status of a remote command from the error
+// returned by ssh.Session. Errors without exit status, e.g. connection errors
+// or timeouts, result in -1.
+func exitStatusOf(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus()
+	}
+	return exitStatusUnknown
+}
+
+// remoteTimeoutGrace is how much longer than its timeout a command runs on a
+// host before timeout(1) kills it. The timeout is enforced by runWithTimeout,
+// timeout(1) only makes sure the command doesn't keep running.
+const remoteTimeoutGrace = 5 * time.Second
+
+// withRemoteTimeout wraps commandLine in timeout(1), so that it is killed on
+// the host after timeout and remoteTimeoutGrace. OpenSSH before 8.1 ignores
+// signals sent to sessions. A timeout of zero returns commandLine unchanged.
+func withRemoteTimeout(commandLine string, timeout time.Duration) string {
+	if timeout <= 0 {
+		return commandLine
+	}
+	seconds := int64(math.Ceil((timeout + remoteTimeoutGrace).Seconds()))
+	return fmt.Sprintf("timeout -s KILL %d sh -c %s", seconds, shellQuote(commandLine))
+}
+
+// runWithTimeout calls run, which uses session. If run doesn't return within
+// timeout or before ctx is done, the session is closed. The remote process is
+// sent SIGKILL, which hosts running OpenSSH before 8.1 ignore.
+func runWithTimeout(ctx context.Context, session *ssh.Session, timeout time.Duration, run func() error) error {
+	if timeout <= 0 && ctx.Done() == nil {
+		return run()
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- run() }()
+
+	// A nil channel never fires, so commands without timeout only stop with ctx.
+	var timedOut <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		timedOut = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-timedOut:
+		killSession(session)
+		return fmt.Errorf("Timed out after %s", timeout)
+	case <-ctx.Done():
+		killSession(session)
+		return ctx.Err()
+	}
+}
+
+func killSession(session *ssh.Session) {
+	session.Signal(ssh.SIGKILL)
+	session.Close()
+}
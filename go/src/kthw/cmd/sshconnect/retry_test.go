@@ -0,0 +1,174 @@
+package sshconnect_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"kthw/cmd/sshconnect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOutputContains(t *testing.T) {
+	isLocked := sshconnect.OutputContains("Could not get lock", "Unable to acquire the dpkg frontend lock")
+
+	if !isLocked("E: Could not get lock /var/lib/dpkg/lock-frontend") {
+		t.Errorf("Expected output with one of the substrings to match")
+	}
+	if isLocked("E: Unable to locate package haproxy") {
+		t.Errorf("Expected output without any of the substrings not to match")
+	}
+}
+
+func TestDryRunShowsRetryPolicy(t *testing.T) {
+	var out bytes.Buffer
+	dryRun := sshconnect.NewDryRun(&out)
+
+	dryRun.RunCmd(&sshconnect.ShellCommand{
+		Host:        "10.0.0.1",
+		CommandLine: "curl -L https://example.com/etcd.tar.gz",
+		Description: "Download etcd binary",
+		Retry:       sshconnect.DownloadRetryPolicy}, false)
+	dryRun.Close()
+
+	expected := "# Download etcd binary (retries 3, backoff 5s, timeout 5m0s)"
+	if !strings.Contains(out.String(), expected) {
+		t.Errorf("Expected plan to contain '%s', but was:\n%s", expected, out.String())
+	}
+}
+
+// helperFailTimes returns a handler failing the first times attempts with
+// output and succeeding afterwards.
+func helperFailTimes(times int, output string) sshconnect.TestCommandHandler {
+	var attempts int32
+	return func(command string, stdout io.Writer, stderr io.Writer, closed <-chan struct{}) int {
+		if atomic.AddInt32(&attempts, 1) <= int32(times) {
+			fmt.Fprint(stderr, output)
+			return 100
+		}
+		fmt.Fprint(stdout, "installed")
+		return 0
+	}
+}
+
+func helperRunWithRetry(t *testing.T, handler sshconnect.TestCommandHandler, policy sshconnect.RetryPolicy) (*sshconnect.TestSSHServer, *sshconnect.TestSSHConnect, string, error) {
+	server := sshconnect.NewTestSSHServer(t, handler)
+	sshConnect := sshconnect.NewTestSSHConnect(t, &sshconnect.Settings{User: "root", Port: server.Port}, 5*time.Second)
+	output, err := sshConnect.RunCmd(&sshconnect.ShellCommand{
+		Host:        "127.0.0.1",
+		CommandLine: "apt-get install -y haproxy",
+		Description: "Install haproxy",
+		Retry:       policy}, false)
+	sshConnect.Close()
+	server.Close()
+	return server, sshConnect, output, err
+}
+
+func TestRetryUntilCommandSucceeds(t *testing.T) {
+	policy := sshconnect.RetryPolicy{Retries: 3, Backoff: time.Second}
+	server, sshConnect, output, err := helperRunWithRetry(t, helperFailTimes(2, "E: Could not get lock"), policy)
+
+	if err != nil || output != "installed" {
+		t.Fatalf("Expected third attempt to succeed, but got '%s' and '%v'", output, err)
+	}
+	if len(server.Commands()) != 3 {
+		t.Errorf("Expected 3 attempts, but were %d", len(server.Commands()))
+	}
+	expectedSleeps := []time.Duration{time.Second, 2 * time.Second}
+	if fmt.Sprint(sshConnect.Sleeps) != fmt.Sprint(expectedSleeps) {
+		t.Errorf("Expected backoff doubling with every retry %v, but was %v", expectedSleeps, sshConnect.Sleeps)
+	}
+}
+
+func TestRetryStopsAfterRetries(t *testing.T) {
+	policy := sshconnect.RetryPolicy{Retries: 2, Backoff: time.Second}
+	server, _, _, err := helperRunWithRetry(t, helperFailTimes(10, "E: Could not get lock"), policy)
+
+	var remoteErr *sshconnect.RemoteCommandError
+	if !errors.As(err, &remoteErr) || remoteErr.ExitStatus != 100 {
+		t.Fatalf("Expected RemoteCommandError with exit status 100, but got '%v'", err)
+	}
+	if len(server.Commands()) != 3 {
+		t.Errorf("Expected first attempt and 2 retries, but were %d attempts", len(server.Commands()))
+	}
+}
+
+func TestRetryOnlyIfOutputMatches(t *testing.T) {
+	policy := sshconnect.RetryPolicy{Retries: 3, Backoff: time.Second, RetryIf: sshconnect.OutputContains("Could not get lock")}
+	server, sshConnect, _, err := helperRunWithRetry(t, helperFailTimes(1, "E: Unable to locate package haproxy"), policy)
+
+	if err == nil {
+		t.Errorf("Expected error, because output didn't match RetryIf")
+	}
+	if len(server.Commands()) != 1 || len(sshConnect.Sleeps) != 0 {
+		t.Errorf("Expected no retry, but were %d attempts", len(server.Commands()))
+	}
+}
+
+func TestAttemptTimesOut(t *testing.T) {
+	hangUntilClosed := func(command string, stdout io.Writer, stderr io.Writer, closed <-chan struct{}) int {
+		<-closed
+		return 137
+	}
+	policy := sshconnect.RetryPolicy{Retries: 1, Timeout: 200 * time.Millisecond}
+	server, _, _, err := helperRunWithRetry(t, hangUntilClosed, policy)
+
+	if err == nil || !strings.Contains(err.Error(), "Timed out after 200ms") {
+		t.Errorf("Expected attempt to time out, but got '%v'", err)
+	}
+	commands := server.Commands()
+	if len(commands) != 2 {
+		t.Fatalf("Expected timed out attempt to be retried, but were %d attempts", len(commands))
+	}
+	if !strings.HasPrefix(commands[0], "timeout -s KILL 6 sh -c 'apt-get install -y haproxy'") {
+		t.Errorf("Expected command to be killed on the host by timeout(1), but was '%s'", commands[0])
+	}
+}
+
+func helperRunWithContext(t *testing.T, ctx context.Context, handler sshconnect.TestCommandHandler, policy sshconnect.RetryPolicy) (*sshconnect.TestSSHServer, error) {
+	server := sshconnect.NewTestSSHServer(t, handler)
+	sshConnect := sshconnect.NewTestSSHConnect(t, &sshconnect.Settings{User: "root", Port: server.Port}, 5*time.Second)
+	sshConnect.SetContext(ctx)
+	_, err := sshConnect.RunCmd(&sshconnect.ShellCommand{
+		Host:        "127.0.0.1",
+		CommandLine: "apt-get install -y haproxy",
+		Description: "Install haproxy",
+		Retry:       policy}, false)
+	sshConnect.Close()
+	server.Close()
+	return server, err
+}
+
+func TestCancelledContextRunsNoCommand(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	server, err := helperRunWithContext(t, ctx, helperFailTimes(0, ""), sshconnect.RetryPolicy{})
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, but got '%v'", err)
+	}
+	if len(server.Commands()) != 0 {
+		t.Errorf("Expected no command to run, but were %d", len(server.Commands()))
+	}
+}
+
+func TestCancelKillsRunningCommand(t *testing.T) {
+	hangUntilClosed := func(command string, stdout io.Writer, stderr io.Writer, closed <-chan struct{}) int {
+		<-closed
+		return 137
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	server, err := helperRunWithContext(t, ctx, hangUntilClosed, sshconnect.RetryPolicy{Retries: 3, Backoff: time.Second})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected running command to stop with the context, but got '%v'", err)
+	}
+	if len(server.Commands()) != 1 {
+		t.Errorf("Expected no retry once the context is done, but were %d attempts", len(server.Commands()))
+	}
+}
@@ -0,0 +1,286 @@
+package sshconnect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	runLogsDirName    = "logs"
+	runLogEventsFile  = "events.jsonl"
+	runDirTimeLayout  = "20060102-150405"
+	exitStatusUnknown = -1
+)
+
+// Event describes one attempt to run a command on a host. Output of the
+// command is stored in StdoutFile and StderrFile, relative to the run directory.
+type Event struct {
+	Time        time.Time `json:"time"`
+	Host        string    `json:"host"`
+	Description string    `json:"description"`
+	Command     string    `json:"command"`
+	Attempt     int       `json:"attempt"`
+	ExitStatus  int       `json:"exitStatus"`
+	DurationMs  int64     `json:"durationMs"`
+	Error       string    `json:"error,omitempty"`
+	Retried     bool      `json:"retried"`
+	StdoutFile  string    `json:"stdoutFile"`
+	StderrFile  string    `json:"stderrFile"`
+}
+
+// Failed returns 'true' if the attempt didn't succeed. Failed attempts may be
+// followed by a successful one if Retried is 'true'.
+func (e *Event) Failed() bool {
+	return e.ExitStatus != 0
+}
+
+// RunLog writes the events of one run and the output of every command to a
+// timestamped directory. The directory is created with the first event, so
+// that runs without commands leave no directory behind.
+type RunLog struct {
+	baseDir  string
+	started  time.Time
+	dir      string
+	events   *os.File
+	sequence int
+	mutex    sync.Mutex
+}
+
+// NewRunLog creates a RunLog for a run started at started, writing to a
+// directory in baseDir.
+func NewRunLog(baseDir string, started time.Time) *RunLog {
+	return &RunLog{baseDir: baseDir, started: started}
+}
+
+// DefaultRunLogsDir returns the directory beside the config file of the
+// project containing the directories of all runs.
+func DefaultRunLogsDir() string {
+	return DefaultRunLogsDirIn(viper.GetViper())
+}
+
+// DefaultRunLogsDirIn returns the directory of all runs beside the file config was read from.
+func DefaultRunLogsDirIn(config *viper.Viper) string {
+	return filepath.Join(filepath.Dir(config.ConfigFileUsed()), runLogsDirName)
+}
+
+// Dir returns the directory of the run. It is empty until the first event was recorded.
+func (r *RunLog) Dir() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.dir
+}
+
+// Attempt logs one attempt to run a command. Output is written to files
+// while the command runs.
+type Attempt struct {
+	runLog     *RunLog
+	stdoutFile string
+	stderrFile string
+	stdout     *os.File
+	stderr     *os.File
+}
+
+// Begin starts logging an attempt to run a command on host.
+func (r *RunLog) Begin(host string) (*Attempt, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	err := r.open()
+	if err != nil {
+		return nil, err
+	}
+
+	r.sequence++
+	prefix := fmt.Sprintf("%04d-%s", r.sequence, fileNameOf(host))
+	attempt := &Attempt{runLog: r, stdoutFile: prefix + ".stdout", stderrFile: prefix + ".stderr"}
+	attempt.stdout, err = os.OpenFile(filepath.Join(r.dir, attempt.stdoutFile), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("Error while creating output file in '%s': %s", r.dir, err)
+	}
+	attempt.stderr, err = os.OpenFile(filepath.Join(r.dir, attempt.stderrFile), os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		attempt.stdout.Close()
+		return nil, fmt.Errorf("Error while creating output file in '%s': %s", r.dir, err)
+	}
+	return attempt, nil
+}
+
+// Stdout returns the writer for stdout of the command.
+func (a *Attempt) Stdout() io.Writer { return a.stdout }
+
+// Stderr returns the writer for stderr of the command.
+func (a *Attempt) Stderr() io.Writer { return a.stderr }
+
+// Finish closes the output files and writes event. Fields Time, StdoutFile
+// and StderrFile are set by Finish.
+func (a *Attempt) Finish(event Event) error {
+	a.stdout.Close()
+	a.stderr.Close()
+	event.Time = time.Now()
+	event.StdoutFile = a.stdoutFile
+	event.StderrFile = a.stderrFile
+
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	a.runLog.mutex.Lock()
+	defer a.runLog.mutex.Unlock()
+	if a.runLog.events == nil {
+		return fmt.Errorf("Run log already closed")
+	}
+	_, err = fmt.Fprintln(a.runLog.events, string(line))
+	return err
+}
+
+// Record writes event and the complete output of a command.
+func (r *RunLog) Record(event Event, stdout string, stderr string) error {
+	attempt, err := r.Begin(event.Host)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(attempt.Stdout(), stdout)
+	if err == nil {
+		_, err = io.WriteString(attempt.Stderr(), stderr)
+	}
+	finishErr := attempt.Finish(event)
+	if err != nil {
+		return err
+	}
+	return finishErr
+}
+
+// Close closes the event log.
+func (r *RunLog) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.events == nil {
+		return nil
+	}
+	err := r.events.Close()
+	r.events = nil
+	return err
+}
+
+func (r *RunLog) open() error {
+	if r.events != nil {
+		return nil
+	}
+
+	dir := filepath.Join(r.baseDir, r.started.Format(runDirTimeLayout))
+	for i := 2; dirExists(dir); i++ {
+		dir = filepath.Join(r.baseDir, fmt.Sprintf("%s-%d", r.started.Format(runDirTimeLayout), i))
+	}
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return fmt.Errorf("Error while creating log directory '%s': %s", dir, err)
+	}
+
+	events, err := os.OpenFile(filepath.Join(dir, runLogEventsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("Error while creating event log in '%s': %s", dir, err)
+	}
+	r.dir = dir
+	r.events = events
+	return nil
+}
+
+// LatestRunDir returns the directory of the latest run in baseDir.
+func LatestRunDir(baseDir string) (string, error) {
+	files, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return "", fmt.Errorf("Error while reading log directory '%s': %s", baseDir, err)
+	}
+
+	var runDirs []string
+	for _, file := range files {
+		if file.IsDir() {
+			runDirs = append(runDirs, file.Name())
+		}
+	}
+	if len(runDirs) == 0 {
+		return "", fmt.Errorf("No runs found in '%s'", baseDir)
+	}
+	sort.Slice(runDirs, func(i, j int) bool { return runDirLess(runDirs[i], runDirs[j]) })
+	return filepath.Join(baseDir, runDirs[len(runDirs)-1]), nil
+}
+
+// ReadEvents reads all events of the run in runDir.
+func ReadEvents(runDir string) ([]*Event, error) {
+	file, err := os.Open(filepath.Join(runDir, runLogEventsFile))
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading events of run '%s': %s", runDir, err)
+	}
+	defer file.Close()
+
+	// Events are decoded one after another instead of line by line, as
+	// lines may be longer than the buffer of a bufio.Scanner.
+	var events []*Event
+	decoder := json.NewDecoder(file)
+	for {
+		event := &Event{}
+		err := decoder.Decode(event)
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Error while parsing events of run '%s': %s", runDir, err)
+		}
+		events = append(events, event)
+	}
+}
+
+// LastFailed returns the last failed event which wasn't retried or nil if all
+// commands succeeded eventually.
+func LastFailed(events []*Event) *Event {
+	for i := len(events) - 1; i >= 0; i-- {
+		if events[i].Failed() && !events[i].Retried {
+			return events[i]
+		}
+	}
+	return nil
+}
+
+// runDirLess sorts runs by start time. Runs started in the same second are
+// sorted by their suffix.
+func runDirLess(a string, b string) bool {
+	timeA, suffixA := splitRunDir(a)
+	timeB, suffixB := splitRunDir(b)
+	if timeA != timeB {
+		return timeA < timeB
+	}
+	return suffixA < suffixB
+}
+
+func splitRunDir(name string) (string, int) {
+	if len(name) <= len(runDirTimeLayout)+1 {
+		return name, 1
+	}
+	suffix, err := strconv.Atoi(name[len(runDirTimeLayout)+1:])
+	if err != nil {
+		return name, 1
+	}
+	return name[:len(runDirTimeLayout)], suffix
+}
+
+func fileNameOf(host string) string {
+	return strings.NewReplacer("/", "_", ":", "_", " ", "_", "<", "", ">", "").Replace(host)
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
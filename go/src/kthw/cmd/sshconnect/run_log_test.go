@@ -0,0 +1,155 @@
+package sshconnect_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"kthw/cmd/sshconnect"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func helperTempLogsDir(t *testing.T) string {
+	tempDirName, err := ioutil.TempDir("", "RunLog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tempDirName
+}
+
+func TestRunLogRecordsEventsAndOutput(t *testing.T) {
+	logsDir := helperTempLogsDir(t)
+	runLog := sshconnect.NewRunLog(logsDir, time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC))
+	if runLog.Dir() != "" {
+		t.Errorf("Expected no run directory before the first event, but was '%s'", runLog.Dir())
+	}
+
+	events := []sshconnect.Event{
+		{Host: "10.0.0.1", Description: "Install haproxy", Command: "apt-get install -y haproxy", Attempt: 1, ExitStatus: 100, Retried: true},
+		{Host: "10.0.0.1", Description: "Install haproxy", Command: "apt-get install -y haproxy", Attempt: 2},
+		{Host: "10.0.0.2", Description: "Start haproxy", Command: "systemctl restart haproxy", Attempt: 1, ExitStatus: 1},
+		{Host: "10.0.0.1", Description: "Start haproxy", Command: "systemctl restart haproxy", Attempt: 1}}
+	for _, event := range events {
+		err := runLog.Record(event, "out of "+event.Host, "err of "+event.Host)
+		if err != nil {
+			t.Fatalf("Unexpected error while recording event: %s", err)
+		}
+	}
+	runLog.Close()
+
+	expectedDir := filepath.Join(logsDir, "20190301-120000")
+	if runLog.Dir() != expectedDir {
+		t.Errorf("Expected run directory '%s', but was '%s'", expectedDir, runLog.Dir())
+	}
+
+	recorded, err := sshconnect.ReadEvents(runLog.Dir())
+	if err != nil {
+		t.Fatalf("Unexpected error while reading events: %s", err)
+	}
+	if len(recorded) != len(events) {
+		t.Fatalf("Expected %d events, but were %d", len(events), len(recorded))
+	}
+
+	failed := sshconnect.LastFailed(recorded)
+	if failed == nil || failed.Host != "10.0.0.2" || failed.Description != "Start haproxy" {
+		t.Fatalf("Expected 'Start haproxy' on 10.0.0.2 as last failed step, but was %+v", failed)
+	}
+	stderr, err := ioutil.ReadFile(filepath.Join(runLog.Dir(), failed.StderrFile))
+	if err != nil || string(stderr) != "err of 10.0.0.2" {
+		t.Errorf("Expected stderr of failed step, but was '%s' (%v)", stderr, err)
+	}
+}
+
+func TestLatestRunDir(t *testing.T) {
+	logsDir := helperTempLogsDir(t)
+	started := time.Date(2019, 3, 1, 12, 0, 0, 0, time.UTC)
+	for _, runStarted := range []time.Time{started, started.Add(-time.Hour), started} {
+		runLog := sshconnect.NewRunLog(logsDir, runStarted)
+		err := runLog.Record(sshconnect.Event{Host: "10.0.0.1", Description: "Check"}, "", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		runLog.Close()
+	}
+
+	latest, err := sshconnect.LatestRunDir(logsDir)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if filepath.Base(latest) != "20190301-120000-2" {
+		t.Errorf("Expected second run started at the same time as latest run, but was '%s'", latest)
+	}
+}
+
+func TestAttemptWritesOutputWhileRunning(t *testing.T) {
+	runLog := sshconnect.NewRunLog(helperTempLogsDir(t), time.Now())
+	defer runLog.Close()
+
+	attempt, err := runLog.Begin("10.0.0.1")
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	fmt.Fprintln(attempt.Stdout(), "Reading package lists...")
+	fmt.Fprintln(attempt.Stderr(), "E: Could not get lock")
+
+	events, err := sshconnect.ReadEvents(runLog.Dir())
+	if err != nil || len(events) != 0 {
+		t.Fatalf("Expected no event before the attempt finished, but were %d (%v)", len(events), err)
+	}
+	stdoutFiles, _ := filepath.Glob(filepath.Join(runLog.Dir(), "*.stdout"))
+	if len(stdoutFiles) != 1 {
+		t.Fatalf("Expected stdout file while running, but were %v", stdoutFiles)
+	}
+	stdout, err := ioutil.ReadFile(stdoutFiles[0])
+	if err != nil || string(stdout) != "Reading package lists...\n" {
+		t.Errorf("Expected output written while running, but was '%s' (%v)", stdout, err)
+	}
+
+	err = attempt.Finish(sshconnect.Event{Host: "10.0.0.1", Description: "Install haproxy", Attempt: 1, ExitStatus: 100})
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	events, err = sshconnect.ReadEvents(runLog.Dir())
+	if err != nil || len(events) != 1 {
+		t.Fatalf("Expected one event, but were %d (%v)", len(events), err)
+	}
+	stderr, err := ioutil.ReadFile(filepath.Join(runLog.Dir(), events[0].StderrFile))
+	if err != nil || string(stderr) != "E: Could not get lock\n" {
+		t.Errorf("Expected stderr kept separate, but was '%s' (%v)", stderr, err)
+	}
+}
+
+func TestReadEventsWithLongError(t *testing.T) {
+	runLog := sshconnect.NewRunLog(helperTempLogsDir(t), time.Now())
+	longError := strings.Repeat("x", 100*1024)
+	err := runLog.Record(sshconnect.Event{Host: "10.0.0.1", Description: "Install haproxy", ExitStatus: 1, Error: longError}, "", "")
+	if err != nil {
+		t.Fatalf("Unexpected error while recording event: %s", err)
+	}
+	runLog.Close()
+
+	recorded, err := sshconnect.ReadEvents(runLog.Dir())
+	if err != nil {
+		t.Fatalf("Unexpected error while reading events: %s", err)
+	}
+	if len(recorded) != 1 || recorded[0].Error != longError {
+		t.Errorf("Expected event with long error to be read")
+	}
+}
+
+func TestRemoteCommandErrorSummaryEndsWithOutput(t *testing.T) {
+	err := &sshconnect.RemoteCommandError{
+		Host:       "10.0.0.1",
+		ExitStatus: 100,
+		Output:     strings.Repeat("Reading package lists...\n", 10000) + "E: Unable to locate package haproxy",
+		Err:        fmt.Errorf("Process exited with status 100")}
+
+	summary := err.Summary()
+	if len(summary) > 1024 {
+		t.Errorf("Expected short summary, but it has %d bytes", len(summary))
+	}
+	if !strings.Contains(summary, "Exit status 100") || !strings.Contains(summary, "E: Unable to locate package haproxy") {
+		t.Errorf("Expected summary with exit status and end of output, but was '%s'", summary)
+	}
+}
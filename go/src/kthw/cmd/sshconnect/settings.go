@@ -0,0 +1,248 @@
+package sshconnect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"kthw/cmd/common"
+	"net"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	confUserKey         = "ssh.user"
+	confPortKey         = "ssh.port"
+	confIdentityFileKey = "ssh.identityFile"
+	confAgentKey        = "ssh.agent"
+	confSudoKey         = "ssh.sudo"
+	confProxyJumpKey    = "ssh.proxyJump"
+
+	defaultUser = "root"
+	defaultPort = 22
+
+	ed25519Key = "id_ed25519"
+	rsaKey     = "id_rsa"
+	sshBaseDir = ".ssh"
+
+	// PassphraseEnv is the environment variable the passphrase of an encrypted identity file is read from.
+	PassphraseEnv  = "KTHW_SSH_PASSPHRASE"
+	agentSocketEnv = "SSH_AUTH_SOCK"
+)
+
+// Settings control how hosts are connected to. Hosts are logged in to as User
+// on Port. Keys are taken from the ssh-agent if Agent is 'true' and from
+// IdentityFile, which defaults to ~/.ssh/id_ed25519 or ~/.ssh/id_rsa unless
+// the agent is used. If Sudo is 'true', commands are run with sudo and files
+// are moved to their destination with sudo after uploading them. Hosts are
+// connected to through the jump hosts in ProxyJump, unless set per host.
+type Settings struct {
+	User         string
+	Port         int
+	IdentityFile string
+	Agent        bool
+	Sudo         bool
+	ProxyJump    string
+}
+
+// Overrides of settings in config, set from command line flags.
+var (
+	userOverride         *string
+	portOverride         *int
+	identityFileOverride *string
+	agentOverride        *bool
+	sudoOverride         *bool
+	proxyJumpOverride    *string
+)
+
+// ReadSettings reads SSH settings from config. User defaults to root and Port
+// to 22. Sudo defaults to 'true' for users other than root. Values set by
+// SetUser, SetPort, SetIdentityFile, SetAgent, SetSudo or SetProxyJump take precedence.
+func ReadSettings() *Settings {
+	return ReadSettingsIn(viper.GetViper())
+}
+
+// ReadSettingsIn reads SSH settings from config like ReadSettings.
+func ReadSettingsIn(config *viper.Viper) *Settings {
+	settings := &Settings{
+		User:         config.GetString(confUserKey),
+		Port:         config.GetInt(confPortKey),
+		IdentityFile: config.GetString(confIdentityFileKey),
+		Agent:        config.GetBool(confAgentKey),
+		ProxyJump:    config.GetString(confProxyJumpKey)}
+	if userOverride != nil {
+		settings.User = *userOverride
+	}
+	if settings.User == "" {
+		settings.User = defaultUser
+	}
+	if portOverride != nil {
+		settings.Port = *portOverride
+	}
+	if settings.Port == 0 {
+		settings.Port = defaultPort
+	}
+	if identityFileOverride != nil {
+		settings.IdentityFile = *identityFileOverride
+	}
+	if agentOverride != nil {
+		settings.Agent = *agentOverride
+	}
+
+	settings.Sudo = settings.User != defaultUser
+	if config.IsSet(confSudoKey) {
+		settings.Sudo = config.GetBool(confSudoKey)
+	}
+	if sudoOverride != nil {
+		settings.Sudo = *sudoOverride
+	}
+	if proxyJumpOverride != nil {
+		settings.ProxyJump = *proxyJumpOverride
+	}
+	return settings
+}
+
+// SetUser overrides the user configured at ssh.user. The override isn't written to config.
+func SetUser(user string) {
+	userOverride = &user
+}
+
+// SetPort overrides the port configured at ssh.port. The override isn't written to config.
+func SetPort(port int) {
+	portOverride = &port
+}
+
+// SetIdentityFile overrides the private key file configured at ssh.identityFile.
+// The override isn't written to config.
+func SetIdentityFile(identityFile string) {
+	identityFileOverride = &identityFile
+}
+
+// SetAgent overrides whether keys of the ssh-agent are used, configured at
+// ssh.agent. The override isn't written to config.
+func SetAgent(useAgent bool) {
+	agentOverride = &useAgent
+}
+
+// SetSudo overrides whether commands are run with sudo, configured at
+// ssh.sudo. The override isn't written to config.
+func SetSudo(sudo bool) {
+	sudoOverride = &sudo
+}
+
+// SetProxyJump overrides the jump hosts configured at ssh.proxyJump. The
+// override isn't written to config.
+func SetProxyJump(proxyJump string) {
+	proxyJumpOverride = &proxyJump
+}
+
+// Address returns the address to dial to connect to host.
+func (s *Settings) Address(host string) string {
+	return net.JoinHostPort(host, strconv.Itoa(s.Port))
+}
+
+// WrapCommand returns commandLine run as root via sudo if Sudo is 'true'.
+// Otherwise, commandLine is returned unchanged. sudo must not ask for a
+// password, as commands don't run in a terminal.
+func (s *Settings) WrapCommand(commandLine string) string {
+	if !s.Sudo {
+		return commandLine
+	}
+	return fmt.Sprintf("sudo -n sh -c %s", shellQuote(commandLine))
+}
+
+// AuthMethods returns the keys of the ssh-agent, if Agent is 'true', followed
+// by the key in IdentityFile. Without agent, a key is required. The returned
+// connection to the ssh-agent must be closed once no more hosts are connected
+// to. It is nil without agent.
+func (s *Settings) AuthMethods() ([]ssh.AuthMethod, io.Closer, error) {
+	var methods []ssh.AuthMethod
+	var agentConnection io.Closer
+	if s.Agent {
+		connection, err := dialAgent()
+		if err != nil {
+			return nil, nil, err
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(connection).Signers))
+		agentConnection = connection
+	}
+
+	identityFile := s.IdentityFile
+	if identityFile == "" {
+		identityFile = defaultIdentityFile()
+	}
+	if identityFile == "" {
+		if s.Agent {
+			return methods, agentConnection, nil
+		}
+		return nil, nil, fmt.Errorf("No supported SSH private key found! Set ssh.identityFile or use the ssh-agent")
+	}
+
+	signer, err := loadPrivateKeyFile(identityFile)
+	if err != nil {
+		if agentConnection != nil {
+			agentConnection.Close()
+		}
+		return nil, nil, err
+	}
+	return append(methods, ssh.PublicKeys(signer)), agentConnection, nil
+}
+
+func dialAgent() (net.Conn, error) {
+	socket := os.Getenv(agentSocketEnv)
+	if socket == "" {
+		return nil, fmt.Errorf("ssh-agent not found. Make sure %s is set", agentSocketEnv)
+	}
+	connection, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("Error while connecting to ssh-agent at '%s': %s", socket, err)
+	}
+	return connection, nil
+}
+
+func defaultIdentityFile() string {
+	userHome := os.Getenv("HOME")
+	for _, keyFile := range []string{ed25519Key, rsaKey} {
+		keyPath := path.Join(userHome, sshBaseDir, keyFile)
+		if common.FileExists(keyPath) {
+			return keyPath
+		}
+	}
+	return ""
+}
+
+// loadPrivateKeyFile parses the private key in keyFile. Encrypted keys, in
+// PEM as well as in OpenSSH format, are decrypted with the passphrase in
+// environment variable PassphraseEnv.
+func loadPrivateKeyFile(keyFile string) (ssh.Signer, error) {
+	buffer, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error while reading SSH private key from file '%s': %s", keyFile, err)
+	}
+
+	key, err := ssh.ParsePrivateKey(buffer)
+	var passphraseMissing *ssh.PassphraseMissingError
+	if errors.As(err, &passphraseMissing) {
+		passphrase := os.Getenv(PassphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("SSH private key in file '%s' is encrypted. Set %s or add the key to the ssh-agent", keyFile, PassphraseEnv)
+		}
+		key, err = ssh.ParsePrivateKeyWithPassphrase(buffer, []byte(passphrase))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error while parsing SSH private key from file '%s': %s", keyFile, err)
+	}
+	return key, nil
+}
+
+// shellQuote quotes value as a single argument for sh.
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'\''`, -1) + "'"
+}
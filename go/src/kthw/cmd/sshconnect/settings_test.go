@@ -0,0 +1,150 @@
+package sshconnect_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"io/ioutil"
+	"kthw/cmd/sshconnect"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestReadSettingsDefaults(t *testing.T) {
+	viper.Reset()
+	settings := sshconnect.ReadSettings()
+
+	if settings.User != "root" || settings.Port != 22 || settings.Sudo || settings.Agent {
+		t.Errorf("Expected root on port 22 without sudo and agent, but was %+v", settings)
+	}
+	if settings.Address("10.0.0.1") != "10.0.0.1:22" {
+		t.Errorf("Expected address '10.0.0.1:22', but was '%s'", settings.Address("10.0.0.1"))
+	}
+}
+
+func TestReadSettingsEnablesSudoForOtherUsers(t *testing.T) {
+	viper.Reset()
+	viper.Set("ssh.user", "ubuntu")
+	viper.Set("ssh.port", 2222)
+	defer viper.Reset()
+
+	settings := sshconnect.ReadSettings()
+	if settings.User != "ubuntu" || !settings.Sudo {
+		t.Errorf("Expected user ubuntu with sudo, but was %+v", settings)
+	}
+	if settings.Address("fd00::1") != "[fd00::1]:2222" {
+		t.Errorf("Expected address '[fd00::1]:2222', but was '%s'", settings.Address("fd00::1"))
+	}
+
+	viper.Set("ssh.sudo", false)
+	if sshconnect.ReadSettings().Sudo {
+		t.Errorf("Expected ssh.sudo to disable sudo")
+	}
+}
+
+func TestWrapCommandQuotesCommandForSudo(t *testing.T) {
+	settings := &sshconnect.Settings{User: "ubuntu", Sudo: true}
+	wrapped := settings.WrapCommand("echo 'it''s' > /etc/motd && cat /etc/motd")
+
+	expected := `sudo -n sh -c 'echo '\''it'\'''\''s'\'' > /etc/motd && cat /etc/motd'`
+	if wrapped != expected {
+		t.Errorf("Expected '%s', but was '%s'", expected, wrapped)
+	}
+	if (&sshconnect.Settings{User: "root"}).WrapCommand("ls /") != "ls /" {
+		t.Errorf("Expected command without sudo unchanged")
+	}
+}
+
+func helperWriteEncryptedKey(t *testing.T, dir string, passphrase string) string {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKeyWithPassphrase(privateKey, "kthw test", []byte(passphrase))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := path.Join(dir, "id_ecdsa")
+	err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}
+
+func TestAuthMethodsDecryptsOpenSSHKey(t *testing.T) {
+	tempDirName, err := ioutil.TempDir("", "AuthMethods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirName)
+	settings := &sshconnect.Settings{IdentityFile: helperWriteEncryptedKey(t, tempDirName, "secret")}
+	defer os.Unsetenv(sshconnect.PassphraseEnv)
+
+	os.Unsetenv(sshconnect.PassphraseEnv)
+	_, _, err = settings.AuthMethods()
+	if err == nil || !strings.Contains(err.Error(), sshconnect.PassphraseEnv) {
+		t.Errorf("Expected error asking for %s, but got '%v'", sshconnect.PassphraseEnv, err)
+	}
+
+	os.Setenv(sshconnect.PassphraseEnv, "wrong")
+	_, _, err = settings.AuthMethods()
+	if err == nil {
+		t.Errorf("Expected error for wrong passphrase")
+	}
+
+	os.Setenv(sshconnect.PassphraseEnv, "secret")
+	methods, agentConnection, err := settings.AuthMethods()
+	if err != nil {
+		t.Fatalf("Unexpected error while decrypting key: %s", err)
+	}
+	if len(methods) != 1 || agentConnection != nil {
+		t.Errorf("Expected key from identity file only, but got %d methods and agent %v", len(methods), agentConnection)
+	}
+}
+
+func TestAuthMethodsReturnAgentConnectionToClose(t *testing.T) {
+	tempDirName, err := ioutil.TempDir("", "AuthMethods")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tempDirName)
+	socket := path.Join(tempDirName, "agent.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	served := make(chan error, 1)
+	go func() {
+		connection, err := listener.Accept()
+		if err != nil {
+			served <- err
+			return
+		}
+		served <- agent.ServeAgent(agent.NewKeyring(), connection)
+	}()
+	defer os.Setenv("SSH_AUTH_SOCK", os.Getenv("SSH_AUTH_SOCK"))
+	os.Setenv("SSH_AUTH_SOCK", socket)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", tempDirName)
+
+	methods, agentConnection, err := (&sshconnect.Settings{Agent: true}).AuthMethods()
+	if err != nil {
+		t.Fatalf("Unexpected error while connecting to agent: %s", err)
+	}
+	if len(methods) != 1 || agentConnection == nil {
+		t.Fatalf("Expected keys of the agent only, but got %d methods and agent %v", len(methods), agentConnection)
+	}
+
+	agentConnection.Close()
+	<-served
+}
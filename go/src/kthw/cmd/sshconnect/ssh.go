@@ -1,67 +1,66 @@
 package sshconnect
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"kthw/cmd/common"
+	"net"
 	"os"
-	"path"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/bramvdbogaerde/go-scp"
+	"github.com/spf13/viper"
 	"golang.org/x/crypto/ssh"
 )
 
-const (
-	ed25519Key = "id_ed25519"
-	rsaKey     = "id_rsa"
-	sshBaseDir = ".ssh"
-)
-
-func loadPrivateKeyFile() ssh.AuthMethod {
-	userHome := os.Getenv("HOME")
-	var selectedKeyFile string
-	ed25519KeyPath := path.Join(userHome, sshBaseDir, ed25519Key)
-	rsaKeyPath := path.Join(userHome, sshBaseDir, rsaKey)
-	if common.FileExists(ed25519KeyPath) {
-		selectedKeyFile = ed25519KeyPath
-	} else if common.FileExists(rsaKeyPath) {
-		selectedKeyFile = rsaKeyPath
-	} else {
-		fmt.Println("No supported SSH private key found!")
-		os.Exit(1)
-	}
-
-	buffer, err := ioutil.ReadFile(selectedKeyFile)
-	if err != nil {
-		fmt.Printf("Error while reading SSH private key from file '%s': %s", selectedKeyFile, err)
-		os.Exit(1)
-	}
-
-	key, err := ssh.ParsePrivateKey(buffer)
-	if err != nil {
-		fmt.Printf("Error while parsing SSH private key from file '%s': %s", selectedKeyFile, err)
-		os.Exit(1)
-	}
-	return ssh.PublicKeys(key)
-}
-
 // SSHOperations allow running commands on remote hosts and transferring files.
 type SSHOperations interface {
 	RunCmd(command Command, logOutput bool) (string, error)
 	RunCmds(commands *Commands) error
 	WriteReadOnlyFileTo(host string, contentReader io.Reader, filePathOnHost string) error
 	WriteExecutableFileTo(host string, contentReader io.Reader, filePathOnHost string) error
+	Close() error
 }
 
 // SSHConnect contains sshConfig used to connect to hosts and allows to run commands on a host and copy files via SCP.
+// One connection per host is kept open and shared by all commands and file transfers until Close is called.
+// Hosts may be reached through jump hosts, whose connections are shared as well.
 type SSHConnect struct {
+	ctx                 context.Context
 	sshConfig           ssh.ClientConfig
+	settings            *Settings
+	knownHosts          *KnownHosts
 	logOutputFromServer bool
+	runLog              *RunLog
+	clients             map[string]*pooledClient
+	proxyJumps          map[string][]endpoint
+	servers             ServerLookup
+	clientsMutex        sync.Mutex
+	// agentConnection is the connection to the ssh-agent, if it is used.
+	agentConnection io.Closer
+	// sleep waits before a command is retried. Returns ctx.Err() if ctx is
+	// done before delay passed.
+	sleep func(ctx context.Context, delay time.Duration) error
+	// copy uploads a file to a host.
+	copy func(host string, contentReader io.Reader, filePathOnHost string, mode string, timeout time.Duration) error
 	SSHOperations
 }
 
+// pooledClient is the connection along one route. Its mutex is held while
+// connecting, so that a route is connected to once, even if several commands
+// need it at the same time, while other routes are connected to concurrently.
+type pooledClient struct {
+	client *ssh.Client
+	mutex  sync.Mutex
+}
+
 // Commands contains a arrayof commands to be executed on a remote host.
 type Commands struct {
 	Commands  []Command
@@ -72,15 +71,36 @@ type Commands struct {
 type Command interface {
 	GetDescription() string
 	GetHost() string
-	runWith(ssh *SSHConnect) (string, error)
+	GetRetryPolicy() RetryPolicy
+	runWith(ssh *SSHConnect, sink outputSink) (commandOutput, error)
+}
+
+// outputSink receives stdout and stderr of a remote command while it runs.
+type outputSink struct {
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// commandOutput contains the output and exit status of a remote command.
+// Combined contains stdout and stderr in the order they were written.
+type commandOutput struct {
+	Combined   string
+	Stdout     string
+	Stderr     string
+	ExitStatus int
 }
 
-// ShellCommand is a simple command executed on a remote machine via ssh.
+// ShellCommand is a simple command executed on a remote machine via ssh. Set
+// Sensitive for commands printing key material or other secrets, so that their
+// stdout is only returned, but never printed, written to the run log or
+// included in errors.
 type ShellCommand struct {
 	Command
 	Host        string
 	CommandLine string
 	Description string
+	Retry       RetryPolicy
+	Sensitive   bool
 }
 
 // GetHost returns the host the command is executed on.
@@ -93,16 +113,43 @@ func (sc *ShellCommand) GetDescription() string {
 	return sc.Description
 }
 
-func (sc *ShellCommand) runWith(ssh *SSHConnect) (string, error) {
-	return ssh.runCmd(sc.Host, sc.CommandLine)
+// GetRetryPolicy returns how often the command is attempted and how long each attempt may take.
+func (sc *ShellCommand) GetRetryPolicy() RetryPolicy {
+	return sc.Retry
 }
 
+func (sc *ShellCommand) runWith(ssh *SSHConnect, sink outputSink) (commandOutput, error) {
+	if !sc.Sensitive {
+		return ssh.runCmd(sc.Host, sc.CommandLine, sc.Retry.Timeout, sink)
+	}
+
+	output, err := ssh.runCmd(sc.Host, sc.CommandLine, sc.Retry.Timeout, outputSink{Stdout: ioutil.Discard, Stderr: sink.Stderr})
+	output.Combined = output.Stderr
+	var remoteErr *RemoteCommandError
+	if errors.As(err, &remoteErr) {
+		remoteErr.Output = output.Stderr
+	}
+	return output, err
+}
+
+// CopyFileCommand copies FileContent to FilePath on a remote machine via SCP.
+// Mode defaults to PrivateKeyFileMode for private keys and DefaultFileMode for
+// all other files. Owner is given as 'user:group' and defaults to the user
+// logged in as or, with sudo, root. If Atomic is 'true', an existing file is
+// replaced at once by renaming a temporary file. Set Sensitive for key material
+// and other secrets, so that its content is never printed.
 type CopyFileCommand struct {
 	Command
 	Host        string
 	FileContent io.Reader
 	FilePath    string
+	Mode        string
+	Owner       string
+	Atomic      bool
+	Sensitive   bool
 	Description string
+	Retry       RetryPolicy
+	content     []byte
 }
 
 // GetHost returns the host the command is executed on.
@@ -115,100 +162,425 @@ func (sc *CopyFileCommand) GetDescription() string {
 	return sc.Description
 }
 
-func (sc *CopyFileCommand) runWith(ssh *SSHConnect) (string, error) {
-	err := ssh.WriteReadOnlyFileTo(sc.Host, sc.FileContent, sc.FilePath)
-	return "", err
+// GetRetryPolicy returns how often the command is attempted and how long each attempt may take.
+func (sc *CopyFileCommand) GetRetryPolicy() RetryPolicy {
+	return sc.Retry
 }
 
-// NewSSHConnect created a ssh.ClintConfig for user root and using a private key from ~/.ssh
-func NewSSHConnect(logOutputFromServer bool) *SSHConnect {
+// runWith reads FileContent only once, so that retries upload the same content.
+func (sc *CopyFileCommand) runWith(ssh *SSHConnect, sink outputSink) (commandOutput, error) {
+	if sc.content == nil {
+		content, err := ioutil.ReadAll(sc.FileContent)
+		if err != nil {
+			return commandOutput{}, fmt.Errorf("Error while reading content of '%s': %s", sc.FilePath, err)
+		}
+		sc.content = content
+	}
+	err := ssh.writeFileTo(sc.Host, sc.content, uploadOf(sc, sc.content), sc.Retry.Timeout)
+	return commandOutput{}, err
+}
+
+// NewSSHConnect created a ssh.ClintConfig for the user, port and keys configured in ReadSettings.
+// Host keys are verified against the known_hosts file beside the config file of the project.
+// Every command is logged to a new run directory in DefaultRunLogsDir. Returns
+// an error of class common.ErrAuth if no keys could be loaded.
+func NewSSHConnect(logOutputFromServer bool) (*SSHConnect, error) {
+	return NewSSHConnectIn(context.Background(), viper.GetViper(), logOutputFromServer)
+}
+
+// NewSSHConnectIn creates a SSHConnect like NewSSHConnect with the settings,
+// known_hosts file and run logs of the project config was read from. Once ctx
+// is done, running commands are killed and no further command is started.
+func NewSSHConnectIn(ctx context.Context, config *viper.Viper, logOutputFromServer bool) (*SSHConnect, error) {
+	settings := ReadSettingsIn(config)
+	authMethods, agentConnection, err := settings.AuthMethods()
+	if err != nil {
+		return nil, common.Classify(common.ErrAuth, err)
+	}
+
+	knownHosts := DefaultKnownHostsIn(config)
 	sshConfig := ssh.ClientConfig{
-		User:            "root",
-		Auth:            []ssh.AuthMethod{loadPrivateKeyFile()},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		User:            settings.User,
+		Auth:            authMethods,
+		HostKeyCallback: knownHosts.HostKeyCallback(),
 		Timeout:         15 * time.Second}
-	return &SSHConnect{sshConfig: sshConfig, logOutputFromServer: logOutputFromServer}
+	sshConnect := &SSHConnect{
+		ctx:                 ctx,
+		sshConfig:           sshConfig,
+		settings:            settings,
+		knownHosts:          knownHosts,
+		logOutputFromServer: logOutputFromServer,
+		runLog:              NewRunLog(DefaultRunLogsDirIn(config), time.Now()),
+		clients:             make(map[string]*pooledClient),
+		proxyJumps:          make(map[string][]endpoint),
+		agentConnection:     agentConnection,
+		sleep:               sleepContext}
+	sshConnect.copy = sshConnect.copyFile
+	return sshConnect, nil
 }
 
+// clientConfig restricts host key algorithms to the types of keys recorded
+// for host. Otherwise, the host could present a key of another type, which
+// would be taken as a changed key.
+func (c *SSHConnect) clientConfig(host string) *ssh.ClientConfig {
+	config := c.sshConfig
+	config.HostKeyAlgorithms = c.knownHosts.HostKeyAlgorithms(host)
+	return &config
+}
+
+// TrustHost removes the recorded host keys of host, connects to it and records
+// the key the host presents. Returns the fingerprint of the new key.
+func (c *SSHConnect) TrustHost(host string) (string, error) {
+	err := c.knownHosts.Remove(host)
+	if err != nil {
+		return "", err
+	}
+
+	var fingerprint string
+	config := c.clientConfig(host)
+	verify := config.HostKeyCallback
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint = ssh.FingerprintSHA256(key)
+		return verify(hostname, remote, key)
+	}
+
+	route, err := c.route(host)
+	if err != nil {
+		return "", err
+	}
+	via, err := c.clientOf(route[:len(route)-1])
+	if err != nil {
+		return "", err
+	}
+
+	connection, err := dial(via, c.settings.Address(host), config)
+	if err != nil {
+		return "", classifyDialError(fmt.Errorf("Error while connecting to server: %w", err))
+	}
+	connection.Close()
+	return fingerprint, nil
+}
+
+// client returns the connection to host. A new connection is established if
+// there is none yet or the previous one was closed.
+func (c *SSHConnect) client(host string) (*ssh.Client, error) {
+	route, err := c.route(host)
+	if err != nil {
+		return nil, err
+	}
+	return c.clientOf(route)
+}
+
+// clientOf returns the connection to the last endpoint of route, connecting
+// through the endpoints before it. Connections to jump hosts are kept open
+// and reused for all hosts behind them. Returns nil for an empty route.
+func (c *SSHConnect) clientOf(route []endpoint) (*ssh.Client, error) {
+	if len(route) == 0 {
+		return nil, nil
+	}
+	pooled := c.pooledClientOf(routeKey(route))
+	pooled.mutex.Lock()
+	defer pooled.mutex.Unlock()
+	if pooled.client != nil {
+		return pooled.client, nil
+	}
+
+	via, err := c.clientOf(route[:len(route)-1])
+	if err != nil {
+		return nil, err
+	}
+	target := route[len(route)-1]
+	config := c.clientConfig(target.host)
+	config.User = target.user
+	client, err := dial(via, target.address(), config)
+	if err != nil {
+		if via != nil {
+			return nil, classifyDialError(fmt.Errorf("Error while connecting to server %s via jump host: %w", target.host, err))
+		}
+		return nil, classifyDialError(fmt.Errorf("Error while connecting to server %s: %w", target.host, err))
+	}
+	pooled.client = client
+
+	go func() {
+		client.Wait()
+		c.removeClient(client)
+	}()
+	return client, nil
+}
+
+// pooledClientOf returns the pooled connection of the route with key, which
+// is added to the pool if it is missing.
+func (c *SSHConnect) pooledClientOf(key string) *pooledClient {
+	c.clientsMutex.Lock()
+	defer c.clientsMutex.Unlock()
+
+	pooled, ok := c.clients[key]
+	if !ok {
+		pooled = &pooledClient{}
+		c.clients[key] = pooled
+	}
+	return pooled
+}
+
+// removeClient removes client from the pool, unless it was replaced by a new
+// connection already.
+func (c *SSHConnect) removeClient(client *ssh.Client) {
+	c.clientsMutex.Lock()
+	pool := make([]*pooledClient, 0, len(c.clients))
+	for _, pooled := range c.clients {
+		pool = append(pool, pooled)
+	}
+	c.clientsMutex.Unlock()
+
+	for _, pooled := range pool {
+		pooled.mutex.Lock()
+		if pooled.client == client {
+			pooled.client = nil
+		}
+		pooled.mutex.Unlock()
+	}
+}
+
+// connect opens a new session on the connection to host. If the connection
+// dropped, it reconnects once.
 func (c *SSHConnect) connect(host string) (*ssh.Session, error) {
-	connection, err := ssh.Dial("tcp", fmt.Sprintf("%s:22", host), &c.sshConfig)
+	client, err := c.client(host)
 	if err != nil {
-		return nil, fmt.Errorf("Error while connecting to server: %s", err)
+		return nil, err
 	}
 
-	session, err := connection.NewSession()
+	session, err := client.NewSession()
+	if err == nil {
+		return session, nil
+	}
+
+	client.Close()
+	c.removeClient(client)
+	client, err = c.client(host)
+	if err != nil {
+		return nil, err
+	}
+	session, err = client.NewSession()
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create session: %s", err)
 	}
 	return session, nil
 }
 
-// RunCmd connects to host, runs command on this host and returns its output.
-func (c *SSHConnect) runCmd(host string, command string) (string, error) {
+// Close closes the connections to all hosts, the run log and the connection to
+// the ssh-agent. Commands run afterwards establish new connections, but without
+// the keys of the ssh-agent.
+func (c *SSHConnect) Close() error {
+	if c.runLog.Dir() != "" {
+		fmt.Printf("Logs of this run are in %s\n", c.runLog.Dir())
+	}
+	c.runLog.Close()
+
+	c.clientsMutex.Lock()
+	pool := c.clients
+	c.clients = make(map[string]*pooledClient)
+	c.clientsMutex.Unlock()
+
+	// Connections through jump hosts are closed before the connections to the
+	// jump hosts. Their routes are longer.
+	keys := make([]string, 0, len(pool))
+	for key := range pool {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+
+	var closeErr error
+	for _, key := range keys {
+		pooled := pool[key]
+		pooled.mutex.Lock()
+		if pooled.client != nil {
+			err := pooled.client.Close()
+			if err != nil && closeErr == nil {
+				closeErr = fmt.Errorf("Error while closing connection to %s: %s", key, err)
+			}
+			pooled.client = nil
+		}
+		pooled.mutex.Unlock()
+	}
+
+	if c.agentConnection != nil {
+		err := c.agentConnection.Close()
+		if err != nil && closeErr == nil {
+			closeErr = fmt.Errorf("Error while closing connection to ssh-agent: %s", err)
+		}
+		c.agentConnection = nil
+	}
+	return closeErr
+}
+
+// runCmd connects to host, runs command on this host and returns its output. The
+// output is returned on errors as well. While the command runs, its output is
+// written to sink. A timeout of zero means no deadline. command runs with sudo
+// if configured in settings. Returns a *RemoteCommandError if command failed.
+// Commands with timeout are killed on the host by timeout(1) as well, because
+// closing the session doesn't stop them.
+func (c *SSHConnect) runCmd(host string, command string, timeout time.Duration, sink outputSink) (commandOutput, error) {
 	session, err := c.connect(host)
 	if err != nil {
-		return "", err
+		return commandOutput{}, err
 	}
 	defer session.Close()
 
-	output, err := session.CombinedOutput(command)
+	var stdout, stderr, combined syncBuffer
+	session.Stdout = io.MultiWriter(&stdout, &combined, sink.Stdout)
+	session.Stderr = io.MultiWriter(&stderr, &combined, sink.Stderr)
+	err = runWithTimeout(c.ctx, session, timeout, func() error {
+		return session.Run(c.settings.WrapCommand(withRemoteTimeout(command, timeout)))
+	})
+
+	output := commandOutput{
+		Combined:   combined.String(),
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		ExitStatus: exitStatusOf(err)}
 	if err != nil {
-		errFromServer := string(output)
-		return "", fmt.Errorf("error while running command on remote host. Error output %s. Error %s", errFromServer, err)
+		return output, &RemoteCommandError{
+			Host:        host,
+			CommandLine: command,
+			ExitStatus:  output.ExitStatus,
+			Output:      output.Combined,
+			Err:         err}
 	}
+	return output, nil
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use. Stdout and stderr of
+// a session are copied by different goroutines.
+type syncBuffer struct {
+	buffer bytes.Buffer
+	mutex  sync.Mutex
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.Write(p)
+}
 
-	return string(output), nil
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer.String()
 }
 
+// RunCmd runs command and returns its stdout. If logOutput is 'true' or
+// SSHConnect logs output from server, output is printed while the command runs.
 func (c *SSHConnect) RunCmd(command Command, logOutput bool) (string, error) {
-	result, err := command.runWith(c)
+	stream := c.logOutputFromServer || logOutput
+	printForHost(command.GetHost(), "%s\n", command.GetDescription())
 
-	if c.logOutputFromServer || logOutput {
-		fmt.Printf("Command:%s\nResult:%s\nErr:%s\n", command.GetDescription(), result, err)
-	} else {
-		fmt.Println(command.GetDescription())
+	result, err := c.run(command, stream)
+	if err != nil && stream {
+		printForHost(command.GetHost(), "%s -> Unsuccessful: %s\n", command.GetDescription(), err)
 	}
 	return result, err
 }
 
 // RunCmds runs all commands on a specified remote host. If one command fails, it returns an error.
+// Output is printed while commands run if LogOutput is 'true' or SSHConnect logs output from server.
 func (c *SSHConnect) RunCmds(commands *Commands) error {
+	stream := c.logOutputFromServer || commands.LogOutput
 	for _, command := range commands.Commands {
-		result, err := command.runWith(c)
+		printForHost(command.GetHost(), "%s\n", command.GetDescription())
+		_, err := c.run(command, stream)
 		if err != nil {
-			fmt.Printf("%s -> Unsuccessful!\n", command.GetDescription())
+			printForHost(command.GetHost(), "%s -> Unsuccessful!\n", command.GetDescription())
 			return err
 		}
+	}
+	return nil
+}
+
+// outputMutex keeps output of commands running concurrently on several hosts
+// from being interleaved.
+var outputMutex sync.Mutex
+
+// printForHost prints every line prefixed with host, so that output of hosts
+// handled concurrently can be told apart.
+func printForHost(host string, format string, args ...interface{}) {
+	fprintForHost(os.Stdout, host, format, args...)
+}
+
+func fprintForHost(out io.Writer, host string, format string, args ...interface{}) {
+	lines := strings.Split(strings.TrimSuffix(fmt.Sprintf(format, args...), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = fmt.Sprintf("[%s] %s", host, line)
+	}
+
+	outputMutex.Lock()
+	defer outputMutex.Unlock()
+	fmt.Fprintln(out, strings.Join(lines, "\n"))
+}
 
-		if c.logOutputFromServer {
-			fmt.Printf("Command:%s\nResult:%s\n", command.GetDescription(), result)
-		} else {
-			fmt.Println(command.GetDescription())
+// lineWriter prints complete lines written to it prefixed with host.
+type lineWriter struct {
+	host    string
+	out     io.Writer
+	partial []byte
+}
+
+func newLineWriter(host string, out io.Writer) *lineWriter {
+	return &lineWriter{host: host, out: out}
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		end := bytes.IndexByte(w.partial, '\n')
+		if end < 0 {
+			return len(p), nil
 		}
+		fprintForHost(w.out, w.host, "%s", w.partial[:end])
+		w.partial = w.partial[end+1:]
+	}
+}
+
+// Flush prints the last line if it isn't terminated by a newline.
+func (w *lineWriter) Flush() {
+	if len(w.partial) > 0 {
+		fprintForHost(w.out, w.host, "%s", w.partial)
+		w.partial = nil
 	}
-	return nil
 }
 
 // WriteReadOnlyFileTo connects to host, reads from contentReader and writes it to file at filePathOnHost.
 // Set permission of this file to 0444.
 func (c *SSHConnect) WriteReadOnlyFileTo(host string, contentReader io.Reader, filePathOnHost string) error {
-	return c.writeFileTo(host, contentReader, filePathOnHost, "0444")
+	return c.writeFileWithMode(host, contentReader, filePathOnHost, readOnlyFileMode)
 }
 
 // WriteExecutableFileTo connects to host, reads from contentReader and writes it to file at filePathOnHost.
 // Set permission of this file to 0744.
 func (c *SSHConnect) WriteExecutableFileTo(host string, contentReader io.Reader, filePathOnHost string) error {
-	return c.writeFileTo(host, contentReader, filePathOnHost, "0744")
+	return c.writeFileWithMode(host, contentReader, filePathOnHost, executableFileMode)
 }
 
-func (c *SSHConnect) writeFileTo(host string, contentReader io.Reader, filePathOnHost string, filePermission string) error {
+func (c *SSHConnect) writeFileWithMode(host string, contentReader io.Reader, filePathOnHost string, mode string) error {
+	content, err := ioutil.ReadAll(contentReader)
+	if err != nil {
+		return fmt.Errorf("Error while reading content of '%s': %s", filePathOnHost, err)
+	}
+	return c.writeFileTo(host, content, fileUpload{path: filePathOnHost, mode: mode}, 0)
+}
 
-	client := scp.NewClient(fmt.Sprintf("%s:22", host), &c.sshConfig)
-	err := client.Connect()
+func (c *SSHConnect) copyFile(host string, contentReader io.Reader, filePathOnHost string, mode string, timeout time.Duration) error {
+	session, err := c.connect(host)
 	if err != nil {
 		return err
 	}
-	defer client.Close()
+	defer session.Close()
 
-	return client.CopyFile(contentReader, filePathOnHost, "0655")
+	// The SCP client runs on a session of the shared connection. Its Close
+	// isn't called, as it would close the connection as well.
+	client := scp.NewClient(c.settings.Address(host), c.clientConfig(host))
+	client.Session = session
+	if timeout > 0 {
+		client.Timeout = timeout
+	}
+	return client.CopyFile(contentReader, filePathOnHost, mode)
 }
@@ -1,14 +1,31 @@
 package sshconnect
 
 import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
+	"path"
+	"sync"
 	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
 )
 
 type SSHOperationsMock struct {
 	WrittenReadOnlyFiles []ReadOnlyFiles
 	RunCmdsCommands      []Command
+	RunCmdCommands       []Command
+	RunCmdResults        map[string]string
+	RunCmdErrors         map[string]error
+	Closed               bool
+	mutex                sync.Mutex
 	SSHOperations
 }
 
@@ -18,10 +35,23 @@ type ReadOnlyFiles struct {
 }
 
 func NewSSHOperationsMock() *SSHOperationsMock {
-	return &SSHOperationsMock{}
+	return &SSHOperationsMock{
+		RunCmdResults: make(map[string]string),
+		RunCmdErrors:  make(map[string]error)}
+}
+
+// RunCmd records the command and returns the result and error registered for its
+// description in RunCmdResults and RunCmdErrors.
+func (s *SSHOperationsMock) RunCmd(command Command, logOutput bool) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.RunCmdCommands = append(s.RunCmdCommands, command)
+	return s.RunCmdResults[command.GetDescription()], s.RunCmdErrors[command.GetDescription()]
 }
 
 func (s *SSHOperationsMock) RunCmds(commands *Commands) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	for _, command := range commands.Commands {
 		s.RunCmdsCommands = append(s.RunCmdsCommands, command)
 	}
@@ -29,6 +59,8 @@ func (s *SSHOperationsMock) RunCmds(commands *Commands) error {
 }
 
 func (s *SSHOperationsMock) WriteReadOnlyFileTo(host string, contentReader io.Reader, filePathOnHost string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 	s.WrittenReadOnlyFiles = append(s.WrittenReadOnlyFiles, ReadOnlyFiles{Host: host, FilePathOnHost: filePathOnHost})
 	return nil
 }
@@ -37,6 +69,12 @@ func (s *SSHOperationsMock) WriteExecutableFileTo(host string, contentReader io.
 	return fmt.Errorf("Not implemented")
 }
 
+// Close records that the mock was closed.
+func (s *SSHOperationsMock) Close() error {
+	s.Closed = true
+	return nil
+}
+
 func EnsureNoCommandsIssued(issuedCommands []Command, host string, t *testing.T) {
 	for _, issuedCommand := range issuedCommands {
 		if issuedCommand.GetHost() == host {
@@ -61,3 +99,265 @@ func EnsureCommandNotIssued(issuedCommands []Command, commandDescription string,
 		}
 	}
 }
+
+// TestCommandHandler runs command on a TestSSHServer and returns its exit
+// status. closed is closed once the client closed the session.
+type TestCommandHandler func(command string, stdout io.Writer, stderr io.Writer, closed <-chan struct{}) int
+
+// TestSSHServer is an SSH server on localhost for tests of SSHConnect. It
+// accepts every client key and runs commands with its TestCommandHandler.
+// Signals are ignored, like OpenSSH before 8.1 does.
+type TestSSHServer struct {
+	Port        int
+	listener    net.Listener
+	config      *ssh.ServerConfig
+	handle      TestCommandHandler
+	connections []*ssh.ServerConn
+	accepted    int
+	commands    []string
+	mutex       sync.Mutex
+}
+
+// NewTestSSHServer starts a TestSSHServer. Close it when done.
+func NewTestSSHServer(t *testing.T, handle TestCommandHandler) *TestSSHServer {
+	hostKey, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) { return nil, nil }}
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &TestSSHServer{
+		Port:     listener.Addr().(*net.TCPAddr).Port,
+		listener: listener,
+		config:   config,
+		handle:   handle}
+	go server.serve()
+	return server
+}
+
+// Accepted returns the number of connections accepted so far.
+func (s *TestSSHServer) Accepted() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.accepted
+}
+
+// Open returns the number of connections not closed yet.
+func (s *TestSSHServer) Open() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.connections)
+}
+
+// Commands returns all commands run in the order they were started.
+func (s *TestSSHServer) Commands() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return append([]string{}, s.commands...)
+}
+
+// Drop closes all open connections, as if the network failed.
+func (s *TestSSHServer) Drop() {
+	s.mutex.Lock()
+	connections := append([]*ssh.ServerConn{}, s.connections...)
+	s.mutex.Unlock()
+	for _, connection := range connections {
+		connection.Close()
+	}
+	s.waitUntil(func() bool { return len(s.connections) == 0 })
+}
+
+// WaitUntilClosed waits until all connections are closed by clients. Returns
+// 'false' if they aren't closed within a few seconds.
+func (s *TestSSHServer) WaitUntilClosed() bool {
+	return s.waitUntil(func() bool { return len(s.connections) == 0 })
+}
+
+// Close stops accepting connections and closes all open ones.
+func (s *TestSSHServer) Close() {
+	s.listener.Close()
+	s.Drop()
+}
+
+func (s *TestSSHServer) waitUntil(condition func() bool) bool {
+	for i := 0; i < 500; i++ {
+		s.mutex.Lock()
+		done := condition()
+		s.mutex.Unlock()
+		if done {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func (s *TestSSHServer) serve() {
+	for {
+		connection, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.serveConnection(connection)
+	}
+}
+
+func (s *TestSSHServer) serveConnection(connection net.Conn) {
+	serverConnection, channels, requests, err := ssh.NewServerConn(connection, s.config)
+	if err != nil {
+		connection.Close()
+		return
+	}
+	s.mutex.Lock()
+	s.accepted++
+	s.connections = append(s.connections, serverConnection)
+	s.mutex.Unlock()
+
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveSession(channel, channelRequests)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i, open := range s.connections {
+		if open == serverConnection {
+			s.connections = append(s.connections[:i], s.connections[i+1:]...)
+			break
+		}
+	}
+}
+
+// serveSession runs the command of the first exec request. The session is
+// closed once the command completed.
+func (s *TestSSHServer) serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	closed := make(chan struct{})
+	exited := make(chan struct{})
+	for request := range requests {
+		if request.Type != "exec" || len(request.Payload) < 4 {
+			request.Reply(false, nil)
+			continue
+		}
+		request.Reply(true, nil)
+		command := string(request.Payload[4:])
+		s.mutex.Lock()
+		s.commands = append(s.commands, command)
+		s.mutex.Unlock()
+
+		go func() {
+			defer close(exited)
+			exitStatus := s.handle(command, channel, channel.Stderr(), closed)
+			status := make([]byte, 4)
+			binary.BigEndian.PutUint32(status, uint32(exitStatus))
+			channel.SendRequest("exit-status", false, status)
+			channel.Close()
+		}()
+	}
+	close(closed)
+}
+
+// TestSSHConnect is an SSHConnect connecting to a TestSSHServer. Delays
+// between retries are recorded in Sleeps instead of waited for. Files are
+// recorded in Uploads instead of copied, as TestSSHServer doesn't run scp.
+type TestSSHConnect struct {
+	*SSHConnect
+	Sleeps  []time.Duration
+	Uploads []TestUpload
+	mutex   sync.Mutex
+}
+
+// TestUpload is a file copied to a host by a TestSSHConnect.
+type TestUpload struct {
+	Host    string
+	Path    string
+	Mode    string
+	Content string
+}
+
+// NewTestSSHConnect creates a TestSSHConnect with settings, which must have
+// the port of a TestSSHServer. The SSH handshake times out after timeout.
+// Known hosts and run logs are written to a temporary directory.
+func NewTestSSHConnect(t *testing.T, settings *Settings, timeout time.Duration) *TestSSHConnect {
+	key, err := newTestSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "SSHConnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	knownHosts := NewKnownHosts(path.Join(dir, "known_hosts"))
+	testConnect := &TestSSHConnect{}
+	testConnect.SSHConnect = &SSHConnect{
+		ctx: context.Background(),
+		sshConfig: ssh.ClientConfig{
+			User:            settings.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(key)},
+			HostKeyCallback: knownHosts.HostKeyCallback(),
+			Timeout:         timeout},
+		settings:   settings,
+		knownHosts: knownHosts,
+		runLog:     NewRunLog(dir, time.Now()),
+		clients:    make(map[string]*pooledClient),
+		proxyJumps: make(map[string][]endpoint),
+		sleep: func(ctx context.Context, delay time.Duration) error {
+			testConnect.mutex.Lock()
+			defer testConnect.mutex.Unlock()
+			testConnect.Sleeps = append(testConnect.Sleeps, delay)
+			return ctx.Err()
+		},
+		copy: func(host string, contentReader io.Reader, filePathOnHost string, mode string, timeout time.Duration) error {
+			content, err := ioutil.ReadAll(contentReader)
+			if err != nil {
+				return err
+			}
+			testConnect.mutex.Lock()
+			defer testConnect.mutex.Unlock()
+			testConnect.Uploads = append(testConnect.Uploads, TestUpload{Host: host, Path: filePathOnHost, Mode: mode, Content: string(content)})
+			return nil
+		}}
+	return testConnect
+}
+
+// SetContext stops commands once ctx is done, like the context passed to NewSSHConnectIn.
+func (c *TestSSHConnect) SetContext(ctx context.Context) {
+	c.ctx = ctx
+}
+
+// RunLogDir returns the directory commands run by c are logged to.
+func (c *TestSSHConnect) RunLogDir() string {
+	return c.runLog.Dir()
+}
+
+// Route returns the hops host is reached through, ending with host itself,
+// as user@host:port separated by commas.
+func (c *TestSSHConnect) Route(host string) (string, error) {
+	route, err := c.route(host)
+	if err != nil {
+		return "", err
+	}
+	return routeKey(route), nil
+}
+
+func newTestSigner() (ssh.Signer, error) {
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return ssh.NewSignerFromKey(privateKey)
+}
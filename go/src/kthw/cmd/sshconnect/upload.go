@@ -0,0 +1,119 @@
+package sshconnect
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultFileMode is the mode of uploaded files without explicit mode.
+	DefaultFileMode = "0644"
+	// PrivateKeyFileMode is the mode of uploaded private keys without explicit mode.
+	PrivateKeyFileMode = "0600"
+
+	readOnlyFileMode   = "0444"
+	executableFileMode = "0744"
+
+	// sudoFileOwner owns files uploaded with sudo without explicit owner.
+	sudoFileOwner = "root:root"
+)
+
+// fileUpload describes how a file is written on a host. An empty owner keeps
+// the owner of the uploaded file. Atomic uploads replace an existing file at
+// once, so that it is never read partially written or with the wrong mode.
+type fileUpload struct {
+	path   string
+	mode   string
+	owner  string
+	atomic bool
+}
+
+func (u fileUpload) String() string {
+	settings := []string{fmt.Sprintf("mode %s", u.mode)}
+	if u.owner != "" {
+		settings = append(settings, fmt.Sprintf("owner %s", u.owner))
+	}
+	if u.atomic {
+		settings = append(settings, "atomic")
+	}
+	return strings.Join(settings, ", ")
+}
+
+// uploadOf returns how the file of command with content is written. Files
+// without explicit mode are written with PrivateKeyFileMode if they contain a
+// private key and with DefaultFileMode otherwise.
+func uploadOf(command *CopyFileCommand, content []byte) fileUpload {
+	mode := command.Mode
+	if mode == "" && isPrivateKey(content) {
+		mode = PrivateKeyFileMode
+	} else if mode == "" {
+		mode = DefaultFileMode
+	}
+	return fileUpload{path: command.FilePath, mode: mode, owner: command.Owner, atomic: command.Atomic}
+}
+
+func isPrivateKey(content []byte) bool {
+	return strings.Contains(string(content), "PRIVATE KEY")
+}
+
+// uploadCounter makes names of temporary files unique, as files are uploaded
+// to several hosts concurrently.
+var uploadCounter uint64
+
+func tempFilePath(dir string, name string) string {
+	return path.Join(dir, fmt.Sprintf(".%s.kthw-%d-%d", name, time.Now().UnixNano(), atomic.AddUint64(&uploadCounter, 1)))
+}
+
+// writeFileTo writes content to a file on host as described by upload.
+// With sudo, the user can't write to most destinations. The file is uploaded
+// to /tmp first and then moved to its path and owned by root using sudo.
+// Atomic uploads are written to a temporary file in the directory of the
+// file, which is renamed once its mode and owner are set.
+func (c *SSHConnect) writeFileTo(host string, content []byte, upload fileUpload, timeout time.Duration) error {
+	_, err := strconv.ParseUint(upload.mode, 8, 32)
+	if err != nil {
+		return fmt.Errorf("Invalid mode '%s' of file '%s'", upload.mode, upload.path)
+	}
+	if upload.owner == "" && c.settings.Sudo {
+		upload.owner = sudoFileOwner
+	}
+
+	uploadPath := upload.path
+	if c.settings.Sudo {
+		uploadPath = tempFilePath("/tmp", "upload")
+	} else if upload.atomic {
+		uploadPath = tempFilePath(path.Dir(upload.path), path.Base(upload.path))
+	}
+	err = c.copy(host, bytes.NewReader(content), uploadPath, upload.mode, timeout)
+	if err != nil {
+		return err
+	}
+
+	// scp only sets the mode of new files.
+	commands := []string{fmt.Sprintf("chmod %s %s", upload.mode, shellQuote(uploadPath))}
+	if upload.owner != "" {
+		commands = append(commands, fmt.Sprintf("chown %s %s", shellQuote(upload.owner), shellQuote(uploadPath)))
+	}
+	if uploadPath != upload.path && c.settings.Sudo && upload.atomic {
+		// Files are only renamed atomically within the same file system.
+		sameDirPath := tempFilePath(path.Dir(upload.path), path.Base(upload.path))
+		commands = append(commands, fmt.Sprintf("mv %s %s", shellQuote(uploadPath), shellQuote(sameDirPath)))
+		uploadPath = sameDirPath
+	}
+	if uploadPath != upload.path {
+		commands = append(commands, fmt.Sprintf("mv -f %s %s", shellQuote(uploadPath), shellQuote(upload.path)))
+	}
+
+	discard := outputSink{Stdout: ioutil.Discard, Stderr: ioutil.Discard}
+	_, err = c.runCmd(host, strings.Join(commands, " && "), timeout, discard)
+	if err != nil && uploadPath != upload.path {
+		c.runCmd(host, fmt.Sprintf("rm -f %s", shellQuote(uploadPath)), timeout, discard)
+	}
+	return err
+}
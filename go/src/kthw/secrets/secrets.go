@@ -0,0 +1,261 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	// PassphraseEnv is the environment variable containing the passphrase used to seal secrets.
+	PassphraseEnv = "KTHW_PASSPHRASE"
+
+	confKeyFileKey = "secrets.keyFile"
+
+	sealedPrefix           = "sealed:"
+	sealedPassphrasePrefix = sealedPrefix + "scrypt:"
+	sealedKeyFilePrefix    = sealedPrefix + "key:"
+
+	keyLength   = 32
+	nonceLength = 24
+	saltLength  = 16
+
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Sealer encrypts and decrypts secrets using NaCl secretbox. The key is either
+// derived from a passphrase using scrypt or read from a key file.
+type Sealer struct {
+	passphrase []byte
+	key        *[keyLength]byte
+}
+
+// NewPassphraseSealer creates a Sealer deriving keys from passphrase. Every
+// sealed value uses its own random salt.
+func NewPassphraseSealer(passphrase string) (*Sealer, error) {
+	if passphrase == "" {
+		return nil, fmt.Errorf("Passphrase must not be empty")
+	}
+	return &Sealer{passphrase: []byte(passphrase)}, nil
+}
+
+// NewKeyFileSealer creates a Sealer using the base64 encoded key stored in keyFile.
+func NewKeyFileSealer(keyFile string) (*Sealer, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading key file '%s': %s", keyFile, err)
+	}
+	keyBytes, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil || len(keyBytes) != keyLength {
+		return nil, fmt.Errorf("Key file '%s' doesn't contain a base64 encoded key of %d bytes", keyFile, keyLength)
+	}
+	key := new([keyLength]byte)
+	copy(key[:], keyBytes)
+	return &Sealer{key: key}, nil
+}
+
+// GenerateKeyFile writes a new random key to keyFile. An existing file is not overwritten.
+func GenerateKeyFile(keyFile string) error {
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("Could not write key to already existing file %s", keyFile)
+	}
+	key := make([]byte, keyLength)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return fmt.Errorf("Error generating key: %s", err)
+	}
+	return ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(key)+"\n"), 0600)
+}
+
+// ReadSealer creates a Sealer from the passphrase in environment variable
+// KTHW_PASSPHRASE or from the key file configured at secrets.keyFile. The
+// passphrase takes precedence. Returns nil if neither is configured.
+func ReadSealer() (*Sealer, error) {
+	return ReadSealerIn(viper.GetViper())
+}
+
+// ReadSealerIn creates a Sealer like ReadSealer with the key file configured in config.
+func ReadSealerIn(config *viper.Viper) (*Sealer, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return NewPassphraseSealer(passphrase)
+	}
+	if keyFile := config.GetString(confKeyFileKey); keyFile != "" {
+		return NewKeyFileSealer(keyFile)
+	}
+	return nil, nil
+}
+
+// SetKeyFile configures the key file used to seal secrets. Changes are not persisted.
+func SetKeyFile(keyFile string) {
+	viper.Set(confKeyFileKey, keyFile)
+}
+
+// IsSealed returns 'true' if value was sealed by a Sealer.
+func IsSealed(value []byte) bool {
+	return bytes.HasPrefix(value, []byte(sealedPrefix))
+}
+
+// Seal encrypts plaintext. The result is a printable string which can be
+// stored in project.yaml or in a file.
+func (s *Sealer) Seal(plaintext []byte) (string, error) {
+	var nonce [nonceLength]byte
+	_, err := io.ReadFull(rand.Reader, nonce[:])
+	if err != nil {
+		return "", fmt.Errorf("Error generating nonce: %s", err)
+	}
+
+	if s.key != nil {
+		box := secretbox.Seal(nonce[:], plaintext, &nonce, s.key)
+		return sealedKeyFilePrefix + base64.StdEncoding.EncodeToString(box), nil
+	}
+
+	salt := make([]byte, saltLength)
+	_, err = io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return "", fmt.Errorf("Error generating salt: %s", err)
+	}
+	key, err := s.deriveKey(salt)
+	if err != nil {
+		return "", err
+	}
+	box := secretbox.Seal(append(salt, nonce[:]...), plaintext, &nonce, key)
+	return sealedPassphrasePrefix + base64.StdEncoding.EncodeToString(box), nil
+}
+
+// Open decrypts a value sealed by Seal.
+func (s *Sealer) Open(sealed []byte) ([]byte, error) {
+	var key *[keyLength]byte
+	var encoded string
+
+	switch {
+	case bytes.HasPrefix(sealed, []byte(sealedKeyFilePrefix)):
+		if s.key == nil {
+			return nil, fmt.Errorf("Secret was sealed with a key file, but a passphrase is configured")
+		}
+		key = s.key
+		encoded = strings.TrimPrefix(string(sealed), sealedKeyFilePrefix)
+	case bytes.HasPrefix(sealed, []byte(sealedPassphrasePrefix)):
+		if s.key != nil {
+			return nil, fmt.Errorf("Secret was sealed with a passphrase, but a key file is configured")
+		}
+		encoded = strings.TrimPrefix(string(sealed), sealedPassphrasePrefix)
+	default:
+		return nil, fmt.Errorf("Value is not sealed")
+	}
+
+	box, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("Error decoding sealed secret: %s", err)
+	}
+
+	if key == nil {
+		if len(box) < saltLength {
+			return nil, fmt.Errorf("Sealed secret is too short")
+		}
+		key, err = s.deriveKey(box[:saltLength])
+		if err != nil {
+			return nil, err
+		}
+		box = box[saltLength:]
+	}
+
+	if len(box) < nonceLength {
+		return nil, fmt.Errorf("Sealed secret is too short")
+	}
+	var nonce [nonceLength]byte
+	copy(nonce[:], box[:nonceLength])
+	plaintext, ok := secretbox.Open(nil, box[nonceLength:], &nonce, key)
+	if !ok {
+		return nil, fmt.Errorf("Could not open sealed secret. Passphrase or key file is wrong")
+	}
+	return plaintext, nil
+}
+
+func (s *Sealer) deriveKey(salt []byte) (*[keyLength]byte, error) {
+	keyBytes, err := scrypt.Key(s.passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, fmt.Errorf("Error deriving key from passphrase: %s", err)
+	}
+	key := new([keyLength]byte)
+	copy(key[:], keyBytes)
+	return key, nil
+}
+
+// GetSealed reads the config value of key and opens it if it is sealed. Values
+// stored in plain text are returned as they are. Sealed values are returned
+// unopened if no Sealer is configured.
+func GetSealed(key string) (string, error) {
+	return OpenSealedIn(viper.GetViper(), key, viper.GetString(key))
+}
+
+// OpenSealedIn opens value of key in config like GetSealed, if value was read
+// from config already.
+func OpenSealedIn(config *viper.Viper, key string, value string) (string, error) {
+	if !IsSealed([]byte(value)) {
+		return value, nil
+	}
+
+	sealer, err := ReadSealerIn(config)
+	if err != nil {
+		return "", err
+	}
+	if sealer == nil {
+		return value, nil
+	}
+
+	plaintext, err := sealer.Open([]byte(value))
+	if err != nil {
+		return "", fmt.Errorf("Error opening '%s': %s", key, err)
+	}
+	return string(plaintext), nil
+}
+
+// SetSealed seals value and sets it as config value of key. Without a
+// configured Sealer, value is stored in plain text. A sealed value already
+// stored at key is kept if it contains value. Changes are not persisted.
+func SetSealed(key string, value string) error {
+	return SetSealedIn(viper.GetViper(), key, value)
+}
+
+// SetSealedIn seals value like SetSealed and sets it as value of key in config.
+func SetSealedIn(config *viper.Viper, key string, value string) error {
+	if value == "" || IsSealed([]byte(value)) {
+		config.Set(key, value)
+		return nil
+	}
+
+	sealer, err := ReadSealerIn(config)
+	if err != nil {
+		return err
+	}
+	if sealer == nil {
+		config.Set(key, value)
+		return nil
+	}
+
+	current := config.GetString(key)
+	if IsSealed([]byte(current)) {
+		plaintext, err := sealer.Open([]byte(current))
+		if err == nil && string(plaintext) == value {
+			return nil
+		}
+	}
+
+	sealed, err := sealer.Seal([]byte(value))
+	if err != nil {
+		return err
+	}
+	config.Set(key, sealed)
+	return nil
+}
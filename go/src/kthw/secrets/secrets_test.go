@@ -0,0 +1,133 @@
+package secrets_test
+
+import (
+	"io/ioutil"
+	"kthw/secrets"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestSealAndOpenWithPassphrase(t *testing.T) {
+	sealer, err := secrets.NewPassphraseSealer("correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sealed, err := sealer.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !secrets.IsSealed([]byte(sealed)) || strings.Contains(sealed, "secret") {
+		t.Errorf("Expected sealed value, but was '%s'", sealed)
+	}
+
+	plaintext, err := sealer.Open([]byte(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Expected 'secret', but opened '%s'", plaintext)
+	}
+
+	wrongSealer, _ := secrets.NewPassphraseSealer("wrong")
+	_, err = wrongSealer.Open([]byte(sealed))
+	if err == nil {
+		t.Errorf("Expected error opening a secret with the wrong passphrase")
+	}
+}
+
+func TestSealAndOpenWithKeyFile(t *testing.T) {
+	keyFile := helperTempKeyFile(t)
+
+	sealer, err := secrets.NewKeyFileSealer(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := sealer.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := sealer.Open([]byte(sealed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Errorf("Expected 'secret', but opened '%s'", plaintext)
+	}
+
+	passphraseSealer, _ := secrets.NewPassphraseSealer("irrelevant")
+	_, err = passphraseSealer.Open([]byte(sealed))
+	if err == nil {
+		t.Errorf("Expected error opening a secret sealed with a key file using a passphrase")
+	}
+}
+
+func TestGenerateKeyFileNotOverrideExistingFile(t *testing.T) {
+	keyFile := helperTempKeyFile(t)
+
+	err := secrets.GenerateKeyFile(keyFile)
+	if err == nil {
+		t.Errorf("Existing key file overridden")
+	}
+}
+
+func TestSetAndGetSealed(t *testing.T) {
+	viper.Reset()
+	os.Setenv(secrets.PassphraseEnv, "passphrase")
+	defer os.Unsetenv(secrets.PassphraseEnv)
+
+	err := secrets.SetSealed("secret", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed := viper.GetString("secret")
+	if !secrets.IsSealed([]byte(sealed)) {
+		t.Fatalf("Expected value in config to be sealed, but was '%s'", sealed)
+	}
+
+	err = secrets.SetSealed("secret", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viper.GetString("secret") != sealed {
+		t.Errorf("Sealed value changed, although value is the same")
+	}
+
+	value, err := secrets.GetSealed("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != "value" {
+		t.Errorf("Expected 'value', but was '%s'", value)
+	}
+}
+
+func TestSetSealedWithoutSealerStoresPlainText(t *testing.T) {
+	viper.Reset()
+	os.Unsetenv(secrets.PassphraseEnv)
+
+	err := secrets.SetSealed("secret", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if viper.GetString("secret") != "value" {
+		t.Errorf("Expected plain text value, but was '%s'", viper.GetString("secret"))
+	}
+}
+
+func helperTempKeyFile(t *testing.T) string {
+	tempDirName, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := path.Join(tempDirName, "project.key")
+	err = secrets.GenerateKeyFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile
+}